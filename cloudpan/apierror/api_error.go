@@ -42,6 +42,14 @@ const (
 	ApiCodeInvalidArgument = 18
 	// 敏感文件，禁止上传
 	ApiCodeInfoSecurityError = 19
+	// 批量任务执行失败
+	ApiCodeBatchTaskFailed = 20
+	// 批量任务存在同名文件冲突
+	ApiCodeBatchTaskConflict = 21
+	// 操作已取消或超时
+	ApiCodeCanceled = 22
+	// 分享次数达到每日上限
+	ApiCodeShareCreateOverload = 23
 )
 
 type ApiCode int
@@ -40,11 +40,17 @@ FlhDeqVOG094hFJvZeK4OzA6HVwzwnEW5vIZ7d+u61RV1bsFxmB68+8JXs3ycGcE
 	bi_rm = "0123456789abcdefghijklmnopqrstuvwxyz"
 
 	FileNameSpecialChars = "\\/:*?\"<>|"
+
+	// TimeLayout 云盘接口返回的时间格式
+	TimeLayout = "2006-01-02 15:04:05"
 )
 
 var (
 	// UUID for client sn
 	clientSn = strings.ToUpper(uuid.NewV4().String())
+
+	// 云盘接口返回的时间均为北京时间
+	beijingLocation = time.FixedZone("CST", 8*3600)
 )
 
 func init() {
@@ -170,4 +176,18 @@ func CheckFileNameValid(name string) bool {
 		return true
 	}
 	return !strings.ContainsAny(name, FileNameSpecialChars)
+}
+
+// ParseTimeStr 解析云盘接口返回的时间字符串，格式：2018-11-18 09:12:13，解析失败返回零值
+func ParseTimeStr(timeStr string) time.Time {
+	t, err := time.ParseInLocation(TimeLayout, strings.TrimSpace(timeStr), beijingLocation)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// FormatTime 将时间格式化为云盘接口使用的时间字符串
+func FormatTime(t time.Time) string {
+	return t.In(beijingLocation).Format(TimeLayout)
 }
\ No newline at end of file
@@ -0,0 +1,250 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"strings"
+	"time"
+)
+
+type (
+	// AppCrossCloudTransferParam 个人云和家庭云之间复制/移动文件的参数
+	AppCrossCloudTransferParam struct {
+		// SrcFamilyId 源文件所在的家庭云，<=0 为个人云
+		SrcFamilyId int64
+		// DstFamilyId 目标文件夹所在的家庭云，<=0 为个人云，源和目标必须一个是个人云一个是家庭云
+		DstFamilyId int64
+		// SrcPaths 源文件路径，支持通配符，文件夹会整个复制
+		SrcPaths []string
+		// DestPath 目标文件夹路径，不存在会自动创建
+		DestPath string
+		// RemoveSource 校验目标文件和源文件一致后删除源文件，即跨云移动
+		RemoveSource bool
+		// VerifyTimeout 等待目标文件全部出现的最长时间，默认5分钟
+		VerifyTimeout time.Duration
+		// WaitOption 轮询任务状态的参数，为 nil 使用默认参数
+		WaitOption *BatchTaskWaitOption
+	}
+
+	// AppCrossCloudTransferResult 跨云复制/移动的结果
+	AppCrossCloudTransferResult struct {
+		// TaskIdList 服务端返回的任务ID
+		TaskIdList []string
+		// SrcFileList 源文件
+		SrcFileList AppFileList
+		// Verified 目标文件是否已经全部校验通过
+		Verified bool
+		// SourceRemoved 源文件是否已经删除
+		SourceRemoved bool
+	}
+
+	// crossCloudNode 源文件的目录树，用于校验目标文件
+	crossCloudNode struct {
+		entity   *AppFileEntity
+		children []*crossCloudNode
+	}
+)
+
+const (
+	defaultCrossCloudVerifyTimeout = 5 * time.Minute
+)
+
+// AppCrossCloudTransfer 在个人云和家庭云之间复制文件或文件夹，服务端复制完成后逐个校验文件大小和MD5，
+// RemoveSource=true 时校验通过后删除源文件
+func (p *PanClient) AppCrossCloudTransfer(ctx context.Context, param *AppCrossCloudTransferParam) (*AppCrossCloudTransferResult, *apierror.ApiError) {
+	if (param.SrcFamilyId > 0) == (param.DstFamilyId > 0) {
+		return nil, apierror.NewFailedApiError("只支持个人云和家庭云之间的复制")
+	}
+	familyId := param.SrcFamilyId
+	if familyId <= 0 {
+		familyId = param.DstFamilyId
+	}
+
+	srcList, apiErr := p.AppMatchPathByShellPattern(param.SrcFamilyId, param.SrcPaths...)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	result := &AppCrossCloudTransferResult{SrcFileList: srcList}
+	if len(srcList) == 0 {
+		result.Verified = true
+		return result, nil
+	}
+	dest, apiErr := p.AppMkdirAll(param.DstFamilyId, param.DestPath)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	destFolder := &AppFileEntity{FileId: dest.FileId, IsFolder: true}
+
+	// 目标文件夹已经存在同名文件时，无法区分复制结果
+	destChildren, apiErr := p.appListFolder(param.DstFamilyId, destFolder)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	for _, fe := range destChildren {
+		if srcList.findByName(fe.FileName) != nil {
+			return nil, apierror.NewApiError(apierror.ApiCodeFileAlreadyExisted, "目标文件夹已存在同名文件: "+fe.FileName)
+		}
+	}
+
+	// 复制前记录源文件的目录树
+	srcListFunc := func(folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+		return p.appListFolder(param.SrcFamilyId, folder)
+	}
+	trees := []*crossCloudNode{}
+	for _, fe := range srcList {
+		node, apiErr := loadCrossCloudTree(fe, srcListFunc)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		trees = append(trees, node)
+	}
+
+	for start := 0; start < len(srcList); start += BatchTaskMaxFileCount {
+		end := start + BatchTaskMaxFileCount
+		if end > len(srcList) {
+			end = len(srcList)
+		}
+		fileIdList := []string{}
+		for _, fe := range srcList[start:end] {
+			fileIdList = append(fileIdList, fe.FileId)
+		}
+		var taskId string
+		if param.SrcFamilyId > 0 {
+			taskId, apiErr = p.AppFamilySaveFileToPersonCloudDir(familyId, fileIdList, dest.FileId)
+		} else {
+			taskId, apiErr = p.AppSaveFileToFamilyCloudDir(familyId, fileIdList, dest.FileId)
+		}
+		if apiErr != nil {
+			return result, apiErr
+		}
+		result.TaskIdList = append(result.TaskIdList, taskId)
+		if _, apiErr := p.WaitBatchTask(ctx, familyId, BatchTaskTypeCopy, taskId, param.WaitOption); apiErr != nil {
+			switch apiErr.Code {
+			case apierror.ApiCodeBatchTaskFailed, apierror.ApiCodeBatchTaskConflict, apierror.ApiCodeCanceled:
+				return result, apiErr
+			}
+			// 查询不到任务状态时通过校验目标文件判断是否完成
+			logger.Verboseln("wait cross cloud task failed: ", apiErr)
+		}
+	}
+
+	// 服务端复制文件夹是异步的，轮询直到目标文件全部出现
+	dstListFunc := func(folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+		return p.appListFolder(param.DstFamilyId, folder)
+	}
+	timeout := param.VerifyTimeout
+	if timeout <= 0 {
+		timeout = defaultCrossCloudVerifyTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	interval := time.Second
+	for {
+		ok, apiErr := verifyCrossCloudTrees(trees, destFolder, dstListFunc)
+		if apiErr != nil {
+			return result, apiErr
+		}
+		if ok {
+			result.Verified = true
+			break
+		}
+		if time.Now().After(deadline) {
+			return result, apierror.NewFailedApiError("等待目标文件超时，目标文件和源文件不一致")
+		}
+		select {
+		case <-ctx.Done():
+			return result, apierror.NewApiError(apierror.ApiCodeCanceled, ctx.Err().Error())
+		case <-time.After(interval):
+		}
+		if interval < 10*time.Second {
+			interval *= 2
+		}
+	}
+
+	if param.RemoveSource {
+		if apiErr := p.AppDeleteFileByFamily(param.SrcFamilyId, srcList); apiErr != nil {
+			return result, apiErr
+		}
+		result.SourceRemoved = true
+	}
+	return result, nil
+}
+
+// appListFolder 获取文件夹下的所有文件
+func (p *PanClient) appListFolder(familyId int64, folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+	param := NewAppFileListParam()
+	param.FamilyId = familyId
+	param.FileId = folder.FileId
+	r, apiErr := p.AppGetAllFileList(param)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return r.FileList, nil
+}
+
+func (afl AppFileList) findByName(name string) *AppFileEntity {
+	for _, fe := range afl {
+		if fe.FileName == name {
+			return fe
+		}
+	}
+	return nil
+}
+
+func loadCrossCloudTree(fe *AppFileEntity, list listFolderFunc) (*crossCloudNode, *apierror.ApiError) {
+	node := &crossCloudNode{entity: fe}
+	if !fe.IsFolder {
+		return node, nil
+	}
+	children, apiErr := list(fe)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	for _, child := range children {
+		childNode, apiErr := loadCrossCloudTree(child, list)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		node.children = append(node.children, childNode)
+	}
+	return node, nil
+}
+
+// verifyCrossCloudTrees 校验目标文件夹中存在和源文件同名、同大小、同MD5的文件，文件夹递归校验
+func verifyCrossCloudTrees(trees []*crossCloudNode, destFolder *AppFileEntity, list listFolderFunc) (bool, *apierror.ApiError) {
+	destChildren, apiErr := list(destFolder)
+	if apiErr != nil {
+		return false, apiErr
+	}
+	for _, node := range trees {
+		dst := destChildren.findByName(node.entity.FileName)
+		if dst == nil || dst.IsFolder != node.entity.IsFolder {
+			return false, nil
+		}
+		if !node.entity.IsFolder {
+			if dst.FileSize != node.entity.FileSize || !strings.EqualFold(dst.FileMd5, node.entity.FileMd5) {
+				return false, nil
+			}
+			continue
+		}
+		ok, apiErr := verifyCrossCloudTrees(node.children, dst, list)
+		if !ok || apiErr != nil {
+			return ok, apiErr
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyCrossCloudTrees(t *testing.T) {
+	tree := map[string]AppFileList{
+		// 源文件
+		"s1": {{FileId: "s2", FileName: "a.txt", FileSize: 1, FileMd5: "AA"}},
+		// 目标文件夹
+		"d0": {{FileId: "d1", FileName: "docs", IsFolder: true}},
+		"d1": {{FileId: "d2", FileName: "a.txt", FileSize: 1, FileMd5: "aa"}},
+	}
+	list := func(folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+		return tree[folder.FileId], nil
+	}
+	node, err := loadCrossCloudTree(&AppFileEntity{FileId: "s1", FileName: "docs", IsFolder: true}, list)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(node.children))
+
+	dest := &AppFileEntity{FileId: "d0", IsFolder: true}
+	ok, err := verifyCrossCloudTrees([]*crossCloudNode{node}, dest, list)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 目标文件还没有复制完成
+	tree["d1"] = AppFileList{}
+	ok, _ = verifyCrossCloudTrees([]*crossCloudNode{node}, dest, list)
+	assert.False(t, ok)
+
+	// 目标文件MD5不一致
+	tree["d1"] = AppFileList{{FileId: "d2", FileName: "a.txt", FileSize: 1, FileMd5: "BB"}}
+	ok, _ = verifyCrossCloudTrees([]*crossCloudNode{node}, dest, list)
+	assert.False(t, ok)
+}
+
+func TestParseCrossCloudSaveTaskId(t *testing.T) {
+	taskId, apiErr := parseCrossCloudSaveTaskId([]byte("<shareFileToFamily><taskId>123</taskId></shareFileToFamily>"), "复制出错")
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "123", taskId)
+
+	// 响应无法解析或者没有任务ID时不能当作提交成功
+	_, apiErr = parseCrossCloudSaveTaskId([]byte("not xml"), "复制出错")
+	assert.NotNil(t, apiErr)
+	_, apiErr = parseCrossCloudSaveTaskId([]byte("<shareFileToFamily></shareFileToFamily>"), "复制出错")
+	assert.NotNil(t, apiErr)
+}
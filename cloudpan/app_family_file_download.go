@@ -15,12 +15,12 @@
 package cloudpan
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"github.com/phpc0de/ctapi/cloudpan/apierror"
 	"github.com/phpc0de/ctapi/cloudpan/apiutil"
 	"github.com/phpc0de/ctlibgo/logger"
-	"strconv"
 	"strings"
 )
 
@@ -58,7 +58,19 @@ func (p *PanClient) AppFamilyGetFileDownloadUrl(familyId int64, fileId string) (
 	return strings.ReplaceAll(item.FileDownloadUrl, "&amp;", "&"), nil
 }
 
+// AppFamilyDownloadFileData 下载家庭云文件数据，受 PanClient 的限速控制，见 AppDownloadFileDataContext
 func (p *PanClient) AppFamilyDownloadFileData(downloadFileUrl string, fileRange AppFileDownloadRange, downloadFunc DownloadFuncCallback) *apierror.ApiError {
+	return p.AppFamilyDownloadFileDataContext(context.Background(), downloadFileUrl, fileRange, downloadFunc)
+}
+
+// AppFamilyDownloadFileDataContext 下载家庭云文件数据，限速和 AppDownloadFileDataContext 一致
+func (p *PanClient) AppFamilyDownloadFileDataContext(ctx context.Context, downloadFileUrl string, fileRange AppFileDownloadRange, downloadFunc DownloadFuncCallback) *apierror.ApiError {
+	fullUrl, headers := p.appFamilyDownloadRequest(downloadFileUrl, fileRange)
+	return p.handleThrottledDownload(ctx, "GET", fullUrl, headers, downloadFunc)
+}
+
+// appFamilyDownloadRequest 下载家庭云文件数据的请求地址和请求头
+func (p *PanClient) appFamilyDownloadRequest(downloadFileUrl string, fileRange AppFileDownloadRange) (string, map[string]string) {
 	fullUrl := &strings.Builder{}
 
 	fmt.Fprintf(fullUrl, "%s&%s",
@@ -77,19 +89,9 @@ func (p *PanClient) AppFamilyDownloadFileData(downloadFileUrl string, fileRange
 	}
 
 	// 支持断点续传
-	if fileRange.Offset != 0 || fileRange.End != 0 {
-		rangeStr := "bytes=" + strconv.FormatInt(fileRange.Offset, 10) + "-"
-		if fileRange.End != 0 {
-			rangeStr += strconv.FormatInt(fileRange.End, 10)
-		}
+	if rangeStr := fileRange.rangeHeader(); rangeStr != "" {
 		headers["range"] = rangeStr
 	}
 	logger.Verboseln("do request url: " + fullUrl.String())
-	_, err := downloadFunc(httpMethod, fullUrl.String(), headers)
-	//resp, err := p.client.Req(httpMethod, fullUrl.String(), nil, headers)
-	if err != nil {
-		logger.Verboseln("AppDownloadFileData response failed")
-		return apierror.NewApiErrorWithError(err)
-	}
-	return nil
-}
\ No newline at end of file
+	return fullUrl.String(), headers
+}
@@ -15,6 +15,7 @@
 package cloudpan
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"github.com/phpc0de/ctapi/cloudpan/apierror"
@@ -69,7 +70,13 @@ func (p *PanClient) AppFamilyCreateUploadFile(param *AppCreateUploadFileParam) (
 	return item, nil
 }
 
-func (p *PanClient) AppFamilyUploadFileData(familyId int64, uploadUrl, uploadFileId, xRequestId string, fileRange *AppFileUploadRange, uploadFunc UploadFunc) *apierror.ApiError {
+// AppFamilyUploadFileData 上传家庭云文件数据，受 PanClient 的限速控制，见 AppUploadFileDataContext
+func (p *PanClient) AppFamilyUploadFileData(familyId int64, uploadUrl, uploadFileId, xRequestId string, fileRange *AppFileUploadRange) *apierror.ApiError {
+	return p.AppFamilyUploadFileDataContext(context.Background(), familyId, uploadUrl, uploadFileId, xRequestId, fileRange)
+}
+
+// AppFamilyUploadFileDataContext 上传家庭云文件数据，限速和 AppUploadFileDataContext 一致
+func (p *PanClient) AppFamilyUploadFileDataContext(ctx context.Context, familyId int64, uploadUrl, uploadFileId, xRequestId string, fileRange *AppFileUploadRange) *apierror.ApiError {
 	fullUrl := uploadUrl + "?" + apiutil.PcClientInfoSuffixParam()
 	httpMethod := "PUT"
 	dateOfGmt := apiutil.DateOfGmtStr()
@@ -91,7 +98,7 @@ func (p *PanClient) AppFamilyUploadFileData(familyId int64, uploadUrl, uploadFil
 	}
 
 	logger.Verboseln("do request url: " + fullUrl)
-	resp, err1 := uploadFunc(httpMethod, fullUrl, headers)
+	resp, err1 := p.sendThrottledUploadData(ctx, httpMethod, fullUrl, headers, fileRange)
 	if err1 != nil {
 		logger.Verboseln("AppUploadFileData occurs error: ", err1.Error())
 		return apierror.NewApiErrorWithError(err1)
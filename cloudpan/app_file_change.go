@@ -0,0 +1,302 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// AppFileChangeType 文件变更类型
+	AppFileChangeType string
+
+	// AppSnapshotEntry 快照中的文件/文件夹信息
+	AppSnapshotEntry struct {
+		// FileId 文件ID
+		FileId string `json:"fileId"`
+		// ParentId 父文件夹ID
+		ParentId string `json:"parentId"`
+		// FileName 名称
+		FileName string `json:"fileName"`
+		// Path 文件完整路径
+		Path string `json:"path"`
+		// IsFolder 是否是文件夹
+		IsFolder bool `json:"isFolder"`
+		// FileSize 文件大小
+		FileSize int64 `json:"fileSize"`
+		// FileMd5 文件MD5
+		FileMd5 string `json:"fileMd5"`
+		// LastOpTime 最后修改时间
+		LastOpTime string `json:"lastOpTime"`
+		// Rev 版本号
+		Rev string `json:"rev"`
+	}
+
+	// AppSnapshotFolder 快照中的文件夹，记录文件夹的版本号以及子文件ID列表
+	AppSnapshotFolder struct {
+		// FileId 文件夹ID
+		FileId string `json:"fileId"`
+		// Rev 文件夹版本号，版本号不变则不需要重新获取子文件列表
+		Rev string `json:"rev"`
+		// ChildIdList 子文件/文件夹ID列表
+		ChildIdList []string `json:"childIdList"`
+	}
+
+	// AppFileSnapshot 目录树快照
+	AppFileSnapshot struct {
+		// FamilyId 家庭云ID，个人云为0
+		FamilyId int64 `json:"familyId"`
+		// RootFileId 快照根目录ID
+		RootFileId string `json:"rootFileId"`
+		// RootPath 快照根目录路径
+		RootPath string `json:"rootPath"`
+		// SnapshotTime 快照时间
+		SnapshotTime time.Time `json:"snapshotTime"`
+		// Entries 文件ID -> 文件信息
+		Entries map[string]*AppSnapshotEntry `json:"entries"`
+		// Folders 文件夹ID -> 文件夹信息
+		Folders map[string]*AppSnapshotFolder `json:"folders"`
+	}
+
+	// AppFileChange 文件变更项
+	AppFileChange struct {
+		// Type 变更类型
+		Type AppFileChangeType `json:"type"`
+		// Old 变更前的文件信息，新建的文件为nil
+		Old *AppSnapshotEntry `json:"old"`
+		// New 变更后的文件信息，删除的文件为nil
+		New *AppSnapshotEntry `json:"new"`
+	}
+
+	AppFileChangeList []*AppFileChange
+
+	// snapshotSource 构建快照时获取文件夹版本号和文件列表
+	snapshotSource struct {
+		// folderRev 查询文件夹当前的版本号，无法查询时返回空
+		folderRev func(folderId string) string
+		listFolder func(folderId string) (*AppFileListResult, *apierror.ApiError)
+	}
+)
+
+const (
+	// AppFileChangeCreated 新建
+	AppFileChangeCreated AppFileChangeType = "created"
+	// AppFileChangeModified 内容修改
+	AppFileChangeModified AppFileChangeType = "modified"
+	// AppFileChangeDeleted 删除
+	AppFileChangeDeleted AppFileChangeType = "deleted"
+	// AppFileChangeRenamed 重命名
+	AppFileChangeRenamed AppFileChangeType = "renamed"
+	// AppFileChangeMoved 移动到其他文件夹，同时改名的也归为移动
+	AppFileChangeMoved AppFileChangeType = "moved"
+)
+
+func newAppSnapshotEntry(fe *AppFileEntity, parentPath string) *AppSnapshotEntry {
+	return &AppSnapshotEntry{
+		FileId: fe.FileId,
+		ParentId: fe.ParentId,
+		FileName: fe.FileName,
+		Path: path.Join(parentPath, fe.FileName),
+		IsFolder: fe.IsFolder,
+		FileSize: fe.FileSize,
+		FileMd5: fe.FileMd5,
+		LastOpTime: fe.LastOpTime,
+		Rev: fe.Rev,
+	}
+}
+
+// AppTakeFileSnapshot 获取指定目录的目录树快照，用于后续的变更检测
+func (p *PanClient) AppTakeFileSnapshot(familyId int64, pathStr string) (*AppFileSnapshot, *apierror.ApiError) {
+	rootInfo, err := p.AppFileInfoByPath(familyId, pathStr)
+	if err != nil {
+		return nil, err
+	}
+	if !rootInfo.IsFolder {
+		return nil, apierror.NewFailedApiError("快照路径必须是文件夹")
+	}
+	if pathStr == "" {
+		pathStr = "/"
+	}
+
+	snapshot := &AppFileSnapshot{
+		FamilyId: familyId,
+		RootFileId: rootInfo.FileId,
+		RootPath: path.Clean(pathStr),
+		SnapshotTime: time.Now(),
+		Entries: map[string]*AppSnapshotEntry{},
+		Folders: map[string]*AppSnapshotFolder{},
+	}
+	if err := refreshSnapshotFolder(p.newSnapshotSource(familyId), nil, snapshot, rootInfo.FileId, "", snapshot.RootPath); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// AppFileChangesSince 检测快照之后的文件变更，返回变更列表以及最新的快照。
+// 文件夹版本号没有变化的不会重新获取文件列表
+func (p *PanClient) AppFileChangesSince(snapshot *AppFileSnapshot) (AppFileChangeList, *AppFileSnapshot, *apierror.ApiError) {
+	if snapshot == nil {
+		return nil, nil, apierror.NewFailedApiError("快照为空")
+	}
+	newSnapshot := &AppFileSnapshot{
+		FamilyId: snapshot.FamilyId,
+		RootFileId: snapshot.RootFileId,
+		RootPath: snapshot.RootPath,
+		SnapshotTime: time.Now(),
+		Entries: map[string]*AppSnapshotEntry{},
+		Folders: map[string]*AppSnapshotFolder{},
+	}
+	if err := refreshSnapshotFolder(p.newSnapshotSource(snapshot.FamilyId), snapshot, newSnapshot, snapshot.RootFileId, "", snapshot.RootPath); err != nil {
+		return nil, nil, err
+	}
+	return DiffAppFileSnapshot(snapshot, newSnapshot), newSnapshot, nil
+}
+
+// refreshSnapshotFolder 递归构建文件夹快照。knownRev 为父文件夹列表中返回的版本号，为空时会查询文件夹当前的版本号，
+// 版本号和旧快照一致的只复用该文件夹自己的子文件列表，子文件夹仍然逐个检查版本号
+func refreshSnapshotFolder(src *snapshotSource, old, cur *AppFileSnapshot, folderId, knownRev, folderPath string) *apierror.ApiError {
+	rev := knownRev
+	if rev == "" {
+		rev = src.folderRev(folderId)
+	}
+
+	if old != nil && rev != "" {
+		if of, ok := old.Folders[folderId]; ok && of.Rev == rev {
+			// 版本号没有变化，复用旧快照的子文件列表
+			cur.Folders[folderId] = of
+			for _, childId := range of.ChildIdList {
+				oe, ok := old.Entries[childId]
+				if !ok {
+					continue
+				}
+				e := *oe
+				e.Path = path.Join(folderPath, e.FileName)
+				cur.Entries[childId] = &e
+				if e.IsFolder {
+					// 子文件夹的变化不一定会改变上级文件夹的版本号，需要查询子文件夹当前的版本号
+					if err := refreshSnapshotFolder(src, old, cur, e.FileId, "", e.Path); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	}
+
+	logger.Verboseln("snapshot list folder: " + folderPath)
+	r, err := src.listFolder(folderId)
+	if err != nil {
+		return err
+	}
+	if rev == "" {
+		rev = r.LastRev
+	}
+	folder := &AppSnapshotFolder{
+		FileId: folderId,
+		Rev: rev,
+		ChildIdList: []string{},
+	}
+	cur.Folders[folderId] = folder
+	for _, fe := range r.FileList {
+		e := newAppSnapshotEntry(fe, folderPath)
+		e.ParentId = folderId
+		cur.Entries[e.FileId] = e
+		folder.ChildIdList = append(folder.ChildIdList, e.FileId)
+	}
+	for _, fe := range r.FileList {
+		if !fe.IsFolder {
+			continue
+		}
+		if err := refreshSnapshotFolder(src, old, cur, fe.FileId, fe.Rev, path.Join(folderPath, fe.FileName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newSnapshotSource 通过接口获取文件夹版本号和文件列表
+func (p *PanClient) newSnapshotSource(familyId int64) *snapshotSource {
+	return &snapshotSource{
+		folderRev: func(folderId string) string {
+			// 家庭云根目录等无法查询文件夹信息的，直接获取文件列表
+			if fi, err := p.AppGetBasicFileInfo(&AppGetFileInfoParam{FamilyId: familyId, FileId: folderId}); err == nil {
+				return fi.Rev
+			}
+			return ""
+		},
+		listFolder: func(folderId string) (*AppFileListResult, *apierror.ApiError) {
+			time.Sleep(time.Duration(200) * time.Millisecond)
+			param := NewAppFileListParam()
+			param.FamilyId = familyId
+			param.FileId = folderId
+			return p.AppGetAllFileList(param)
+		},
+	}
+}
+
+// DiffAppFileSnapshot 比较两个快照，返回新快照相对于旧快照的变更列表，按路径排序
+func DiffAppFileSnapshot(old, cur *AppFileSnapshot) AppFileChangeList {
+	changes := AppFileChangeList{}
+	for id, ne := range cur.Entries {
+		oe, ok := old.Entries[id]
+		if !ok {
+			changes = append(changes, &AppFileChange{Type: AppFileChangeCreated, New: ne})
+			continue
+		}
+		if oe.ParentId != ne.ParentId {
+			changes = append(changes, &AppFileChange{Type: AppFileChangeMoved, Old: oe, New: ne})
+			continue
+		}
+		if oe.FileName != ne.FileName {
+			changes = append(changes, &AppFileChange{Type: AppFileChangeRenamed, Old: oe, New: ne})
+			continue
+		}
+		if !ne.IsFolder && (oe.FileSize != ne.FileSize || !strings.EqualFold(oe.FileMd5, ne.FileMd5) || oe.Rev != ne.Rev) {
+			changes = append(changes, &AppFileChange{Type: AppFileChangeModified, Old: oe, New: ne})
+		}
+	}
+	for id, oe := range old.Entries {
+		if _, ok := cur.Entries[id]; !ok {
+			changes = append(changes, &AppFileChange{Type: AppFileChangeDeleted, Old: oe})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].sortPath() < changes[j].sortPath()
+	})
+	return changes
+}
+
+func (c *AppFileChange) sortPath() string {
+	if c.New != nil {
+		return c.New.Path
+	}
+	return c.Old.Path
+}
+
+// Path 获取快照中文件ID对应的路径
+func (s *AppFileSnapshot) Path(fileId string) string {
+	if fileId == s.RootFileId {
+		return s.RootPath
+	}
+	if e, ok := s.Entries[fileId]; ok {
+		return e.Path
+	}
+	return ""
+}
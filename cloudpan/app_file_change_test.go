@@ -0,0 +1,119 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiffAppFileSnapshot(t *testing.T) {
+	old := &AppFileSnapshot{
+		Entries: map[string]*AppSnapshotEntry{
+			"1": {FileId: "1", ParentId: "-11", FileName: "a", Path: "/a", IsFolder: true},
+			"2": {FileId: "2", ParentId: "-11", FileName: "b", Path: "/b", IsFolder: true},
+			"3": {FileId: "3", ParentId: "1", FileName: "x.txt", Path: "/a/x.txt", FileSize: 1, FileMd5: "AA", Rev: "1"},
+			"4": {FileId: "4", ParentId: "1", FileName: "y.txt", Path: "/a/y.txt", FileSize: 1, FileMd5: "BB", Rev: "1"},
+			"5": {FileId: "5", ParentId: "1", FileName: "z.txt", Path: "/a/z.txt", FileSize: 1, FileMd5: "CC", Rev: "1"},
+			"6": {FileId: "6", ParentId: "2", FileName: "old.txt", Path: "/b/old.txt", FileSize: 1, FileMd5: "DD", Rev: "1"},
+		},
+	}
+	cur := &AppFileSnapshot{
+		Entries: map[string]*AppSnapshotEntry{
+			"1": {FileId: "1", ParentId: "-11", FileName: "a", Path: "/a", IsFolder: true},
+			"2": {FileId: "2", ParentId: "-11", FileName: "b", Path: "/b", IsFolder: true},
+			"3": {FileId: "3", ParentId: "1", FileName: "x.txt", Path: "/a/x.txt", FileSize: 2, FileMd5: "EE", Rev: "2"},
+			"4": {FileId: "4", ParentId: "1", FileName: "y2.txt", Path: "/a/y2.txt", FileSize: 1, FileMd5: "BB", Rev: "1"},
+			"5": {FileId: "5", ParentId: "2", FileName: "z.txt", Path: "/b/z.txt", FileSize: 1, FileMd5: "CC", Rev: "1"},
+			"7": {FileId: "7", ParentId: "2", FileName: "new.txt", Path: "/b/new.txt", FileSize: 1, FileMd5: "FF", Rev: "1"},
+		},
+	}
+
+	changes := DiffAppFileSnapshot(old, cur)
+	types := map[string]AppFileChangeType{}
+	for _, c := range changes {
+		types[c.sortPath()] = c.Type
+	}
+	assert.Equal(t, 5, len(changes))
+	assert.Equal(t, AppFileChangeModified, types["/a/x.txt"])
+	assert.Equal(t, AppFileChangeRenamed, types["/a/y2.txt"])
+	assert.Equal(t, AppFileChangeMoved, types["/b/z.txt"])
+	assert.Equal(t, AppFileChangeCreated, types["/b/new.txt"])
+	assert.Equal(t, AppFileChangeDeleted, types["/b/old.txt"])
+}
+
+func TestRefreshSnapshotFolderUnchanged(t *testing.T) {
+	old := &AppFileSnapshot{
+		RootFileId: "-11",
+		RootPath: "/",
+		Entries: map[string]*AppSnapshotEntry{
+			"1": {FileId: "1", ParentId: "-11", FileName: "a", Path: "/a", IsFolder: true, Rev: "r1"},
+			"2": {FileId: "2", ParentId: "1", FileName: "b", Path: "/a/b", IsFolder: true, Rev: "r2"},
+			"3": {FileId: "3", ParentId: "2", FileName: "x.txt", Path: "/a/b/x.txt", FileSize: 1, FileMd5: "AA", Rev: "1"},
+		},
+		Folders: map[string]*AppSnapshotFolder{
+			"-11": {FileId: "-11", Rev: "r0", ChildIdList: []string{"1"}},
+			"1": {FileId: "1", Rev: "r1", ChildIdList: []string{"2"}},
+			"2": {FileId: "2", Rev: "r2", ChildIdList: []string{"3"}},
+		},
+	}
+	revs := map[string]string{"1": "r1", "2": "r2"}
+	lists := map[string]AppFileList{
+		"2": {
+			{FileId: "3", FileName: "x.txt", FileSize: 1, FileMd5: "AA", Rev: "1"},
+			{FileId: "4", FileName: "y.txt", FileSize: 1, FileMd5: "BB", Rev: "1"},
+		},
+	}
+	revCalls, listCalls := 0, 0
+	src := &snapshotSource{
+		folderRev: func(folderId string) string {
+			revCalls++
+			return revs[folderId]
+		},
+		listFolder: func(folderId string) (*AppFileListResult, *apierror.ApiError) {
+			listCalls++
+			return &AppFileListResult{LastRev: revs[folderId], FileList: lists[folderId]}, nil
+		},
+	}
+
+	// 版本号都没有变化时不获取文件列表，子文件夹逐个查询版本号
+	cur := &AppFileSnapshot{
+		Entries: map[string]*AppSnapshotEntry{},
+		Folders: map[string]*AppSnapshotFolder{},
+	}
+	assert.Nil(t, refreshSnapshotFolder(src, old, cur, "-11", "r0", "/"))
+	assert.Equal(t, 0, listCalls)
+	assert.Equal(t, 2, revCalls)
+	assert.Equal(t, 3, len(cur.Entries))
+	assert.Equal(t, 3, len(cur.Folders))
+	assert.Equal(t, "/a/b/x.txt", cur.Path("3"))
+	assert.Equal(t, 0, len(DiffAppFileSnapshot(old, cur)))
+
+	// 上级文件夹版本号不变，子文件夹变化的仍然能检测到
+	revs["2"] = "r3"
+	revCalls, listCalls = 0, 0
+	cur = &AppFileSnapshot{
+		Entries: map[string]*AppSnapshotEntry{},
+		Folders: map[string]*AppSnapshotFolder{},
+	}
+	assert.Nil(t, refreshSnapshotFolder(src, old, cur, "-11", "r0", "/"))
+	assert.Equal(t, 1, listCalls)
+	changes := DiffAppFileSnapshot(old, cur)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, AppFileChangeCreated, changes[0].Type)
+	assert.Equal(t, "/a/b/y.txt", changes[0].New.Path)
+	assert.Equal(t, "r3", cur.Folders["2"].Rev)
+}
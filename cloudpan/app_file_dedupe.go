@@ -0,0 +1,292 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// DedupeScope 查找重复文件的范围
+	DedupeScope struct {
+		// FamilyId 家庭云ID，<=0 为个人云
+		FamilyId int64
+		// Path 文件夹的绝对路径，为空是根目录
+		Path string
+	}
+
+	// DedupeFile 参与查重的文件
+	DedupeFile struct {
+		*AppFileEntity
+		// FamilyId 文件所在的家庭云，个人云为0
+		FamilyId int64
+	}
+
+	// DedupeGroup MD5和大小都相同的一组文件
+	DedupeGroup struct {
+		FileMd5 string
+		FileSize int64
+		// FileList 按路径排序
+		FileList []*DedupeFile
+	}
+
+	// DedupeReport 重复文件报告
+	DedupeReport struct {
+		// Groups 重复文件组，按浪费的空间降序排列
+		Groups []*DedupeGroup
+		// ScannedCount 扫描的文件数量
+		ScannedCount int
+		// WastedBytes 每组只保留一个文件时可以节省的空间
+		WastedBytes int64
+	}
+
+	// DedupeKeepRule 每组重复文件中保留哪一个
+	DedupeKeepRule int
+
+	// DedupeRemoveParam 删除重复文件的参数
+	DedupeRemoveParam struct {
+		Rule DedupeKeepRule
+		// KeepFamilyId 和 KeepFolder 只对 DedupeKeepInFolder 有效
+		KeepFamilyId int64
+		// KeepFolder 优先保留该文件夹下的文件，组内没有该文件夹下的文件时整组都不删除
+		KeepFolder string
+		// DryRun 只计算要删除的文件，不执行删除
+		DryRun bool
+	}
+
+	// DedupeRemoveResult 删除重复文件的结果
+	DedupeRemoveResult struct {
+		// Kept 每组保留的文件
+		Kept []*DedupeFile
+		// Removed 删除的文件，删除后可以从回收站还原
+		Removed []*DedupeFile
+		// FreedBytes 删除的文件大小
+		FreedBytes int64
+		// Summaries 家庭云ID -> 删除任务的结果
+		Summaries map[int64]*BatchTaskSummary
+	}
+)
+
+const (
+	// DedupeKeepOldest 保留创建时间最早的文件
+	DedupeKeepOldest DedupeKeepRule = iota
+	// DedupeKeepShortestPath 保留路径最短的文件
+	DedupeKeepShortestPath
+	// DedupeKeepInFolder 保留指定文件夹下的文件
+	DedupeKeepInFolder
+)
+
+// WastedBytes 只保留一个文件时可以节省的空间
+func (g *DedupeGroup) WastedBytes() int64 {
+	return g.FileSize * int64(len(g.FileList)-1)
+}
+
+// SelectKeep 按规则选出保留的文件，其余的为要删除的文件，keep 为 nil 时整组都不删除
+func (g *DedupeGroup) SelectKeep(param *DedupeRemoveParam) (keep *DedupeFile, remove []*DedupeFile) {
+	for _, f := range g.FileList {
+		if param.Rule == DedupeKeepInFolder {
+			if !dedupeInFolder(f, param.KeepFamilyId, param.KeepFolder) {
+				continue
+			}
+		}
+		if keep == nil || dedupeBetter(param.Rule, f, keep) {
+			keep = f
+		}
+	}
+	if keep == nil {
+		return nil, nil
+	}
+	for _, f := range g.FileList {
+		if f != keep {
+			remove = append(remove, f)
+		}
+	}
+	return keep, remove
+}
+
+// dedupeBetter a 是否比 b 更应该保留，相同时保留路径靠前的
+func dedupeBetter(rule DedupeKeepRule, a, b *DedupeFile) bool {
+	switch rule {
+	case DedupeKeepShortestPath, DedupeKeepInFolder:
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) < len(b.Path)
+		}
+	default:
+		ta := apiutil.ParseTimeStr(a.CreateTime)
+		tb := apiutil.ParseTimeStr(b.CreateTime)
+		if !ta.Equal(tb) {
+			if ta.IsZero() || tb.IsZero() {
+				return !ta.IsZero()
+			}
+			return ta.Before(tb)
+		}
+	}
+	if a.FamilyId != b.FamilyId {
+		return a.FamilyId < b.FamilyId
+	}
+	return a.Path < b.Path
+}
+
+func dedupeInFolder(f *DedupeFile, familyId int64, folder string) bool {
+	if familyId < 0 {
+		familyId = 0
+	}
+	if f.FamilyId != familyId {
+		return false
+	}
+	folder = path.Clean("/" + folder)
+	return folder == "/" || strings.HasPrefix(f.Path, folder+"/")
+}
+
+// BuildDedupeReport 按MD5和大小对文件分组，只返回有重复的组，文件夹和空文件不参与查重。
+// 查找范围有重叠时同一个文件会出现多次，按 FamilyId 和 FileId 只保留第一次出现的，
+// 避免把同一个文件当成重复文件删除
+func BuildDedupeReport(files []*DedupeFile) *DedupeReport {
+	report := &DedupeReport{Groups: []*DedupeGroup{}}
+	groups := map[string]*DedupeGroup{}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if f.IsFolder || f.FileSize == 0 || f.FileMd5 == "" {
+			continue
+		}
+		fileKey := strconv.FormatInt(f.FamilyId, 10) + ":" + f.FileId
+		if seen[fileKey] {
+			continue
+		}
+		seen[fileKey] = true
+		report.ScannedCount++
+		md5 := strings.ToUpper(f.FileMd5)
+		key := md5 + ":" + strconv.FormatInt(f.FileSize, 10)
+		g, ok := groups[key]
+		if !ok {
+			g = &DedupeGroup{FileMd5: md5, FileSize: f.FileSize}
+			groups[key] = g
+		}
+		g.FileList = append(g.FileList, f)
+	}
+	for _, g := range groups {
+		if len(g.FileList) < 2 {
+			continue
+		}
+		sort.Slice(g.FileList, func(i, j int) bool {
+			if g.FileList[i].FamilyId != g.FileList[j].FamilyId {
+				return g.FileList[i].FamilyId < g.FileList[j].FamilyId
+			}
+			return g.FileList[i].Path < g.FileList[j].Path
+		})
+		report.Groups = append(report.Groups, g)
+		report.WastedBytes += g.WastedBytes()
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		wi, wj := report.Groups[i].WastedBytes(), report.Groups[j].WastedBytes()
+		if wi != wj {
+			return wi > wj
+		}
+		return report.Groups[i].FileMd5 < report.Groups[j].FileMd5
+	})
+	return report
+}
+
+// AppDedupeReport 在一个或多个云盘(个人云、家庭云)的文件夹中查找重复文件，使用服务端记录的MD5，不需要下载文件
+func (p *PanClient) AppDedupeReport(scopes ...*DedupeScope) (*DedupeReport, *apierror.ApiError) {
+	files := []*DedupeFile{}
+	for _, scope := range scopes {
+		familyId := scope.FamilyId
+		if familyId < 0 {
+			familyId = 0
+		}
+		dirPath := scope.Path
+		if dirPath == "" {
+			dirPath = "/"
+		}
+		dir, apiErr := p.AppFileInfoByPath(familyId, dirPath)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		dir.Path = path.Clean(dirPath)
+		list := func(folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+			return p.appListFolder(familyId, folder)
+		}
+		apiErr = walkAppFiles(dir, list, func(fe *AppFileEntity) {
+			files = append(files, &DedupeFile{AppFileEntity: fe, FamilyId: familyId})
+		})
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	return BuildDedupeReport(files), nil
+}
+
+// walkAppFiles 递归遍历文件夹下的所有文件，Path 为完整路径
+func walkAppFiles(folder *AppFileEntity, list listFolderFunc, fn func(fe *AppFileEntity)) *apierror.ApiError {
+	children, apiErr := list(folder)
+	if apiErr != nil {
+		return apiErr
+	}
+	for _, fe := range children {
+		fe.Path = path.Join(folder.Path, fe.FileName)
+		if !fe.IsFolder {
+			fn(fe)
+			continue
+		}
+		if apiErr := walkAppFiles(fe, list, fn); apiErr != nil {
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// AppDedupeRemove 按规则删除重复文件，每组只保留一个，删除通过批量任务执行，删除的文件可以从回收站还原
+func (p *PanClient) AppDedupeRemove(ctx context.Context, report *DedupeReport, param *DedupeRemoveParam) (*DedupeRemoveResult, *apierror.ApiError) {
+	result := &DedupeRemoveResult{
+		Kept: []*DedupeFile{},
+		Removed: []*DedupeFile{},
+		Summaries: map[int64]*BatchTaskSummary{},
+	}
+	removeByFamily := map[int64]AppFileList{}
+	families := []int64{}
+	for _, g := range report.Groups {
+		keep, remove := g.SelectKeep(param)
+		if keep == nil {
+			continue
+		}
+		result.Kept = append(result.Kept, keep)
+		for _, f := range remove {
+			if _, ok := removeByFamily[f.FamilyId]; !ok {
+				families = append(families, f.FamilyId)
+			}
+			removeByFamily[f.FamilyId] = append(removeByFamily[f.FamilyId], f.AppFileEntity)
+			result.Removed = append(result.Removed, f)
+			result.FreedBytes += f.FileSize
+		}
+	}
+	if param.DryRun {
+		return result, nil
+	}
+	for _, familyId := range families {
+		summary, apiErr := p.batchDeleteFileList(ctx, familyId, removeByFamily[familyId])
+		result.Summaries[familyId] = summary
+		if apiErr != nil {
+			return result, apiErr
+		}
+	}
+	return result, nil
+}
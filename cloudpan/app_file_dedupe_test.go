@@ -0,0 +1,92 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDedupeReport(t *testing.T) {
+	file := func(familyId int64, fileId, p string, size int64, md5, createTime string) *DedupeFile {
+		return &DedupeFile{
+			AppFileEntity: &AppFileEntity{FileId: fileId, Path: p, FileSize: size, FileMd5: md5, CreateTime: createTime},
+			FamilyId: familyId,
+		}
+	}
+	files := []*DedupeFile{
+		file(0, "1", "/照片/2020/a.jpg", 100, "aaa", "2020-01-02 00:00:00"),
+		file(0, "2", "/备份/a.jpg", 100, "AAA", "2020-01-01 00:00:00"),
+		file(9, "3", "/a.jpg", 100, "AAA", "2021-01-01 00:00:00"),
+		// 大小不同不算重复
+		file(0, "4", "/b.jpg", 200, "AAA", ""),
+		file(0, "5", "/c.mp4", 1000, "CCC", ""),
+		file(0, "6", "/视频/c.mp4", 1000, "CCC", ""),
+		// 空文件不参与查重
+		file(0, "7", "/empty1", 0, DefaultEmptyFileMd5, ""),
+		file(0, "8", "/empty2", 0, DefaultEmptyFileMd5, ""),
+	}
+	report := BuildDedupeReport(files)
+	assert.Equal(t, 6, report.ScannedCount)
+	assert.Equal(t, 2, len(report.Groups))
+	assert.Equal(t, int64(1200), report.WastedBytes)
+	assert.Equal(t, "CCC", report.Groups[0].FileMd5)
+	assert.Equal(t, "AAA", report.Groups[1].FileMd5)
+	assert.Equal(t, 3, len(report.Groups[1].FileList))
+
+	g := report.Groups[1]
+	keep, remove := g.SelectKeep(&DedupeRemoveParam{Rule: DedupeKeepOldest})
+	assert.Equal(t, "2", keep.FileId)
+	assert.Equal(t, 2, len(remove))
+
+	keep, _ = g.SelectKeep(&DedupeRemoveParam{Rule: DedupeKeepShortestPath})
+	assert.Equal(t, "3", keep.FileId)
+
+	keep, _ = g.SelectKeep(&DedupeRemoveParam{Rule: DedupeKeepInFolder, KeepFolder: "/照片"})
+	assert.Equal(t, "1", keep.FileId)
+
+	keep, remove = g.SelectKeep(&DedupeRemoveParam{Rule: DedupeKeepInFolder, KeepFolder: "/其他"})
+	assert.Nil(t, keep)
+	assert.Nil(t, remove)
+
+	// DryRun 不会调用接口
+	r, apiErr := (&PanClient{}).AppDedupeRemove(context.Background(), report, &DedupeRemoveParam{Rule: DedupeKeepShortestPath, DryRun: true})
+	assert.Nil(t, apiErr)
+	assert.Equal(t, 2, len(r.Kept))
+	assert.Equal(t, 3, len(r.Removed))
+	assert.Equal(t, int64(1200), r.FreedBytes)
+}
+
+func TestDedupeReportOverlappingScopes(t *testing.T) {
+	// "/" 和 "/照片" 两个查找范围重叠，同一个文件出现两次
+	a := &AppFileEntity{FileId: "1", Path: "/照片/a.jpg", FileSize: 100, FileMd5: "AAA"}
+	files := []*DedupeFile{
+		{AppFileEntity: a},
+		{AppFileEntity: &AppFileEntity{FileId: "2", Path: "/b.jpg", FileSize: 200, FileMd5: "BBB"}},
+		{AppFileEntity: a},
+		// 家庭云中相同ID的文件是不同的文件
+		{AppFileEntity: &AppFileEntity{FileId: "2", Path: "/b.jpg", FileSize: 200, FileMd5: "BBB"}, FamilyId: 9},
+	}
+	report := BuildDedupeReport(files)
+	assert.Equal(t, 3, report.ScannedCount)
+	assert.Equal(t, 1, len(report.Groups))
+	assert.Equal(t, "BBB", report.Groups[0].FileMd5)
+	assert.Equal(t, int64(200), report.WastedBytes)
+
+	// 同一个文件重复出现时没有可以删除的文件
+	report = BuildDedupeReport([]*DedupeFile{files[0], files[2]})
+	assert.Equal(t, 0, len(report.Groups))
+}
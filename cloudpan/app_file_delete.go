@@ -15,6 +15,7 @@
 package cloudpan
 
 import (
+	"context"
 	"fmt"
 	"github.com/phpc0de/ctapi/cloudpan/apierror"
 	"github.com/phpc0de/ctapi/cloudpan/apiutil"
@@ -43,4 +44,29 @@ func (p *PanClient) AppDeleteFile(fileIdList []string) (bool, *apierror.ApiError
 		return false, apierror.NewApiErrorWithError(err1)
 	}
 	return true, nil
-}
\ No newline at end of file
+}
+
+// AppDeleteFileByFamily 删除文件/文件夹，familyId<=0 为个人云，家庭云通过批量任务删除并等待任务结束
+func (p *PanClient) AppDeleteFileByFamily(familyId int64, fileList AppFileList) *apierror.ApiError {
+	if len(fileList) == 0 {
+		return nil
+	}
+	if familyId > 0 {
+		taskId, err := p.AppCreateBatchTask(familyId, &BatchTaskParam{
+			TypeFlag: BatchTaskTypeDelete,
+			TaskInfos: newBatchTaskInfoList(fileList),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = p.WaitBatchTask(context.Background(), familyId, BatchTaskTypeDelete, taskId, nil)
+		return err
+	}
+
+	fileIdList := []string{}
+	for _, fe := range fileList {
+		fileIdList = append(fileIdList, fe.FileId)
+	}
+	_, err := p.AppDeleteFile(fileIdList)
+	return err
+}
@@ -15,6 +15,7 @@
 package cloudpan
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"github.com/phpc0de/ctapi/cloudpan/apierror"
@@ -26,7 +27,8 @@ import (
 )
 
 type (
-	DownloadFuncCallback func(httpMethod, fullUrl string, headers map[string]string) (resp *http.Response, err error)
+	// DownloadFuncCallback 处理文件数据的响应，resp.Body 已经受限速控制，函数返回后关闭 Body
+	DownloadFuncCallback func(resp *http.Response) error
 
 	AppFileDownloadRange struct {
 		// 起始值，包含
@@ -36,6 +38,18 @@ type (
 	}
 )
 
+// rangeHeader 区间下载的 range 请求头，End 为0表示下载到文件末尾，下载整个文件时为空
+func (r AppFileDownloadRange) rangeHeader() string {
+	if r.Offset == 0 && r.End == 0 {
+		return ""
+	}
+	rangeStr := "bytes=" + strconv.FormatInt(r.Offset, 10) + "-"
+	if r.End != 0 {
+		rangeStr += strconv.FormatInt(r.End, 10)
+	}
+	return rangeStr
+}
+
 func (p *PanClient) AppGetFileDownloadUrl(fileId string) (string, *apierror.ApiError) {
 	fullUrl := &strings.Builder{}
 	appToken := p.appToken
@@ -75,7 +89,19 @@ func (p *PanClient) AppGetFileDownloadUrl(fileId string) (string, *apierror.ApiE
 	return strings.ReplaceAll(item.FileDownloadUrl, "&amp;", "&"), nil
 }
 
+// AppDownloadFileData 下载文件数据，受 PanClient 的限速控制，见 AppDownloadFileDataContext
 func (p *PanClient) AppDownloadFileData(downloadFileUrl string, fileRange AppFileDownloadRange, downloadFunc DownloadFuncCallback) *apierror.ApiError {
+	return p.AppDownloadFileDataContext(context.Background(), downloadFileUrl, fileRange, downloadFunc)
+}
+
+// AppDownloadFileDataContext 下载文件数据，交给 downloadFunc 的响应的 Body 已经替换为限速后的数据流。ctx 结束时停止等待
+func (p *PanClient) AppDownloadFileDataContext(ctx context.Context, downloadFileUrl string, fileRange AppFileDownloadRange, downloadFunc DownloadFuncCallback) *apierror.ApiError {
+	fullUrl, headers := p.appDownloadRequest(downloadFileUrl, fileRange)
+	return p.handleThrottledDownload(ctx, "GET", fullUrl, headers, downloadFunc)
+}
+
+// appDownloadRequest 下载个人云文件数据的请求地址和请求头
+func (p *PanClient) appDownloadRequest(downloadFileUrl string, fileRange AppFileDownloadRange) (string, map[string]string) {
 	fullUrl := &strings.Builder{}
 	appToken := p.appToken
 	httpMethod := "GET"
@@ -89,19 +115,9 @@ func (p *PanClient) AppDownloadFileData(downloadFileUrl string, fileRange AppFil
 		"X-Request-ID": apiutil.XRequestId(),
 	}
 	// 支持断点续传
-	if fileRange.Offset != 0 || fileRange.End != 0 {
-		rangeStr := "bytes=" + strconv.FormatInt(fileRange.Offset, 10) + "-"
-		if fileRange.End != 0 {
-			rangeStr += strconv.FormatInt(fileRange.End, 10)
-		}
+	if rangeStr := fileRange.rangeHeader(); rangeStr != "" {
 		headers["range"] = rangeStr
 	}
 	logger.Verboseln("do request url: " + fullUrl.String())
-	_, err := downloadFunc(httpMethod, fullUrl.String(), headers)
-	//resp, err := p.client.Req(httpMethod, fullUrl.String(), nil, headers)
-	if err != nil {
-		logger.Verboseln("AppDownloadFileData response failed")
-		return apierror.NewApiErrorWithError(err)
-	}
-	return nil
-}
\ No newline at end of file
+	return fullUrl.String(), headers
+}
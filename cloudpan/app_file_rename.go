@@ -29,6 +29,11 @@ func (p *PanClient) AppRenameFile(renameFileId, newName string) (*AppFileEntity,
 	return p.appRenameFileInternal(renameFileId, newName, false)
 }
 
+// AppRenameFolder 重命名文件夹
+func (p *PanClient) AppRenameFolder(renameFolderId, newName string) (*AppFileEntity, *apierror.ApiError) {
+	return p.appRenameFileInternal(renameFolderId, newName, true)
+}
+
 func (p *PanClient) appRenameFileInternal(renameFileId, newName string, isFolder bool) (*AppFileEntity, *apierror.ApiError) {
 	fullUrl := &strings.Builder{}
 	if isFolder {
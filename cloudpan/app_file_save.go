@@ -23,74 +23,81 @@ import (
 	"strings"
 )
 
+type (
+	appCrossCloudSaveResult struct {
+		TaskId string `xml:"taskId"`
+	}
+)
+
 // AppFamilySaveFileToPersonCloud 复制家庭共享文件文件到个人云
 func (p *PanClient) AppFamilySaveFileToPersonCloud(familyId int64, familyFileIdList []string) (bool, *apierror.ApiError) {
 	if len(familyFileIdList) == 0 {
 		return false, nil
 	}
-
-	fileIdStrList := []string{}
-	for _,item := range familyFileIdList {
-		fileIdStrList = append(fileIdStrList, "fileIdList=" + item)
-	}
-	fileIdListStr := strings.Join(fileIdStrList, "&")
-
-	fullUrl := &strings.Builder{}
-	fmt.Fprintf(fullUrl, "%s/family/file/saveFileToMember.action?familyId=%d&%s&destParentId=&%s",
-		API_URL,
-		familyId,
-		fileIdListStr,
-		apiutil.PcClientInfoSuffixParam())
-
-	sessionKey := p.appToken.FamilySessionKey
-	sessionSecret := p.appToken.FamilySessionSecret
-	httpMethod := "GET"
-	dateOfGmt := apiutil.DateOfGmtStr()
-	headers := map[string]string {
-		"Date": dateOfGmt,
-		"SessionKey": sessionKey,
-		"Signature": apiutil.SignatureOfHmac(sessionSecret, sessionKey, httpMethod, fullUrl.String(), dateOfGmt),
-		"X-Request-ID": apiutil.XRequestId(),
-	}
-
-	logger.Verboseln("do request url: " + fullUrl.String())
-	respBody, err1 := p.client.Fetch(httpMethod, fullUrl.String(), nil, headers)
-	if err1 != nil {
-		logger.Verboseln("AppSaveFileToPersonCloud occurs error: ", err1.Error())
-		return false, apierror.NewApiErrorWithError(err1)
-	}
-	logger.Verboseln("response: " + string(respBody))
-
-	er := &apierror.AppErrorXmlResp{}
-	if err := xml.Unmarshal(respBody, er); err == nil {
-		if er.Code != "" {
-			if er.Code == "FileAlreadyExists" {
-				return false, apierror.NewApiError(apierror.ApiCodeFileAlreadyExisted, "文件已存在")
-			}
-			return false, apierror.NewFailedApiError("复制保存文件到个人云出错")
-		}
+	if _, apiErr := p.appCrossCloudSaveRequest("saveFileToMember", familyId, familyFileIdList, "", "复制保存文件到个人云出错"); apiErr != nil {
+		return false, apiErr
 	}
 	return true, nil
 }
 
+// AppFamilySaveFileToPersonCloudDir 复制家庭共享文件到个人云的指定文件夹，destParentId 为空使用默认文件夹，
+// 返回服务端异步任务的ID
+func (p *PanClient) AppFamilySaveFileToPersonCloudDir(familyId int64, familyFileIdList []string, destParentId string) (taskId string, error *apierror.ApiError) {
+	return p.appCrossCloudSave("saveFileToMember", familyId, familyFileIdList, destParentId, "复制保存文件到个人云出错")
+}
 
 // AppSaveFileToFamilyCloud 复制个人云文件文件到家庭云
 func (p *PanClient) AppSaveFileToFamilyCloud(familyId int64, personFileIdList []string) (bool, *apierror.ApiError) {
 	if len(personFileIdList) == 0 {
 		return false, nil
 	}
+	if _, apiErr := p.appCrossCloudSaveRequest("shareFileToFamily", familyId, personFileIdList, "", "复制保存文件到家庭云出错"); apiErr != nil {
+		return false, apiErr
+	}
+	return true, nil
+}
+
+// AppSaveFileToFamilyCloudDir 复制个人云文件到家庭云的指定文件夹，destParentId 为空使用默认文件夹，
+// 返回服务端异步任务的ID
+func (p *PanClient) AppSaveFileToFamilyCloudDir(familyId int64, personFileIdList []string, destParentId string) (taskId string, error *apierror.ApiError) {
+	return p.appCrossCloudSave("shareFileToFamily", familyId, personFileIdList, destParentId, "复制保存文件到家庭云出错")
+}
+
+// appCrossCloudSave 提交个人云和家庭云之间的复制任务，响应中没有任务ID时返回错误
+func (p *PanClient) appCrossCloudSave(action string, familyId int64, fileIdList []string, destParentId, errMsg string) (string, *apierror.ApiError) {
+	respBody, apiErr := p.appCrossCloudSaveRequest(action, familyId, fileIdList, destParentId, errMsg)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	return parseCrossCloudSaveTaskId(respBody, errMsg)
+}
 
+func parseCrossCloudSaveTaskId(respBody []byte, errMsg string) (string, *apierror.ApiError) {
+	item := &appCrossCloudSaveResult{}
+	if err := xml.Unmarshal(respBody, item); err != nil {
+		logger.Verboseln("appCrossCloudSave response parse error: ", err)
+		return "", apierror.NewApiErrorWithError(err)
+	}
+	if item.TaskId == "" {
+		return "", apierror.NewFailedApiError(errMsg + "：没有返回任务ID")
+	}
+	return item.TaskId, nil
+}
+
+func (p *PanClient) appCrossCloudSaveRequest(action string, familyId int64, fileIdList []string, destParentId, errMsg string) ([]byte, *apierror.ApiError) {
 	fileIdStrList := []string{}
-	for _,item := range personFileIdList {
+	for _,item := range fileIdList {
 		fileIdStrList = append(fileIdStrList, "fileIdList=" + item)
 	}
 	fileIdListStr := strings.Join(fileIdStrList, "&")
 
 	fullUrl := &strings.Builder{}
-	fmt.Fprintf(fullUrl, "%s/family/file/shareFileToFamily.action?familyId=%d&%s&destParentId=&%s",
+	fmt.Fprintf(fullUrl, "%s/family/file/%s.action?familyId=%d&%s&destParentId=%s&%s",
 		API_URL,
+		action,
 		familyId,
 		fileIdListStr,
+		destParentId,
 		apiutil.PcClientInfoSuffixParam())
 
 	sessionKey := p.appToken.FamilySessionKey
@@ -107,8 +114,8 @@ func (p *PanClient) AppSaveFileToFamilyCloud(familyId int64, personFileIdList []
 	logger.Verboseln("do request url: " + fullUrl.String())
 	respBody, err1 := p.client.Fetch(httpMethod, fullUrl.String(), nil, headers)
 	if err1 != nil {
-		logger.Verboseln("AppSaveFileToFamilyCloud occurs error: ", err1.Error())
-		return false, apierror.NewApiErrorWithError(err1)
+		logger.Verboseln("appCrossCloudSave occurs error: ", err1.Error())
+		return nil, apierror.NewApiErrorWithError(err1)
 	}
 	logger.Verboseln("response: " + string(respBody))
 
@@ -116,10 +123,10 @@ func (p *PanClient) AppSaveFileToFamilyCloud(familyId int64, personFileIdList []
 	if err := xml.Unmarshal(respBody, er); err == nil {
 		if er.Code != "" {
 			if er.Code == "FileAlreadyExists" {
-				return false, apierror.NewApiError(apierror.ApiCodeFileAlreadyExisted, "文件已存在")
+				return nil, apierror.NewApiError(apierror.ApiCodeFileAlreadyExisted, "文件已存在")
 			}
-			return false, apierror.NewFailedApiError("复制保存文件到家庭云出错")
+			return nil, apierror.NewFailedApiError(errMsg)
 		}
 	}
-	return true, nil
-}
\ No newline at end of file
+	return respBody, nil
+}
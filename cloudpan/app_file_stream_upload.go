@@ -0,0 +1,375 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"github.com/phpc0de/ctlibgo/logger"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// AppStreamUploadParam 流式上传参数
+	AppStreamUploadParam struct {
+		FamilyId int64
+		// ParentFolderId 存储云盘的目录ID
+		ParentFolderId string
+		// FileName 存储云盘的文件名，拆分上传时为分片文件和清单文件的前缀
+		FileName string
+		// MemoryBufferSize 不可 Seek 的数据流缓存在内存中的最大字节数，超过后写入临时文件，默认 DefaultStreamMemoryBufferSize
+		MemoryBufferSize int64
+		// PartSize 单个文件的最大字节数，数据流超过时拆分为多个分片文件上传，默认 DefaultStreamPartSize
+		PartSize int64
+		// TempDir 临时文件目录，默认为系统临时目录
+		TempDir string
+		// Overwrite 覆盖同名文件，只对个人云有效
+		Overwrite bool
+	}
+
+	// AppStreamUploadPart 拆分上传的分片文件
+	AppStreamUploadPart struct {
+		// Index 分片序号，从1开始
+		Index int `json:"index"`
+		FileName string `json:"fileName"`
+		FileId string `json:"fileId"`
+		// Offset 分片在数据流中的偏移
+		Offset int64 `json:"offset"`
+		Size int64 `json:"size"`
+		// Md5 分片的MD5，大写
+		Md5 string `json:"md5"`
+	}
+
+	// AppStreamManifest 拆分上传的清单，按顺序拼接所有分片得到原始数据
+	AppStreamManifest struct {
+		// FileName 原始文件名
+		FileName string `json:"fileName"`
+		// Size 数据流总大小
+		Size int64 `json:"size"`
+		// Md5 数据流的MD5，大写
+		Md5 string `json:"md5"`
+		PartSize int64 `json:"partSize"`
+		Parts []*AppStreamUploadPart `json:"parts"`
+		// CreateTime 上传时间，格式：2018-11-18 09:12:13
+		CreateTime string `json:"createTime"`
+	}
+
+	// AppStreamUploadResult 流式上传结果
+	AppStreamUploadResult struct {
+		// Size 数据流总大小
+		Size int64
+		// Md5 数据流的MD5，大写
+		Md5 string
+		// File 没有拆分时上传的文件，拆分上传时为 nil
+		File *AppUploadFileCommitResult
+		// Manifest 拆分上传时的清单，没有拆分时为 nil
+		Manifest *AppStreamManifest
+		// ManifestFile 上传的清单文件
+		ManifestFile *AppUploadFileCommitResult
+		// Parts 已经上传的分片文件，上传失败时和错误一起返回，调用方可以据此删除已经上传的分片
+		Parts []*AppStreamUploadPart
+	}
+
+	// streamUploadFunc 上传一个文件
+	streamUploadFunc func(name string, size int64, md5 string, reader io.Reader) (*AppUploadFileCommitResult, *apierror.ApiError)
+
+	// streamPart 读取到的一段数据，MD5和大小已经计算好，可以重新读取用于上传
+	streamPart struct {
+		size   int64
+		md5    string
+		reader io.Reader
+		// last 是否是数据流的最后一段
+		last    bool
+		cleanup func()
+	}
+
+	// streamSplitter 把数据流按 partSize 切分，不可 Seek 的数据流缓存到内存或临时文件，
+	// 可 Seek 的数据流先读一遍计算MD5再 Seek 回来
+	streamSplitter struct {
+		seeker   io.ReadSeeker
+		buffered *bufio.Reader
+		partSize int64
+		memLimit int64
+		tempDir  string
+		total    hash.Hash
+		offset   int64
+	}
+
+	// spoolBuffer 先写入内存，超过 limit 后转为写入临时文件
+	spoolBuffer struct {
+		mem   bytes.Buffer
+		file  *os.File
+		limit int64
+		dir   string
+	}
+)
+
+const (
+	// DefaultStreamMemoryBufferSize 默认流式上传内存缓存大小
+	DefaultStreamMemoryBufferSize int64 = 8 * 1024 * 1024
+	// DefaultStreamPartSize 默认流式上传单个文件的最大字节数
+	DefaultStreamPartSize int64 = 2 * 1024 * 1024 * 1024
+
+	// StreamManifestSuffix 拆分上传的清单文件后缀
+	StreamManifestSuffix = ".manifest.json"
+)
+
+// StreamPartFileName 拆分上传的分片文件名，例如 backup.sql.part0001
+func StreamPartFileName(fileName string, index int) string {
+	return fmt.Sprintf("%s.part%04d", fileName, index)
+}
+
+// AppUploadFileFromStream 上传大小未知的数据流，例如管道的输出，支持个人云和家庭云。
+// 可 Seek 的 reader 先读一遍计算MD5再从当前位置上传，否则缓存到有限大小的内存或临时文件中再上传。
+// 数据超过 PartSize 时拆分为 文件名.part0001、文件名.part0002 ... 多个分片文件，最后上传 文件名.manifest.json 清单。
+// 拆分上传中途失败时，返回的结果中 Parts 为已经上传的分片文件，不会自动删除
+func (p *PanClient) AppUploadFileFromStream(param *AppStreamUploadParam, reader io.Reader) (*AppStreamUploadResult, *apierror.ApiError) {
+	return uploadStream(param, reader, func(name string, size int64, md5 string, reader io.Reader) (*AppUploadFileCommitResult, *apierror.ApiError) {
+		return p.appUploadStreamData(param, name, size, md5, reader)
+	})
+}
+
+func uploadStream(param *AppStreamUploadParam, reader io.Reader, upload streamUploadFunc) (*AppStreamUploadResult, *apierror.ApiError) {
+	if param.FileName == "" {
+		return nil, apierror.NewFailedApiError("文件名不能为空")
+	}
+	s, err := newStreamSplitter(reader, param)
+	if err != nil {
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+
+	result := &AppStreamUploadResult{}
+	for index := 1; ; index++ {
+		offset := s.offset
+		part, err := s.next()
+		if err != nil {
+			return result, apierror.NewApiErrorWithError(err)
+		}
+		name := param.FileName
+		if index > 1 || !part.last {
+			name = StreamPartFileName(param.FileName, index)
+		}
+		logger.Verboseln("stream upload part: ", name, " size: ", part.size)
+		r, apiErr := upload(name, part.size, part.md5, part.reader)
+		part.cleanup()
+		if apiErr != nil {
+			return result, apiErr
+		}
+		if index == 1 && part.last {
+			result.File = r
+		} else {
+			result.Parts = append(result.Parts, &AppStreamUploadPart{
+				Index: index,
+				FileName: name,
+				FileId: r.Id,
+				Offset: offset,
+				Size: part.size,
+				Md5: part.md5,
+			})
+		}
+		if part.last {
+			break
+		}
+	}
+	result.Size = s.offset
+	result.Md5 = strings.ToUpper(hex.EncodeToString(s.total.Sum(nil)))
+	if result.File != nil {
+		return result, nil
+	}
+
+	manifest := &AppStreamManifest{
+		FileName: param.FileName,
+		Size: result.Size,
+		Md5: result.Md5,
+		PartSize: s.partSize,
+		Parts: result.Parts,
+		CreateTime: apiutil.FormatTime(time.Now()),
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return result, apierror.NewApiErrorWithError(err)
+	}
+	sum := md5.Sum(data)
+	r, apiErr := upload(param.FileName+StreamManifestSuffix, int64(len(data)),
+		strings.ToUpper(hex.EncodeToString(sum[:])), bytes.NewReader(data))
+	if apiErr != nil {
+		return result, apiErr
+	}
+	result.Manifest = manifest
+	result.ManifestFile = r
+	return result, nil
+}
+
+func (p *PanClient) appUploadStreamData(param *AppStreamUploadParam, name string, size int64, md5 string, reader io.Reader) (*AppUploadFileCommitResult, *apierror.ApiError) {
+	return p.AppUploadFileFromReader(&AppCreateUploadFileParam{
+		FamilyId: param.FamilyId,
+		ParentFolderId: param.ParentFolderId,
+		FileName: name,
+		Size: size,
+		Md5: md5,
+		LastWrite: apiutil.FormatTime(time.Now()),
+	}, reader, param.Overwrite)
+}
+
+func newStreamSplitter(reader io.Reader, param *AppStreamUploadParam) (*streamSplitter, error) {
+	s := &streamSplitter{
+		partSize: param.PartSize,
+		memLimit: param.MemoryBufferSize,
+		tempDir: param.TempDir,
+		total: md5.New(),
+	}
+	if s.partSize <= 0 {
+		s.partSize = DefaultStreamPartSize
+	}
+	if s.memLimit <= 0 {
+		s.memLimit = DefaultStreamMemoryBufferSize
+	}
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		// 管道等不支持 Seek 的文件 Seek 会失败
+		if _, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			s.seeker = seeker
+			return s, nil
+		}
+	}
+	s.buffered = bufio.NewReader(reader)
+	return s, nil
+}
+
+// next 读取下一段数据，调用方上传后需要调用 cleanup
+func (s *streamSplitter) next() (*streamPart, error) {
+	if s.seeker != nil {
+		return s.nextSeekable()
+	}
+	return s.nextSpooled()
+}
+
+func (s *streamSplitter) nextSeekable() (*streamPart, error) {
+	start, err := s.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	h := md5.New()
+	n, err := io.CopyN(io.MultiWriter(h, s.total), s.seeker, s.partSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	last := err == io.EOF
+	if !last {
+		// 刚好读完 partSize 时判断后面是否还有数据
+		var b [1]byte
+		m, err := s.seeker.Read(b[:])
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		last = m == 0
+	}
+	if _, err := s.seeker.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s.offset += n
+	return &streamPart{
+		size: n,
+		md5: strings.ToUpper(hex.EncodeToString(h.Sum(nil))),
+		reader: io.LimitReader(s.seeker, n),
+		last: last,
+		cleanup: func() {
+			// 上传时可能没有读取数据（秒传），定位到下一段的开始
+			s.seeker.Seek(start+n, io.SeekStart)
+		},
+	}, nil
+}
+
+func (s *streamSplitter) nextSpooled() (*streamPart, error) {
+	spool := &spoolBuffer{limit: s.memLimit, dir: s.tempDir}
+	h := md5.New()
+	n, err := io.CopyN(io.MultiWriter(spool, h, s.total), s.buffered, s.partSize)
+	if err != nil && err != io.EOF {
+		spool.Close()
+		return nil, err
+	}
+	last := err == io.EOF
+	if !last {
+		if _, err := s.buffered.Peek(1); err != nil {
+			if err != io.EOF {
+				spool.Close()
+				return nil, err
+			}
+			last = true
+		}
+	}
+	reader, err := spool.Reader()
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	s.offset += n
+	return &streamPart{
+		size: n,
+		md5: strings.ToUpper(hex.EncodeToString(h.Sum(nil))),
+		reader: reader,
+		last: last,
+		cleanup: spool.Close,
+	}, nil
+}
+
+func (b *spoolBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && int64(b.mem.Len()+len(p)) > b.limit {
+		f, err := ioutil.TempFile(b.dir, "ctstream")
+		if err != nil {
+			return 0, err
+		}
+		b.file = f
+		if _, err := f.Write(b.mem.Bytes()); err != nil {
+			return 0, err
+		}
+		b.mem.Reset()
+	}
+	if b.file != nil {
+		return b.file.Write(p)
+	}
+	return b.mem.Write(p)
+}
+
+// Reader 从头读取缓存的数据
+func (b *spoolBuffer) Reader() (io.Reader, error) {
+	if b.file == nil {
+		return bytes.NewReader(b.mem.Bytes()), nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return b.file, nil
+}
+
+// Close 删除临时文件
+func (b *spoolBuffer) Close() {
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+		b.file = nil
+	}
+	b.mem.Reset()
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func md5Upper(data []byte) string {
+	sum := md5.Sum(data)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// splitAll 切分数据流，返回每一段的数据
+func splitAll(t *testing.T, reader io.Reader, param *AppStreamUploadParam) ([][]byte, *streamSplitter) {
+	s, err := newStreamSplitter(reader, param)
+	assert.Nil(t, err)
+	parts := [][]byte{}
+	for i := 0; i < 100; i++ {
+		part, err := s.next()
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(part.reader)
+		assert.Nil(t, err)
+		assert.Equal(t, part.size, int64(len(data)))
+		assert.Equal(t, md5Upper(data), part.md5)
+		part.cleanup()
+		parts = append(parts, data)
+		if part.last {
+			break
+		}
+	}
+	return parts, s
+}
+
+func TestStreamSplitter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "streamupload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	data := []byte("0123456789abcdefghijABCDE")
+	param := &AppStreamUploadParam{PartSize: 10, MemoryBufferSize: 4, TempDir: tempDir}
+	cases := []struct {
+		size  int
+		parts []int
+	}{
+		{0, []int{0}},
+		{5, []int{5}},
+		{10, []int{10}},
+		{20, []int{10, 10}},
+		{25, []int{10, 10, 5}},
+	}
+	for _, c := range cases {
+		input := data[:c.size]
+		readers := map[string]io.Reader{
+			// 隐藏 Seek，模拟管道
+			"pipe": io.MultiReader(bytes.NewReader(input)),
+			"seek": bytes.NewReader(input),
+		}
+		for kind, reader := range readers {
+			parts, s := splitAll(t, reader, param)
+			sizes := []int{}
+			joined := []byte{}
+			for _, p := range parts {
+				sizes = append(sizes, len(p))
+				joined = append(joined, p...)
+			}
+			assert.Equal(t, c.parts, sizes, kind)
+			assert.Equal(t, input, joined, kind)
+			assert.Equal(t, int64(c.size), s.offset, kind)
+			assert.Equal(t, md5Upper(input), strings.ToUpper(hex.EncodeToString(s.total.Sum(nil))), kind)
+		}
+	}
+
+	// 临时文件上传后被删除
+	files, err := ioutil.ReadDir(tempDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+
+	// 可 Seek 的数据流从当前位置开始
+	r := bytes.NewReader(data)
+	r.Seek(20, io.SeekStart)
+	parts, _ := splitAll(t, r, param)
+	assert.Equal(t, [][]byte{[]byte("ABCDE")}, parts)
+
+	assert.Equal(t, "db.sql.part0012", StreamPartFileName("db.sql", 12))
+}
+
+func TestUploadStreamPartFailed(t *testing.T) {
+	param := &AppStreamUploadParam{FileName: "db.sql", PartSize: 10}
+	uploaded := []string{}
+	failAt := 3
+	upload := func(name string, size int64, md5 string, reader io.Reader) (*AppUploadFileCommitResult, *apierror.ApiError) {
+		if len(uploaded)+1 == failAt {
+			return nil, apierror.NewFailedApiError("上传失败")
+		}
+		uploaded = append(uploaded, name)
+		return &AppUploadFileCommitResult{Id: strconv.Itoa(len(uploaded)), Name: name}, nil
+	}
+
+	result, apiErr := uploadStream(param, bytes.NewReader([]byte("0123456789abcdefghijABCDE")), upload)
+	assert.NotNil(t, apiErr)
+	// 返回已经上传的分片，调用方可以删除
+	assert.NotNil(t, result)
+	assert.Equal(t, 2, len(result.Parts))
+	assert.Equal(t, "db.sql.part0001", result.Parts[0].FileName)
+	assert.Equal(t, "1", result.Parts[0].FileId)
+	assert.Equal(t, "2", result.Parts[1].FileId)
+	assert.Nil(t, result.Manifest)
+
+	uploaded = []string{}
+	failAt = 0
+	result, apiErr = uploadStream(param, bytes.NewReader([]byte("0123456789ab")), upload)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, []string{"db.sql.part0001", "db.sql.part0002", "db.sql.manifest.json"}, uploaded)
+	assert.Equal(t, 2, len(result.Manifest.Parts))
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+type (
+	// sizedReader 带长度的reader，用于设置上传请求的 Content-Length
+	sizedReader struct {
+		io.Reader
+		size int64
+	}
+)
+
+func (r *sizedReader) Len() int64 {
+	return r.size
+}
+
+// AppUploadFileFromReader 从 reader 读取文件数据上传到云盘，支持个人云和家庭云。
+// param.Size 和 param.Md5 必须和 reader 的数据一致，云盘已存在相同数据时会直接秒传，不读取 reader。
+// overwrite=true 会覆盖同名文件，只对个人云有效
+func (p *PanClient) AppUploadFileFromReader(param *AppCreateUploadFileParam, reader io.Reader, overwrite bool) (*AppUploadFileCommitResult, *apierror.ApiError) {
+	return p.AppUploadFileFromReaderContext(context.Background(), param, reader, overwrite)
+}
+
+// AppUploadFileFromReaderContext 和 AppUploadFileFromReader 一致，ctx 结束时停止限速等待
+func (p *PanClient) AppUploadFileFromReaderContext(ctx context.Context, param *AppCreateUploadFileParam, reader io.Reader, overwrite bool) (*AppUploadFileCommitResult, *apierror.ApiError) {
+	var upload *AppCreateUploadFileResult
+	var apiErr *apierror.ApiError
+	if param.FamilyId > 0 {
+		upload, apiErr = p.AppFamilyCreateUploadFile(param)
+	} else {
+		upload, apiErr = p.AppCreateUploadFile(param)
+	}
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if upload.FileDataExists != 1 {
+		fileRange := &AppFileUploadRange{
+			Offset: 0,
+			Len: param.Size,
+			Reader: reader,
+		}
+		if param.FamilyId > 0 {
+			apiErr = p.AppFamilyUploadFileDataContext(ctx, param.FamilyId, upload.FileUploadUrl, upload.UploadFileId, upload.XRequestId, fileRange)
+		} else {
+			apiErr = p.AppUploadFileDataContext(ctx, upload.FileUploadUrl, upload.UploadFileId, upload.XRequestId, fileRange)
+		}
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	} else {
+		logger.Verboseln("rapid upload: " + param.FileName)
+	}
+
+	if param.FamilyId > 0 {
+		return p.AppFamilyUploadFileCommit(param.FamilyId, upload.FileCommitUrl, upload.UploadFileId, upload.XRequestId)
+	}
+	return p.AppUploadFileCommitOverwrite(upload.FileCommitUrl, upload.UploadFileId, upload.XRequestId, overwrite)
+}
+
+// AppFamilyReplaceFile 用新上传的文件替换家庭云中的同名旧文件 old：先删除旧文件，再把新文件改回 old 的文件名。
+// 家庭云上传不支持覆盖，同名的新文件会被自动重命名为 newFileName，应在新文件上传成功后再调用，
+// 这样上传失败时旧文件不受影响
+func (p *PanClient) AppFamilyReplaceFile(familyId int64, old *AppFileEntity, newFileId, newFileName string) *apierror.ApiError {
+	if apiErr := p.AppDeleteFileByFamily(familyId, AppFileList{old}); apiErr != nil {
+		return apiErr
+	}
+	if newFileName == old.FileName {
+		return nil
+	}
+	_, apiErr := p.AppFamilyRenameFile(familyId, newFileId, old.FileName)
+	return apiErr
+}
+
+// AppGetFileDownloadUrlByFamily 获取文件下载链接，familyId<=0 为个人云文件
+func (p *PanClient) AppGetFileDownloadUrlByFamily(familyId int64, fileId string) (string, *apierror.ApiError) {
+	if familyId > 0 {
+		return p.AppFamilyGetFileDownloadUrl(familyId, fileId)
+	}
+	return p.AppGetFileDownloadUrl(fileId)
+}
+
+// AppDownloadFileReader 打开文件数据流，支持个人云和家庭云以及区间下载，调用方负责关闭返回的 ReadCloser
+func (p *PanClient) AppDownloadFileReader(familyId int64, fileId string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	return p.AppDownloadFileReaderContext(context.Background(), familyId, fileId, fileRange)
+}
+
+// AppDownloadFileReaderContext 和 AppDownloadFileReader 一致，ctx 结束时停止限速等待
+func (p *PanClient) AppDownloadFileReaderContext(ctx context.Context, familyId int64, fileId string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	downloadUrl, apiErr := p.AppGetFileDownloadUrlByFamily(familyId, fileId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return p.AppDownloadUrlReaderContext(ctx, familyId, downloadUrl, fileRange)
+}
+
+// AppDownloadUrlReader 通过下载链接打开文件数据流
+func (p *PanClient) AppDownloadUrlReader(familyId int64, downloadUrl string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	return p.AppDownloadUrlReaderContext(context.Background(), familyId, downloadUrl, fileRange)
+}
+
+// AppDownloadUrlReaderContext 和 AppDownloadUrlReader 一致，ctx 结束时停止限速等待
+func (p *PanClient) AppDownloadUrlReaderContext(ctx context.Context, familyId int64, downloadUrl string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	var fullUrl string
+	var headers map[string]string
+	if familyId > 0 {
+		fullUrl, headers = p.appFamilyDownloadRequest(downloadUrl, fileRange)
+	} else {
+		fullUrl, headers = p.appDownloadRequest(downloadUrl, fileRange)
+	}
+	resp, apiErr := p.openThrottledDownload(ctx, "GET", fullUrl, headers)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return resp.Body, nil
+}
+
+// openDownloadResponse 请求文件数据，响应不是 200 或者 206 时关闭响应并返回错误
+func (p *PanClient) openDownloadResponse(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
+	resp, err := p.transferClient.Req(httpMethod, fullUrl, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		logger.Verboseln("response: " + string(data))
+		if apiErr := apierror.ParseAppCommonApiError(data); apiErr != nil {
+			return nil, apiErr
+		}
+		return nil, fmt.Errorf("下载文件数据失败: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// AppDownloadFileToWriter 下载文件数据写入 writer，返回写入的字节数
+func (p *PanClient) AppDownloadFileToWriter(familyId int64, fileId string, fileRange AppFileDownloadRange, writer io.Writer) (int64, *apierror.ApiError) {
+	body, apiErr := p.AppDownloadFileReader(familyId, fileId, fileRange)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	defer body.Close()
+	n, err := io.Copy(writer, body)
+	if err != nil {
+		return n, apierror.NewApiErrorWithError(err)
+	}
+	return n, nil
+}
@@ -15,20 +15,18 @@
 package cloudpan
 
 import (
+	"context"
 	"encoding/xml"
 	"github.com/phpc0de/ctapi/cloudpan/apierror"
 	"github.com/phpc0de/ctapi/cloudpan/apiutil"
 	"github.com/phpc0de/ctlibgo/logger"
+	"io"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
 )
 
 type (
-	// UploadFunc 上传文件处理函数
-	UploadFunc func(httpMethod, fullUrl string, headers map[string]string) (resp *http.Response, err error)
-
 	AppCreateUploadFileParam struct {
 		FamilyId int64
 		// ParentFolderId 存储云盘的目录ID
@@ -64,6 +62,8 @@ type (
 		Offset int64
 		// 总上传长度
 		Len int64
+		// Reader 这一段的数据，不能为空，由 AppUploadFileData 限速后发送
+		Reader io.Reader
 	}
 
 	AppUploadFileCommitResult struct {
@@ -140,7 +140,13 @@ func (p *PanClient) AppCreateUploadFile(param *AppCreateUploadFileParam) (*AppCr
 	return item, nil
 }
 
-func (p *PanClient) AppUploadFileData(uploadUrl, uploadFileId, xRequestId string, fileRange *AppFileUploadRange, uploadFunc UploadFunc) *apierror.ApiError {
+// AppUploadFileData 上传文件数据，受 PanClient 的限速控制，见 AppUploadFileDataContext
+func (p *PanClient) AppUploadFileData(uploadUrl, uploadFileId, xRequestId string, fileRange *AppFileUploadRange) *apierror.ApiError {
+	return p.AppUploadFileDataContext(context.Background(), uploadUrl, uploadFileId, xRequestId, fileRange)
+}
+
+// AppUploadFileDataContext 上传文件数据，发送限速后的 fileRange.Reader 中的数据，ctx 结束时停止等待
+func (p *PanClient) AppUploadFileDataContext(ctx context.Context, uploadUrl, uploadFileId, xRequestId string, fileRange *AppFileUploadRange) *apierror.ApiError {
 	fullUrl := uploadUrl + "?" + apiutil.PcClientInfoSuffixParam()
 	httpMethod := "PUT"
 	dateOfGmt := apiutil.DateOfGmtStr()
@@ -158,7 +164,7 @@ func (p *PanClient) AppUploadFileData(uploadUrl, uploadFileId, xRequestId string
 		"Expect": "100-continue",
 	}
 	logger.Verboseln("do request url: " + fullUrl)
-	resp, err1 := uploadFunc(httpMethod, fullUrl, headers)
+	resp, err1 := p.sendThrottledUploadData(ctx, httpMethod, fullUrl, headers, fileRange)
 	if err1 != nil {
 		logger.Verboseln("AppUploadFileData occurs error: ", err1.Error())
 		return apierror.NewApiErrorWithError(err1)
@@ -21,6 +21,7 @@ import (
 	"github.com/phpc0de/ctapi/cloudpan/apiutil"
 	"github.com/phpc0de/ctlibgo/logger"
 	"net/url"
+	"path"
 	"strings"
 )
 
@@ -148,3 +149,23 @@ func (p *PanClient) AppMkdirRecursive(familyId int64, parentFileId string, fullP
 	}
 }
 
+
+// AppMkdirAll 创建绝对路径对应的文件夹，已存在的文件夹直接复用，返回最后一级文件夹的信息，支持个人云和家庭云
+func (p *PanClient) AppMkdirAll(familyId int64, pathStr string) (*AppMkdirResult, *apierror.ApiError) {
+	if !path.IsAbs(pathStr) {
+		return nil, apierror.NewFailedApiError("pathStr必须是绝对路径")
+	}
+	pathStr = path.Clean(pathStr)
+	if pathStr == "/" {
+		if familyId > 0 {
+			return &AppMkdirResult{FileId: ""}, nil
+		}
+		return &AppMkdirResult{FileId: NewAppFileEntityForRootDir().FileId}, nil
+	}
+	pathSlice := strings.Split(pathStr, PathSeparator)
+	if familyId > 0 {
+		// 家庭云从根目录下的第一级开始创建
+		return p.AppMkdirRecursive(familyId, "", "", 1, pathSlice)
+	}
+	return p.AppMkdirRecursive(familyId, "", "", 0, pathSlice)
+}
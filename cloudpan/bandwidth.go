@@ -0,0 +1,165 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/throttle"
+	"github.com/phpc0de/ctlibgo/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+type (
+	// BandwidthLimit 上传下载限速配置，速度单位为字节/秒，<=0 不限速
+	BandwidthLimit struct {
+		// UploadRate 所有上传的总速度
+		UploadRate int64
+		// DownloadRate 所有下载的总速度
+		DownloadRate int64
+		// PerUploadRate 单个上传的速度
+		PerUploadRate int64
+		// PerDownloadRate 单个下载的速度
+		PerDownloadRate int64
+		// UploadSchedule 上传总速度的分时段规则，时间段内代替 UploadRate
+		UploadSchedule throttle.Schedule
+		// DownloadSchedule 下载总速度的分时段规则，时间段内代替 DownloadRate
+		DownloadSchedule throttle.Schedule
+	}
+
+	// bandwidthState PanClient 的限速状态，所有上传下载共用
+	bandwidthState struct {
+		mu              sync.Mutex
+		upload          *throttle.Limiter
+		download        *throttle.Limiter
+		perUploadRate   int64
+		perDownloadRate int64
+	}
+)
+
+func newBandwidthState() *bandwidthState {
+	return &bandwidthState{
+		upload: throttle.NewLimiter(0),
+		download: throttle.NewLimiter(0),
+	}
+}
+
+// SetBandwidthLimit 设置上传下载限速，对之后以及正在进行的传输都生效，limit=nil 取消限速。
+// 限速在 AppUploadFileData、AppDownloadFileData 等上传下载数据的接口内部执行，上传的数据和交给 DownloadFuncCallback 的响应都已经限速
+func (p *PanClient) SetBandwidthLimit(limit *BandwidthLimit) {
+	if limit == nil {
+		limit = &BandwidthLimit{}
+	}
+	if p.bandwidth == nil {
+		p.bandwidth = newBandwidthState()
+	}
+	b := p.bandwidth
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.upload.SetRate(limit.UploadRate)
+	b.upload.SetSchedule(limit.UploadSchedule)
+	b.download.SetRate(limit.DownloadRate)
+	b.download.SetSchedule(limit.DownloadSchedule)
+	b.perUploadRate = limit.PerUploadRate
+	b.perDownloadRate = limit.PerDownloadRate
+}
+
+// limiters 返回总速度限速器和单个传输的限速器
+func (b *bandwidthState) limiters(upload bool) []*throttle.Limiter {
+	if b == nil {
+		// 没有通过 NewPanClient 创建
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	global, perRate := b.download, b.perDownloadRate
+	if upload {
+		global, perRate = b.upload, b.perUploadRate
+	}
+	if perRate <= 0 {
+		return []*throttle.Limiter{global}
+	}
+	return []*throttle.Limiter{global, throttle.NewLimiter(perRate)}
+}
+
+// throttleUploadReader 上传数据流限速，ctx 结束时停止等待
+func (p *PanClient) throttleUploadReader(ctx context.Context, reader io.Reader) io.Reader {
+	return throttle.NewReader(ctx, reader, p.bandwidth.limiters(true)...)
+}
+
+// throttleDownloadReader 下载数据流限速，ctx 结束时停止等待
+func (p *PanClient) throttleDownloadReader(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	return throttle.NewReadCloser(ctx, body, p.bandwidth.limiters(false)...)
+}
+
+// sendThrottledUploadData 发送限速后的 fileRange.Reader 中的数据，ctx 结束时停止等待
+func (p *PanClient) sendThrottledUploadData(ctx context.Context, httpMethod, fullUrl string, headers map[string]string, fileRange *AppFileUploadRange) (*http.Response, error) {
+	if fileRange.Reader == nil {
+		return nil, apierror.NewApiError(apierror.ApiCodeInvalidArgument, "上传数据不能为空")
+	}
+	body := &sizedReader{Reader: p.throttleUploadReader(ctx, fileRange.Reader), size: fileRange.Len}
+	return p.sendUploadData(httpMethod, fullUrl, headers, body)
+}
+
+// openThrottledDownload 请求文件数据，返回的响应的 Body 已经替换为限速后的数据流
+func (p *PanClient) openThrottledDownload(ctx context.Context, httpMethod, fullUrl string, headers map[string]string) (*http.Response, *apierror.ApiError) {
+	resp, err := p.openDownloadResponse(httpMethod, fullUrl, headers)
+	if err != nil {
+		logger.Verboseln("AppDownloadFileData response failed")
+		if apiErr, ok := err.(*apierror.ApiError); ok {
+			return nil, apiErr
+		}
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	resp.Body = p.throttleDownloadReader(ctx, resp.Body)
+	return resp, nil
+}
+
+// handleThrottledDownload 请求文件数据并交给 downloadFunc 处理，downloadFunc 读取的数据已经受限速控制，返回后关闭 Body
+func (p *PanClient) handleThrottledDownload(ctx context.Context, httpMethod, fullUrl string, headers map[string]string, downloadFunc DownloadFuncCallback) *apierror.ApiError {
+	resp, apiErr := p.openThrottledDownload(ctx, httpMethod, fullUrl, headers)
+	if apiErr != nil {
+		return apiErr
+	}
+	defer resp.Body.Close()
+	if err := downloadFunc(resp); err != nil {
+		if apiErr, ok := err.(*apierror.ApiError); ok {
+			return apiErr
+		}
+		return apierror.NewApiErrorWithError(err)
+	}
+	return nil
+}
+
+// sendUploadData 使用不限制请求时长的 http 客户端发送上传数据
+func (p *PanClient) sendUploadData(httpMethod, fullUrl string, headers map[string]string, body *sizedReader) (*http.Response, error) {
+	resp, err := p.transferClient.Req(httpMethod, fullUrl, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		if apiErr := apierror.ParseAppCommonApiError(data); apiErr != nil {
+			return nil, apiErr
+		}
+		return nil, fmt.Errorf("上传文件数据失败: %s", resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadFileDataThrottle(t *testing.T) {
+	received := []byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	p := NewPanClient(WebLoginToken{}, AppLoginToken{})
+
+	// AppUploadFileData 自己发送 Reader 中的数据
+	fileRange := &AppFileUploadRange{Len: 5, Reader: strings.NewReader("hello")}
+	assert.Nil(t, p.AppUploadFileData(server.URL+"/upload", "1", "x", fileRange))
+	assert.Equal(t, "hello", string(received))
+
+	// 没有数据时不发送请求
+	received = nil
+	assert.NotNil(t, p.AppUploadFileData(server.URL+"/upload", "1", "x", &AppFileUploadRange{Len: 5}))
+	assert.Nil(t, received)
+
+	// 限速等待时 ctx 结束，请求已经发出但数据没有发送
+	p.SetBandwidthLimit(&BandwidthLimit{UploadRate: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fileRange = &AppFileUploadRange{Len: 5, Reader: strings.NewReader("hello")}
+	assert.NotNil(t, p.AppUploadFileDataContext(ctx, server.URL+"/upload", "1", "x", fileRange))
+	assert.NotEqual(t, "hello", string(received))
+}
+
+func TestDownloadFileDataThrottle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	p := NewPanClient(WebLoginToken{}, AppLoginToken{})
+	p.SetBandwidthLimit(&BandwidthLimit{DownloadRate: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// downloadFunc 读取的数据已经受限速控制
+	var data []byte
+	var readErr error
+	downloadFunc := func(resp *http.Response) error {
+		data, readErr = ioutil.ReadAll(resp.Body)
+		return readErr
+	}
+	assert.NotNil(t, p.AppDownloadFileDataContext(ctx, server.URL+"/file?a=1", AppFileDownloadRange{}, downloadFunc))
+	assert.Equal(t, context.Canceled, readErr)
+
+	p.SetBandwidthLimit(nil)
+	assert.Nil(t, p.AppDownloadFileDataContext(ctx, server.URL+"/file?a=1", AppFileDownloadRange{}, downloadFunc))
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"strings"
+)
+
+type (
+	// BatchTaskConflictDealWay 同名文件冲突的处理方式
+	BatchTaskConflictDealWay int
+
+	// BatchTaskConflictItem 存在冲突的文件
+	BatchTaskConflictItem struct {
+		BatchTaskInfo
+		// IsConflict 是否冲突，0-否，1-是
+		IsConflict int `json:"isConflict"`
+		// DealWay 冲突处理方式
+		DealWay BatchTaskConflictDealWay `json:"dealWay"`
+	}
+
+	// BatchTaskConflictInfo 批量任务的冲突信息
+	BatchTaskConflictInfo struct {
+		TaskId string `json:"taskId"`
+		TargetFolderId string `json:"targetFolderId"`
+		TaskInfos []*BatchTaskConflictItem `json:"taskInfos"`
+	}
+
+	// BatchTaskConflictOption 冲突处理选项，Resolver 不为空时逐个文件决定处理方式，否则所有冲突文件都使用 DealWay
+	BatchTaskConflictOption struct {
+		DealWay BatchTaskConflictDealWay
+		Resolver func(item *BatchTaskConflictItem) BatchTaskConflictDealWay
+		// WaitOption 轮询任务状态的参数，为 nil 使用默认参数
+		WaitOption *BatchTaskWaitOption
+	}
+)
+
+const (
+	// BatchTaskConflictSkip 跳过同名文件
+	BatchTaskConflictSkip BatchTaskConflictDealWay = 1
+	// BatchTaskConflictKeepBoth 保留两者，新文件自动重命名
+	BatchTaskConflictKeepBoth BatchTaskConflictDealWay = 2
+	// BatchTaskConflictOverwrite 覆盖同名文件
+	BatchTaskConflictOverwrite BatchTaskConflictDealWay = 3
+
+	// maxConflictRounds 处理冲突后任务再次冲突时的最大处理次数
+	maxConflictRounds = 5
+)
+
+// Conflicts 获取存在冲突的文件
+func (c *BatchTaskConflictInfo) Conflicts() []*BatchTaskConflictItem {
+	list := []*BatchTaskConflictItem{}
+	for _, item := range c.TaskInfos {
+		if item.IsConflict == 1 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// GetConflictTaskInfo 获取冲突暂停的批量任务的冲突信息，只支持个人云的复制、移动和转存任务
+func (p *PanClient) GetConflictTaskInfo(typeFlag BatchTaskType, taskId string) (*BatchTaskConflictInfo, *apierror.ApiError) {
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/getConflictTaskInfo.action", WEB_URL)
+	logger.Verboseln("do request url: " + fullUrl.String())
+	postData := map[string]string {
+		"type": string(typeFlag),
+		"taskId": taskId,
+	}
+	body, err := p.client.DoPost(fullUrl.String(), postData)
+	if err != nil {
+		logger.Verboseln("GetConflictTaskInfo failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	item := &BatchTaskConflictInfo{}
+	if err := json.Unmarshal(body, item); err != nil {
+		logger.Verboseln("GetConflictTaskInfo response failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	return item, nil
+}
+
+// ManageBatchTask 提交冲突文件的处理方式，任务会继续执行
+func (p *PanClient) ManageBatchTask(typeFlag BatchTaskType, taskId, targetFolderId string, taskInfos []*BatchTaskConflictItem) *apierror.ApiError {
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/manageBatchTask.action", WEB_URL)
+	logger.Verboseln("do request url: " + fullUrl.String())
+	taskInfosStr, _ := json.Marshal(taskInfos)
+	postData := map[string]string {
+		"type": string(typeFlag),
+		"taskId": taskId,
+		"targetFolderId": targetFolderId,
+		"taskInfos": string(taskInfosStr),
+	}
+	body, err := p.client.DoPost(fullUrl.String(), postData)
+	if err != nil {
+		logger.Verboseln("ManageBatchTask failed")
+		return apierror.NewApiErrorWithError(err)
+	}
+	comResp := &apierror.ErrorResp{}
+	if err := json.Unmarshal(body, comResp); err == nil {
+		if comResp.ErrorCode != "" {
+			logger.Verboseln("response failed", comResp)
+			return apierror.NewFailedApiError("处理冲突失败")
+		}
+	}
+	return nil
+}
+
+// resolveConflicts 根据选项设置每个冲突文件的处理方式，未冲突的文件保持不变
+func resolveConflicts(info *BatchTaskConflictInfo, opt *BatchTaskConflictOption) []*BatchTaskConflictItem {
+	for _, item := range info.TaskInfos {
+		if item.IsConflict != 1 {
+			continue
+		}
+		dealWay := opt.DealWay
+		if opt.Resolver != nil {
+			dealWay = opt.Resolver(item)
+		}
+		if dealWay < BatchTaskConflictSkip || dealWay > BatchTaskConflictOverwrite {
+			dealWay = BatchTaskConflictSkip
+		}
+		item.DealWay = dealWay
+	}
+	return info.TaskInfos
+}
+
+// ExecuteBatchTask 创建个人云批量任务并等待任务结束，任务因同名文件冲突暂停时按 conflict 处理后继续等待。
+// conflict 为 nil 时遇到冲突直接返回 ApiCodeBatchTaskConflict 错误，可以通过 GetConflictTaskInfo 查看冲突文件
+func (p *PanClient) ExecuteBatchTask(ctx context.Context, param *BatchTaskParam, conflict *BatchTaskConflictOption) (*CheckTaskResult, *apierror.ApiError) {
+	taskId, apiErr := p.CreateBatchTask(param)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if taskId == "" {
+		return nil, apierror.NewFailedApiError("创建批量任务失败")
+	}
+	var waitOption *BatchTaskWaitOption
+	if conflict != nil {
+		waitOption = conflict.WaitOption
+	}
+
+	for round := 0; ; round++ {
+		r, apiErr := p.WaitBatchTask(ctx, 0, param.TypeFlag, taskId, waitOption)
+		if apiErr == nil || apiErr.Code != apierror.ApiCodeBatchTaskConflict || conflict == nil || round >= maxConflictRounds {
+			return r, apiErr
+		}
+
+		info, apiErr := p.GetConflictTaskInfo(param.TypeFlag, taskId)
+		if apiErr != nil {
+			return r, apiErr
+		}
+		targetFolderId := info.TargetFolderId
+		if targetFolderId == "" {
+			targetFolderId = param.TargetFolderId
+		}
+		logger.Verboseln("batch task conflict: ", taskId, ", count: ", len(info.Conflicts()))
+		if apiErr := p.ManageBatchTask(param.TypeFlag, taskId, targetFolderId, resolveConflicts(info, conflict)); apiErr != nil {
+			return r, apiErr
+		}
+	}
+}
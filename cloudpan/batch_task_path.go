@@ -0,0 +1,271 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// BatchTaskSummary 多个批量任务的汇总结果
+	BatchTaskSummary struct {
+		// TaskIdList 创建的所有任务ID
+		TaskIdList []string
+		// FileList 参与任务的所有文件
+		FileList AppFileList
+		SubTaskCount int
+		SuccessedCount int
+		FailedCount int
+		SkipCount int
+		SuccessedFileIdList []int64
+	}
+
+	listFolderFunc func(folder *AppFileEntity) (AppFileList, *apierror.ApiError)
+)
+
+var (
+	// BatchTaskMaxFileCount 单个批量任务最多包含的文件数量，超过的会拆分为多个任务依次执行
+	BatchTaskMaxFileCount = 500
+)
+
+func (s *BatchTaskSummary) add(taskId string, r *CheckTaskResult) {
+	s.TaskIdList = append(s.TaskIdList, taskId)
+	if r == nil {
+		return
+	}
+	s.SubTaskCount += r.SubTaskCount
+	s.SuccessedCount += r.SuccessedCount
+	s.FailedCount += r.FailedCount
+	s.SkipCount += r.SkipCount
+	s.SuccessedFileIdList = append(s.SuccessedFileIdList, r.SuccessedFileIdList...)
+}
+
+// hasGlobMeta 路径中是否包含通配符
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+// AppMatchPathByShellPattern 获取匹配通配符的文件，通配符规则同 path.Match，例如 /照片/2023/*.jpg，
+// 不含通配符的路径必须存在，返回的文件按路径排序并去重，Path 为完整路径
+func (p *PanClient) AppMatchPathByShellPattern(familyId int64, patterns ...string) (AppFileList, *apierror.ApiError) {
+	list := func(folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+		param := NewAppFileListParam()
+		param.FamilyId = familyId
+		param.FileId = folder.FileId
+		r, apiErr := p.AppGetAllFileList(param)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return r.FileList, nil
+	}
+
+	result := AppFileList{}
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		if !path.IsAbs(pattern) {
+			return nil, apierror.NewFailedApiError("路径必须是绝对路径: " + pattern)
+		}
+		pattern = path.Clean(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, apierror.NewFailedApiError("通配符格式错误: " + pattern)
+		}
+
+		// 不含通配符的前缀直接通过路径获取
+		segments := strings.Split(pattern, PathSeparator)[1:]
+		literal := 0
+		for literal < len(segments) && !hasGlobMeta(segments[literal]) {
+			literal++
+		}
+		basePath := "/" + strings.Join(segments[:literal], PathSeparator)
+		base, apiErr := p.AppFileInfoByPath(familyId, basePath)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		base.Path = basePath
+
+		files, apiErr := matchPathSegments(base, segments[literal:], list)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		for _, fe := range files {
+			if !seen[fe.FileId] {
+				seen[fe.FileId] = true
+				result = append(result, fe)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+// matchPathSegments 从 base 开始逐级匹配通配符
+func matchPathSegments(base *AppFileEntity, segments []string, list listFolderFunc) (AppFileList, *apierror.ApiError) {
+	if len(segments) == 0 {
+		return AppFileList{base}, nil
+	}
+	if !base.IsFolder {
+		return AppFileList{}, nil
+	}
+	children, apiErr := list(base)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	result := AppFileList{}
+	for _, fe := range children {
+		if ok, _ := path.Match(segments[0], fe.FileName); !ok {
+			continue
+		}
+		fe.Path = path.Join(base.Path, fe.FileName)
+		files, apiErr := matchPathSegments(fe, segments[1:], list)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result = append(result, files...)
+	}
+	return result, nil
+}
+
+// splitBatchTaskInfoList 将任务文件按数量拆分
+func splitBatchTaskInfoList(infoList BatchTaskInfoList, size int) []BatchTaskInfoList {
+	if size <= 0 {
+		size = len(infoList)
+	}
+	chunks := []BatchTaskInfoList{}
+	for start := 0; start < len(infoList); start += size {
+		end := start + size
+		if end > len(infoList) {
+			end = len(infoList)
+		}
+		chunks = append(chunks, infoList[start:end])
+	}
+	return chunks
+}
+
+func newBatchTaskInfoList(fileList AppFileList) BatchTaskInfoList {
+	infoList := BatchTaskInfoList{}
+	for _, fe := range fileList {
+		isFolder := 0
+		if fe.IsFolder {
+			isFolder = 1
+		}
+		infoList = append(infoList, &BatchTaskInfo{
+			FileId: fe.FileId,
+			FileName: fe.FileName,
+			IsFolder: isFolder,
+			SrcParentId: fe.ParentId,
+		})
+	}
+	return infoList
+}
+
+// BatchCopyByPath 复制匹配通配符的文件到目标文件夹，目标文件夹不存在会自动创建，只支持个人云。
+// conflict 为 nil 时遇到同名文件返回 ApiCodeBatchTaskConflict 错误
+func (p *PanClient) BatchCopyByPath(ctx context.Context, srcPatterns []string, destPath string, conflict *BatchTaskConflictOption) (*BatchTaskSummary, *apierror.ApiError) {
+	return p.batchTransferByPath(ctx, BatchTaskTypeCopy, srcPatterns, destPath, conflict)
+}
+
+// BatchMoveByPath 移动匹配通配符的文件到目标文件夹，目标文件夹不存在会自动创建，只支持个人云。
+// conflict 为 nil 时遇到同名文件返回 ApiCodeBatchTaskConflict 错误
+func (p *PanClient) BatchMoveByPath(ctx context.Context, srcPatterns []string, destPath string, conflict *BatchTaskConflictOption) (*BatchTaskSummary, *apierror.ApiError) {
+	return p.batchTransferByPath(ctx, BatchTaskTypeMove, srcPatterns, destPath, conflict)
+}
+
+func (p *PanClient) batchTransferByPath(ctx context.Context, typeFlag BatchTaskType, srcPatterns []string, destPath string, conflict *BatchTaskConflictOption) (*BatchTaskSummary, *apierror.ApiError) {
+	fileList, apiErr := p.AppMatchPathByShellPattern(0, srcPatterns...)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	destPath = path.Clean(destPath)
+	for _, fe := range fileList {
+		if fe.IsFolder && (destPath == fe.Path || strings.HasPrefix(destPath, fe.Path+"/")) {
+			return nil, apierror.NewFailedApiError("不能复制或移动文件夹到自身或者其子文件夹中: " + fe.Path)
+		}
+	}
+	summary := &BatchTaskSummary{FileList: fileList}
+	if len(fileList) == 0 {
+		return summary, nil
+	}
+	dest, apiErr := p.AppMkdirAll(0, destPath)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	for _, chunk := range splitBatchTaskInfoList(newBatchTaskInfoList(fileList), BatchTaskMaxFileCount) {
+		r, apiErr := p.ExecuteBatchTask(ctx, &BatchTaskParam{
+			TypeFlag: typeFlag,
+			TaskInfos: chunk,
+			TargetFolderId: dest.FileId,
+		}, conflict)
+		if r != nil {
+			summary.add(r.TaskId, r)
+		}
+		if apiErr != nil {
+			return summary, apiErr
+		}
+	}
+	return summary, nil
+}
+
+// BatchDeleteByPath 删除匹配通配符的文件，删除的文件会进入回收站，familyId<=0 为个人云
+func (p *PanClient) BatchDeleteByPath(ctx context.Context, familyId int64, patterns ...string) (*BatchTaskSummary, *apierror.ApiError) {
+	fileList, apiErr := p.AppMatchPathByShellPattern(familyId, patterns...)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	for _, fe := range fileList {
+		if fe.Path == "/" {
+			return nil, apierror.NewFailedApiError("不能删除根目录")
+		}
+	}
+	return p.batchDeleteFileList(ctx, familyId, fileList)
+}
+
+// batchDeleteFileList 分批删除文件并等待任务结束，删除的文件会进入回收站
+func (p *PanClient) batchDeleteFileList(ctx context.Context, familyId int64, fileList AppFileList) (*BatchTaskSummary, *apierror.ApiError) {
+	summary := &BatchTaskSummary{FileList: fileList}
+	for _, chunk := range splitBatchTaskInfoList(newBatchTaskInfoList(fileList), BatchTaskMaxFileCount) {
+		param := &BatchTaskParam{
+			TypeFlag: BatchTaskTypeDelete,
+			TaskInfos: chunk,
+		}
+		if familyId <= 0 {
+			r, apiErr := p.ExecuteBatchTask(ctx, param, nil)
+			if r != nil {
+				summary.add(r.TaskId, r)
+			}
+			if apiErr != nil {
+				return summary, apiErr
+			}
+			continue
+		}
+
+		taskId, apiErr := p.AppCreateBatchTask(familyId, param)
+		if apiErr != nil {
+			return summary, apiErr
+		}
+		r, apiErr := p.WaitBatchTask(ctx, familyId, BatchTaskTypeDelete, taskId, nil)
+		summary.add(taskId, r)
+		if apiErr != nil {
+			return summary, apiErr
+		}
+	}
+	return summary, nil
+}
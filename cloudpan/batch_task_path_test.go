@@ -0,0 +1,66 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchPathSegments(t *testing.T) {
+	tree := map[string]AppFileList{
+		"1": {
+			{FileId: "2", FileName: "2023", IsFolder: true},
+			{FileId: "3", FileName: "2024", IsFolder: true},
+			{FileId: "4", FileName: "readme.txt"},
+		},
+		"2": {
+			{FileId: "5", FileName: "a.jpg"},
+			{FileId: "6", FileName: "b.png"},
+		},
+		"3": {
+			{FileId: "7", FileName: "c.jpg"},
+		},
+	}
+	list := func(folder *AppFileEntity) (AppFileList, *apierror.ApiError) {
+		return tree[folder.FileId], nil
+	}
+	base := &AppFileEntity{FileId: "1", FileName: "照片", Path: "/照片", IsFolder: true}
+
+	files, err := matchPathSegments(base, []string{"*", "*.jpg"}, list)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, "/照片/2023/a.jpg", files[0].Path)
+	assert.Equal(t, "/照片/2024/c.jpg", files[1].Path)
+
+	files, _ = matchPathSegments(base, []string{"202[3]"}, list)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "2", files[0].FileId)
+
+	files, _ = matchPathSegments(base, []string{"readme.txt", "*"}, list)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestSplitBatchTaskInfoList(t *testing.T) {
+	infoList := BatchTaskInfoList{}
+	for i := 0; i < 5; i++ {
+		infoList = append(infoList, &BatchTaskInfo{})
+	}
+	chunks := splitBatchTaskInfoList(infoList, 2)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, 1, len(chunks[2]))
+	assert.Equal(t, 1, len(splitBatchTaskInfoList(infoList, 0)))
+}
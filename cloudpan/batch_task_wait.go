@@ -0,0 +1,123 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"time"
+)
+
+type (
+	// BatchTaskWaitOption 等待批量任务的轮询参数
+	BatchTaskWaitOption struct {
+		// Interval 第一次轮询的间隔
+		Interval time.Duration
+		// MaxInterval 轮询间隔的最大值，每次轮询后间隔翻倍
+		MaxInterval time.Duration
+		// MaxRetry 查询任务状态出错时的最大重试次数
+		MaxRetry int
+	}
+
+	checkBatchTaskFunc func() (*CheckTaskResult, *apierror.ApiError)
+)
+
+const (
+	// BatchTaskStatusPending 任务准备中
+	BatchTaskStatusPending BatchTaskStatus = 1
+	// BatchTaskStatusRunning 任务执行中
+	BatchTaskStatusRunning BatchTaskStatus = 3
+)
+
+// NewBatchTaskWaitOption 默认的轮询参数
+func NewBatchTaskWaitOption() *BatchTaskWaitOption {
+	return &BatchTaskWaitOption{
+		Interval: 200 * time.Millisecond,
+		MaxInterval: 3 * time.Second,
+		MaxRetry: 3,
+	}
+}
+
+// IsRunning 任务是否还在执行中
+func (r *CheckTaskResult) IsRunning() bool {
+	return r.TaskStatus == BatchTaskStatusPending || r.TaskStatus == BatchTaskStatusRunning
+}
+
+// IsConflict 任务是否因为同名文件冲突而暂停，需要处理冲突后才能继续执行
+func (r *CheckTaskResult) IsConflict() bool {
+	return r.TaskStatus == BatchTaskStatusNotAction && r.SuccessedCount+r.SkipCount+r.FailedCount < r.SubTaskCount
+}
+
+// err 将已结束的任务结果转换为错误，任务全部成功时返回 nil
+func (r *CheckTaskResult) err() *apierror.ApiError {
+	if r.IsConflict() {
+		return apierror.NewApiError(apierror.ApiCodeBatchTaskConflict,
+			fmt.Sprintf("批量任务存在同名文件冲突，任务ID：%s", r.TaskId))
+	}
+	if r.FailedCount > 0 || (r.TaskStatus != BatchTaskStatusOk && r.TaskStatus != BatchTaskStatusNotAction) {
+		return apierror.NewApiError(apierror.ApiCodeBatchTaskFailed,
+			fmt.Sprintf("批量任务执行失败，任务ID：%s，状态：%d，失败数：%d", r.TaskId, r.TaskStatus, r.FailedCount))
+	}
+	return nil
+}
+
+// WaitBatchTask 轮询批量任务直到任务结束，familyId<=0 为个人云。
+// 任务失败返回 ApiCodeBatchTaskFailed，存在同名冲突返回 ApiCodeBatchTaskConflict，ctx 取消或超时返回 ApiCodeCanceled，
+// 只要查询到过任务状态，都会返回最后一次查询的结果。opt 为 nil 使用默认参数
+func (p *PanClient) WaitBatchTask(ctx context.Context, familyId int64, typeFlag BatchTaskType, taskId string, opt *BatchTaskWaitOption) (*CheckTaskResult, *apierror.ApiError) {
+	return waitBatchTask(ctx, func() (*CheckTaskResult, *apierror.ApiError) {
+		if familyId > 0 {
+			return p.AppCheckBatchTask(typeFlag, taskId)
+		}
+		return p.CheckBatchTask(typeFlag, taskId)
+	}, opt)
+}
+
+func waitBatchTask(ctx context.Context, check checkBatchTaskFunc, opt *BatchTaskWaitOption) (*CheckTaskResult, *apierror.ApiError) {
+	if opt == nil {
+		opt = NewBatchTaskWaitOption()
+	}
+	interval := opt.Interval
+	retry := 0
+	var last *CheckTaskResult
+	for {
+		r, apiErr := check()
+		if apiErr != nil {
+			retry++
+			if retry > opt.MaxRetry {
+				return last, apiErr
+			}
+			logger.Verboseln("check batch task failed, retry ", retry, ": ", apiErr)
+		} else {
+			retry = 0
+			last = r
+			if !r.IsRunning() {
+				return r, r.err()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return last, apierror.NewApiError(apierror.ApiCodeCanceled, ctx.Err().Error())
+		case <-time.After(interval):
+		}
+		interval *= 2
+		if interval > opt.MaxInterval {
+			interval = opt.MaxInterval
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWaitBatchTask(t *testing.T) {
+	opt := &BatchTaskWaitOption{Interval: time.Millisecond, MaxInterval: 4 * time.Millisecond, MaxRetry: 1}
+	sequence := func(results ...*CheckTaskResult) checkBatchTaskFunc {
+		i := 0
+		return func() (*CheckTaskResult, *apierror.ApiError) {
+			r := results[i]
+			if i < len(results)-1 {
+				i++
+			}
+			if r == nil {
+				return nil, apierror.NewFailedApiError("network error")
+			}
+			return r, nil
+		}
+	}
+
+	// 执行中 -> 查询出错 -> 成功
+	r, err := waitBatchTask(context.Background(), sequence(
+		&CheckTaskResult{TaskId: "1", TaskStatus: BatchTaskStatusRunning, SubTaskCount: 2},
+		nil,
+		&CheckTaskResult{TaskId: "1", TaskStatus: BatchTaskStatusOk, SubTaskCount: 2, SuccessedCount: 2, SuccessedFileIdList: []int64{11, 12}},
+	), opt)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{11, 12}, r.SuccessedFileIdList)
+
+	// 部分失败
+	r, err = waitBatchTask(context.Background(), sequence(
+		&CheckTaskResult{TaskId: "2", TaskStatus: BatchTaskStatusOk, SubTaskCount: 2, SuccessedCount: 1, FailedCount: 1},
+	), opt)
+	assert.Equal(t, apierror.ApiCode(apierror.ApiCodeBatchTaskFailed), err.Code)
+	assert.Equal(t, 1, r.FailedCount)
+
+	// 同名冲突
+	r, err = waitBatchTask(context.Background(), sequence(
+		&CheckTaskResult{TaskId: "3", TaskStatus: BatchTaskStatusNotAction, SubTaskCount: 2, SuccessedCount: 1},
+	), opt)
+	assert.Equal(t, apierror.ApiCode(apierror.ApiCodeBatchTaskConflict), err.Code)
+
+	// 连续出错超过重试次数
+	r, err = waitBatchTask(context.Background(), sequence(nil), opt)
+	assert.Nil(t, r)
+	assert.Equal(t, apierror.ApiCodeFailed, err.Code)
+
+	// 取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, err = waitBatchTask(ctx, sequence(
+		&CheckTaskResult{TaskId: "4", TaskStatus: BatchTaskStatusRunning},
+	), opt)
+	assert.Equal(t, apierror.ApiCode(apierror.ApiCodeCanceled), err.Code)
+	assert.Equal(t, "4", r.TaskId)
+}
+
+func TestResolveConflicts(t *testing.T) {
+	info := &BatchTaskConflictInfo{
+		TaskInfos: []*BatchTaskConflictItem{
+			{BatchTaskInfo: BatchTaskInfo{FileId: "1", FileName: "a.txt"}, IsConflict: 1},
+			{BatchTaskInfo: BatchTaskInfo{FileId: "2", FileName: "b.txt"}, IsConflict: 0},
+			{BatchTaskInfo: BatchTaskInfo{FileId: "3", FileName: "c.txt"}, IsConflict: 1},
+		},
+	}
+	assert.Equal(t, 2, len(info.Conflicts()))
+
+	items := resolveConflicts(info, &BatchTaskConflictOption{DealWay: BatchTaskConflictOverwrite})
+	assert.Equal(t, BatchTaskConflictOverwrite, items[0].DealWay)
+	assert.Equal(t, BatchTaskConflictDealWay(0), items[1].DealWay)
+
+	items = resolveConflicts(info, &BatchTaskConflictOption{Resolver: func(item *BatchTaskConflictItem) BatchTaskConflictDealWay {
+		if item.FileName == "a.txt" {
+			return BatchTaskConflictKeepBoth
+		}
+		return 0
+	}})
+	assert.Equal(t, BatchTaskConflictKeepBoth, items[0].DealWay)
+	assert.Equal(t, BatchTaskConflictSkip, items[2].DealWay)
+}
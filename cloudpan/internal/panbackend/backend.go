@@ -0,0 +1,208 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package panbackend panfs、webdav 和 s3gateway 共用的云盘文件操作，测试时使用内存实现 Memory 代替 PanClient
+package panbackend
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// Backend 云盘文件操作，familyId<=0 为个人云。返回的错误为 *apierror.ApiError
+	Backend interface {
+		// Stat 通过绝对路径获取文件信息，不存在时返回 ApiCodeFileNotFoundCode 错误
+		Stat(familyId int64, pathStr string) (*cloudpan.AppFileEntity, error)
+		// List 获取文件夹下的所有文件
+		List(familyId int64, folderId string) (cloudpan.AppFileList, error)
+		// Open 从 offset 开始打开文件数据流，end 为包含的结束位置，小于0表示读取到文件末尾
+		Open(familyId int64, fileId string, offset, end int64) (io.ReadCloser, error)
+		Mkdir(familyId int64, parentId, name string) error
+		// MkdirAll 创建文件夹以及不存在的上级文件夹，返回文件夹ID
+		MkdirAll(familyId int64, pathStr string) (string, error)
+		Remove(familyId int64, fe *cloudpan.AppFileEntity) error
+		Move(familyId int64, fe *cloudpan.AppFileEntity, targetId string) error
+		Rename(familyId int64, fe *cloudpan.AppFileEntity, newName string) error
+		// Upload 上传文件到文件夹 parentId，个人云覆盖同名文件，家庭云上传成功后再替换已存在的同名文件 old
+		Upload(familyId int64, parentId, name string, size int64, md5 string, reader io.Reader, old *cloudpan.AppFileEntity) (*cloudpan.AppFileEntity, error)
+		// Families 获取所有家庭云
+		Families() ([]*cloudpan.AppFamilyInfo, error)
+	}
+
+	// Client 使用 PanClient 实现的 Backend
+	Client struct {
+		client *cloudpan.PanClient
+	}
+
+	limitReadCloser struct {
+		io.Reader
+		io.Closer
+	}
+)
+
+// NewClient 创建使用 PanClient 的 Backend
+func NewClient(client *cloudpan.PanClient) *Client {
+	return &Client{client: client}
+}
+
+// IsNotFound 是否为文件不存在的错误
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*apierror.ApiError)
+	return ok && apiErr.Code == apierror.ApiCodeFileNotFoundCode
+}
+
+// Stat 通过绝对路径获取文件信息
+func (c *Client) Stat(familyId int64, pathStr string) (*cloudpan.AppFileEntity, error) {
+	fe, apiErr := c.client.AppFileInfoByPath(familyId, pathStr)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return fe, nil
+}
+
+// List 获取文件夹下的所有文件
+func (c *Client) List(familyId int64, folderId string) (cloudpan.AppFileList, error) {
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = familyId
+	param.FileId = folderId
+	r, apiErr := c.client.AppGetAllFileList(param)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if r.FileList == nil {
+		return cloudpan.AppFileList{}, nil
+	}
+	return r.FileList, nil
+}
+
+// Open 打开文件数据流
+func (c *Client) Open(familyId int64, fileId string, offset, end int64) (io.ReadCloser, error) {
+	fileRange := cloudpan.AppFileDownloadRange{Offset: offset}
+	if end >= 0 {
+		fileRange.End = end
+	}
+	rc, apiErr := c.client.AppDownloadFileReader(familyId, fileId, fileRange)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if end >= 0 {
+		// End 为0时服务端会返回整个文件，这里统一截断
+		return &limitReadCloser{Reader: io.LimitReader(rc, end-offset+1), Closer: rc}, nil
+	}
+	return rc, nil
+}
+
+// Mkdir 创建文件夹
+func (c *Client) Mkdir(familyId int64, parentId, name string) error {
+	if _, apiErr := c.client.AppMkdir(familyId, parentId, name); apiErr != nil {
+		return apiErr
+	}
+	return nil
+}
+
+// MkdirAll 创建文件夹以及不存在的上级文件夹
+func (c *Client) MkdirAll(familyId int64, pathStr string) (string, error) {
+	r, apiErr := c.client.AppMkdirAll(familyId, pathStr)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	return r.FileId, nil
+}
+
+// Remove 删除文件或文件夹，删除的文件会进入回收站
+func (c *Client) Remove(familyId int64, fe *cloudpan.AppFileEntity) error {
+	if apiErr := c.client.AppDeleteFileByFamily(familyId, cloudpan.AppFileList{fe}); apiErr != nil {
+		return apiErr
+	}
+	return nil
+}
+
+// Move 移动文件或文件夹到 targetId
+func (c *Client) Move(familyId int64, fe *cloudpan.AppFileEntity, targetId string) error {
+	var apiErr *apierror.ApiError
+	if familyId > 0 {
+		_, apiErr = c.client.AppFamilyMoveFile(familyId, fe.FileId, targetId)
+	} else {
+		_, apiErr = c.client.AppMoveFile([]string{fe.FileId}, targetId)
+	}
+	if apiErr != nil {
+		return apiErr
+	}
+	return nil
+}
+
+// Rename 重命名文件或文件夹
+func (c *Client) Rename(familyId int64, fe *cloudpan.AppFileEntity, newName string) error {
+	var apiErr *apierror.ApiError
+	if familyId > 0 {
+		_, apiErr = c.client.AppFamilyRenameFile(familyId, fe.FileId, newName)
+	} else if fe.IsFolder {
+		_, apiErr = c.client.AppRenameFolder(fe.FileId, newName)
+	} else {
+		_, apiErr = c.client.AppRenameFile(fe.FileId, newName)
+	}
+	if apiErr != nil {
+		return apiErr
+	}
+	return nil
+}
+
+// Upload 上传文件，reader 为本地文件时支持断点续传
+func (c *Client) Upload(familyId int64, parentId, name string, size int64, md5 string, reader io.Reader, old *cloudpan.AppFileEntity) (*cloudpan.AppFileEntity, error) {
+	now := time.Now()
+	param := &cloudpan.AppCreateUploadFileParam{
+		FamilyId: familyId,
+		ParentFolderId: parentId,
+		FileName: name,
+		Size: size,
+		Md5: strings.ToUpper(md5),
+		LastWrite: apiutil.FormatTime(now),
+	}
+	if f, ok := reader.(*os.File); ok {
+		param.LocalPath = f.Name()
+	}
+	r, apiErr := c.client.AppUploadFileFromReader(param, reader, true)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if familyId > 0 && old != nil && !old.IsFolder {
+		if apiErr := c.client.AppFamilyReplaceFile(familyId, old, r.Id, r.Name); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	return &cloudpan.AppFileEntity{
+		FileId: r.Id,
+		ParentId: parentId,
+		FileName: name,
+		FileSize: size,
+		FileMd5: md5,
+		LastOpTime: apiutil.FormatTime(now),
+		Rev: r.Rev,
+	}, nil
+}
+
+// Families 获取所有家庭云
+func (c *Client) Families() ([]*cloudpan.AppFamilyInfo, error) {
+	r, apiErr := c.client.AppFamilyGetFamilyList()
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return r.FamilyInfoList, nil
+}
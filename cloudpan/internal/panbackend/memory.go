@@ -0,0 +1,331 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package panbackend
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Memory 内存中的云盘，用于测试。每个家庭云和个人云各有一个根目录，根目录的文件夹ID为 -11 或者空
+	Memory struct {
+		entities  map[string]*cloudpan.AppFileEntity
+		familyIds map[string]int64
+		data      map[string][]byte
+		families  []*cloudpan.AppFamilyInfo
+		nextId    int
+		lock      sync.Mutex
+	}
+)
+
+const (
+	// MemoryLastOpTime Memory 中所有文件的修改时间
+	MemoryLastOpTime = "2021-03-04 05:06:07"
+)
+
+var (
+	rootId = cloudpan.NewAppFileEntityForRootDir().FileId
+)
+
+// NewMemory 创建只有个人云根目录的内存云盘
+func NewMemory() *Memory {
+	return &Memory{
+		entities: map[string]*cloudpan.AppFileEntity{},
+		familyIds: map[string]int64{},
+		data: map[string][]byte{},
+	}
+}
+
+func notFound() error {
+	return apierror.NewApiError(apierror.ApiCodeFileNotFoundCode, "文件不存在")
+}
+
+// AddFamily 添加家庭云，Families 会返回添加的家庭云
+func (m *Memory) AddFamily(familyId int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.families = append(m.families, &cloudpan.AppFamilyInfo{FamilyId: familyId})
+}
+
+// Add 添加文件，不存在的上级文件夹会被创建，同名文件会被替换
+func (m *Memory) Add(familyId int64, pathStr string, data []byte) *cloudpan.AppFileEntity {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	parentId := m.mkdirAll(familyId, path.Dir(path.Clean(pathStr)))
+	return m.copyOf(m.create(familyId, parentId, path.Base(pathStr), false, data))
+}
+
+// folder 获取文件夹，家庭云根目录的ID为空
+func (m *Memory) folder(familyId int64, folderId string) *cloudpan.AppFileEntity {
+	if folderId == "" || folderId == rootId {
+		return cloudpan.NewAppFileEntityForRootDir()
+	}
+	if fe, ok := m.entities[folderId]; ok && fe.IsFolder && m.familyIds[folderId] == familyId {
+		return fe
+	}
+	return nil
+}
+
+func (m *Memory) children(familyId int64, folderId string) cloudpan.AppFileList {
+	if folderId == "" {
+		folderId = rootId
+	}
+	list := cloudpan.AppFileList{}
+	for id, fe := range m.entities {
+		if fe.ParentId == folderId && m.familyIds[id] == familyId {
+			list = append(list, fe)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FileName < list[j].FileName
+	})
+	return list
+}
+
+func (m *Memory) child(familyId int64, folderId, name string) *cloudpan.AppFileEntity {
+	for _, fe := range m.children(familyId, folderId) {
+		if fe.FileName == name {
+			return fe
+		}
+	}
+	return nil
+}
+
+// lookup 通过绝对路径查找文件
+func (m *Memory) lookup(familyId int64, pathStr string) *cloudpan.AppFileEntity {
+	cur := cloudpan.NewAppFileEntityForRootDir()
+	for _, name := range strings.Split(path.Clean("/"+pathStr), "/") {
+		if name == "" {
+			continue
+		}
+		if !cur.IsFolder {
+			return nil
+		}
+		if cur = m.child(familyId, cur.FileId, name); cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// copyOf 返回带有绝对路径的副本，调用者修改返回值不影响云盘中的文件
+func (m *Memory) copyOf(fe *cloudpan.AppFileEntity) *cloudpan.AppFileEntity {
+	c := *fe
+	names := []string{}
+	for p := fe; p.FileId != rootId; p = m.entities[p.ParentId] {
+		names = append([]string{p.FileName}, names...)
+		if p.ParentId == rootId {
+			break
+		}
+	}
+	c.Path = "/" + strings.Join(names, "/")
+	return &c
+}
+
+func (m *Memory) create(familyId int64, parentId, name string, isFolder bool, data []byte) *cloudpan.AppFileEntity {
+	if parentId == "" {
+		parentId = rootId
+	}
+	if old := m.child(familyId, parentId, name); old != nil {
+		m.remove(old.FileId)
+	}
+	m.nextId++
+	sum := md5.Sum(data)
+	fe := &cloudpan.AppFileEntity{
+		FileId: strconv.Itoa(m.nextId),
+		ParentId: parentId,
+		FileName: name,
+		IsFolder: isFolder,
+		LastOpTime: MemoryLastOpTime,
+	}
+	if !isFolder {
+		fe.FileSize = int64(len(data))
+		fe.FileMd5 = strings.ToUpper(hex.EncodeToString(sum[:]))
+		m.data[fe.FileId] = data
+	}
+	m.entities[fe.FileId] = fe
+	m.familyIds[fe.FileId] = familyId
+	return fe
+}
+
+func (m *Memory) mkdirAll(familyId int64, pathStr string) string {
+	cur := cloudpan.NewAppFileEntityForRootDir()
+	for _, name := range strings.Split(path.Clean("/"+pathStr), "/") {
+		if name == "" {
+			continue
+		}
+		next := m.child(familyId, cur.FileId, name)
+		if next == nil || !next.IsFolder {
+			next = m.create(familyId, cur.FileId, name, true, nil)
+		}
+		cur = next
+	}
+	return cur.FileId
+}
+
+func (m *Memory) remove(fileId string) {
+	fe, ok := m.entities[fileId]
+	if !ok {
+		return
+	}
+	if fe.IsFolder {
+		for _, c := range m.children(m.familyIds[fileId], fileId) {
+			m.remove(c.FileId)
+		}
+	}
+	delete(m.entities, fileId)
+	delete(m.familyIds, fileId)
+	delete(m.data, fileId)
+}
+
+// Stat 通过绝对路径获取文件信息
+func (m *Memory) Stat(familyId int64, pathStr string) (*cloudpan.AppFileEntity, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	fe := m.lookup(familyId, pathStr)
+	if fe == nil {
+		return nil, notFound()
+	}
+	return m.copyOf(fe), nil
+}
+
+// List 获取文件夹下的所有文件
+func (m *Memory) List(familyId int64, folderId string) (cloudpan.AppFileList, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.folder(familyId, folderId) == nil {
+		return nil, notFound()
+	}
+	list := cloudpan.AppFileList{}
+	for _, fe := range m.children(familyId, folderId) {
+		list = append(list, m.copyOf(fe))
+	}
+	return list, nil
+}
+
+// Open 打开文件数据流
+func (m *Memory) Open(familyId int64, fileId string, offset, end int64) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.data[fileId]
+	if !ok || m.familyIds[fileId] != familyId {
+		return nil, notFound()
+	}
+	if end < 0 || end >= int64(len(data)) {
+		end = int64(len(data)) - 1
+	}
+	if offset > end+1 {
+		offset = end + 1
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[offset : end+1])), nil
+}
+
+// Mkdir 创建文件夹
+func (m *Memory) Mkdir(familyId int64, parentId, name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.folder(familyId, parentId) == nil {
+		return notFound()
+	}
+	if m.child(familyId, parentId, name) != nil {
+		return apierror.NewApiError(apierror.ApiCodeFileAlreadyExisted, "文件已存在")
+	}
+	m.create(familyId, parentId, name, true, nil)
+	return nil
+}
+
+// MkdirAll 创建文件夹以及不存在的上级文件夹
+func (m *Memory) MkdirAll(familyId int64, pathStr string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.mkdirAll(familyId, pathStr), nil
+}
+
+// Remove 删除文件或文件夹
+func (m *Memory) Remove(familyId int64, fe *cloudpan.AppFileEntity) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.familyIds[fe.FileId] != familyId {
+		return notFound()
+	}
+	m.remove(fe.FileId)
+	return nil
+}
+
+// Move 移动文件或文件夹到 targetId
+func (m *Memory) Move(familyId int64, fe *cloudpan.AppFileEntity, targetId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, ok := m.entities[fe.FileId]
+	if !ok || m.familyIds[fe.FileId] != familyId || m.folder(familyId, targetId) == nil {
+		return notFound()
+	}
+	if targetId == "" {
+		targetId = rootId
+	}
+	e.ParentId = targetId
+	return nil
+}
+
+// Rename 重命名文件或文件夹
+func (m *Memory) Rename(familyId int64, fe *cloudpan.AppFileEntity, newName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, ok := m.entities[fe.FileId]
+	if !ok || m.familyIds[fe.FileId] != familyId {
+		return notFound()
+	}
+	e.FileName = newName
+	return nil
+}
+
+// Upload 上传文件，同名文件会被替换，大小或者MD5和数据不一致时上传失败
+func (m *Memory) Upload(familyId int64, parentId, name string, size int64, md5Str string, reader io.Reader, old *cloudpan.AppFileEntity) (*cloudpan.AppFileEntity, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.folder(familyId, parentId) == nil {
+		return nil, notFound()
+	}
+	if c := m.child(familyId, parentId, name); c != nil && c.IsFolder {
+		return nil, apierror.NewApiError(apierror.ApiCodeFileAlreadyExisted, "文件已存在")
+	}
+	sum := md5.Sum(data)
+	if int64(len(data)) != size || !strings.EqualFold(hex.EncodeToString(sum[:]), md5Str) {
+		return nil, apierror.NewApiError(apierror.ApiCodeFailed, "文件大小或MD5不一致")
+	}
+	return m.copyOf(m.create(familyId, parentId, name, false, data)), nil
+}
+
+// Families 获取所有家庭云
+func (m *Memory) Families() ([]*cloudpan.AppFamilyInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.families, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package panbackend
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	m.Add(0, "/a/b/c.txt", []byte("hello"))
+	m.Add(8, "/a/b/c.txt", []byte("family"))
+
+	// 移动和重命名后路径随之改变
+	b, err := m.Stat(0, "/a/b")
+	assert.Nil(t, err)
+	rootList, err := m.List(0, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rootList))
+	assert.Nil(t, m.Move(0, b, rootList[0].ParentId))
+	assert.Nil(t, m.Rename(0, b, "d"))
+	fe, err := m.Stat(0, "/d/c.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "/d/c.txt", fe.Path)
+	_, err = m.Stat(0, "/a/b/c.txt")
+	assert.True(t, IsNotFound(err))
+
+	// 大小或MD5不一致时上传失败，原文件保留
+	d, _ := m.Stat(0, "/d")
+	_, err = m.Upload(0, d.FileId, "c.txt", 1, "00", strings.NewReader("x"), fe)
+	assert.EqualValues(t, apierror.ApiCodeFailed, err.(*apierror.ApiError).Code)
+	rc, err := m.Open(0, fe.FileId, 1, 3)
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadAll(rc)
+	assert.Equal(t, "ell", string(data))
+
+	// 家庭云的文件互不影响，删除文件夹同时删除其中的文件
+	assert.Nil(t, m.Remove(0, d))
+	_, err = m.Stat(0, "/d/c.txt")
+	assert.True(t, IsNotFound(err))
+	fe, err = m.Stat(8, "/a/b/c.txt")
+	assert.Nil(t, err)
+	_, err = m.Open(0, fe.FileId, 0, -1)
+	assert.True(t, IsNotFound(err))
+}
@@ -35,8 +35,10 @@ var (
 type (
 	PanClient struct {
 		client     *requester.HTTPClient // http 客户端
+		transferClient *requester.HTTPClient // 上传下载文件数据使用的 http 客户端，不限制请求总时长
 		webToken WebLoginToken
 		appToken AppLoginToken
+		bandwidth *bandwidthState // 上传下载限速
 	}
 )
 
@@ -53,10 +55,15 @@ func NewPanClient(webToken WebLoginToken, appToken AppLoginToken) *PanClient {
 		},
 	})
 
+	transferClient := requester.NewHTTPClient()
+	transferClient.SetTimeout(0)
+
 	return &PanClient{
 		client: client,
+		transferClient: transferClient,
 		webToken: webToken,
 		appToken: appToken,
+		bandwidth: newBandwidthState(),
 	}
 }
 
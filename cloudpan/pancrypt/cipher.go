@@ -0,0 +1,470 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pancrypt 客户端端到端加密，文件内容使用 AES-256-GCM 分块加密，支持区间读取，
+// 文件名可选加密。加密结果是确定的：同一个密钥加密相同的文件得到相同的密文，
+// 所以上传时可以使用密文的MD5秒传，代价是服务端可以看出哪些文件内容相同
+package pancrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type (
+	// Key 加密密钥，内容、文件名以及 nonce 使用从主密钥派生的不同子密钥
+	Key struct {
+		id           [keyIdSize]byte
+		content      cipher.AEAD
+		name         cipher.AEAD
+		nonceKey     []byte
+		nameNonceKey []byte
+	}
+
+	// header 加密文件头
+	//
+	//	magic(4) | version(1) | reserved(3) | chunkSize(4) | keyId(8) | noncePrefix(8)
+	header struct {
+		chunkSize   int64
+		keyId       [keyIdSize]byte
+		noncePrefix [noncePrefixSize]byte
+		raw         []byte
+	}
+
+	// EncryptedFile 加密后的文件，Size 和 Md5 用于创建上传任务
+	EncryptedFile struct {
+		key  *Key
+		src  io.ReaderAt
+		hdr  *header
+		// PlainSize 明文大小
+		PlainSize int64
+		// Size 密文大小
+		Size int64
+		// Md5 密文MD5，大写
+		Md5 string
+	}
+
+	encReader struct {
+		f       *EncryptedFile
+		nChunks int64
+		idx     int64
+		buf     []byte
+		plain   []byte
+		started bool
+	}
+
+	decReader struct {
+		key     *Key
+		hdr     *header
+		r       io.Reader
+		idx     int64
+		// nChunks 总分块数量，<0 表示未知，根据认证结果判断最后一块
+		nChunks int64
+		skip    int64
+		remain  int64
+		buf     []byte
+		cbuf    []byte
+		done    bool
+		err     error
+	}
+)
+
+const (
+	// Version 加密格式版本
+	Version = 1
+	// DefaultChunkSize 默认的明文分块大小
+	DefaultChunkSize = 64 * 1024
+	// HeaderSize 文件头大小
+	HeaderSize = 28
+
+	magic           = "CTEC"
+	keyIdSize       = 8
+	noncePrefixSize = 8
+	tagSize         = 16
+)
+
+var (
+	// ErrKeySize 密钥长度错误
+	ErrKeySize = errors.New("密钥必须是32字节")
+	// ErrNotEncrypted 不是加密文件
+	ErrNotEncrypted = errors.New("不是加密文件")
+	// ErrVersion 不支持的加密格式版本
+	ErrVersion = errors.New("不支持的加密格式版本")
+	// ErrKeyMismatch 文件不是用该密钥加密的
+	ErrKeyMismatch = errors.New("密钥和加密文件不匹配")
+	// ErrAuth 密文校验失败，文件被篡改或者损坏
+	ErrAuth = errors.New("密文校验失败")
+	// ErrTruncated 密文不完整
+	ErrTruncated = errors.New("密文不完整")
+	// ErrChunkSize 不支持的分块大小，文件头在解密前没有经过认证，只接受 DefaultChunkSize
+	ErrChunkSize = errors.New("不支持的加密分块大小")
+)
+
+func hmacSum(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewKey 通过32字节的主密钥创建加密密钥
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) != 32 {
+		return nil, ErrKeySize
+	}
+	k := &Key{
+		nonceKey: hmacSum(secret, []byte("pancrypt nonce")),
+		nameNonceKey: hmacSum(secret, []byte("pancrypt name nonce")),
+	}
+	var err error
+	if k.content, err = newGCM(hmacSum(secret, []byte("pancrypt content"))); err != nil {
+		return nil, err
+	}
+	if k.name, err = newGCM(hmacSum(secret, []byte("pancrypt name"))); err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(append([]byte("pancrypt key id"), secret...))
+	copy(k.id[:], id[:keyIdSize])
+	return k, nil
+}
+
+// Id 密钥ID，保存在加密文件头中，用于识别文件使用的密钥
+func (k *Key) Id() string {
+	return hex.EncodeToString(k.id[:])
+}
+
+func chunkCount(plainSize, chunkSize int64) int64 {
+	if plainSize <= 0 {
+		// 空文件也有一个空的分块，用于校验文件没有被截断
+		return 1
+	}
+	return (plainSize + chunkSize - 1) / chunkSize
+}
+
+// EncryptedSize 明文大小对应的密文大小
+func EncryptedSize(plainSize int64) int64 {
+	return HeaderSize + plainSize + chunkCount(plainSize, DefaultChunkSize)*tagSize
+}
+
+// PlainSize 密文大小对应的明文大小，密文大小不合法时返回错误
+func PlainSize(encSize int64) (int64, error) {
+	n := encSize - HeaderSize
+	if n < tagSize {
+		return 0, ErrNotEncrypted
+	}
+	full := DefaultChunkSize + tagSize
+	chunks := (n + int64(full) - 1) / int64(full)
+	size := n - chunks*tagSize
+	if size < 0 || EncryptedSize(size) != encSize {
+		return 0, ErrNotEncrypted
+	}
+	return size, nil
+}
+
+func (h *header) marshal() []byte {
+	b := make([]byte, HeaderSize)
+	copy(b, magic)
+	b[4] = Version
+	binary.BigEndian.PutUint32(b[8:12], uint32(h.chunkSize))
+	copy(b[12:20], h.keyId[:])
+	copy(b[20:28], h.noncePrefix[:])
+	return b
+}
+
+func (k *Key) parseHeader(b []byte) (*header, error) {
+	if len(b) < HeaderSize || string(b[:4]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	if b[4] != Version {
+		return nil, ErrVersion
+	}
+	h := &header{
+		chunkSize: int64(binary.BigEndian.Uint32(b[8:12])),
+		raw: append([]byte{}, b[:HeaderSize]...),
+	}
+	copy(h.keyId[:], b[12:20])
+	copy(h.noncePrefix[:], b[20:28])
+	if h.chunkSize != DefaultChunkSize {
+		return nil, ErrChunkSize
+	}
+	if h.keyId != k.id {
+		return nil, ErrKeyMismatch
+	}
+	return h, nil
+}
+
+func (h *header) nonce(idx int64) []byte {
+	n := make([]byte, 12)
+	copy(n, h.noncePrefix[:])
+	binary.BigEndian.PutUint32(n[8:], uint32(idx))
+	return n
+}
+
+func (h *header) aad(last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(append([]byte{}, h.raw...), flag)
+}
+
+// Encrypt 加密文件。nonce 由明文内容派生，所以会先完整读取一次明文，再读取一次计算密文的MD5，
+// 上传时通过 EncryptedFile.Reader 再读取一次
+func (k *Key) Encrypt(src io.ReaderAt, size int64) (*EncryptedFile, error) {
+	mac := hmac.New(sha256.New, k.nonceKey)
+	sizeBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBuf, uint64(size))
+	mac.Write(sizeBuf)
+	if _, err := io.Copy(mac, io.NewSectionReader(src, 0, size)); err != nil {
+		return nil, err
+	}
+	hdr := &header{chunkSize: DefaultChunkSize, keyId: k.id}
+	copy(hdr.noncePrefix[:], mac.Sum(nil))
+	hdr.raw = hdr.marshal()
+
+	f := &EncryptedFile{
+		key: k,
+		src: src,
+		hdr: hdr,
+		PlainSize: size,
+		Size: EncryptedSize(size),
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, f.Reader()); err != nil {
+		return nil, err
+	}
+	f.Md5 = strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+	return f, nil
+}
+
+// Reader 从头读取密文，每次调用返回新的 Reader
+func (f *EncryptedFile) Reader() io.Reader {
+	return &encReader{
+		f: f,
+		nChunks: chunkCount(f.PlainSize, f.hdr.chunkSize),
+		plain: make([]byte, f.hdr.chunkSize),
+	}
+}
+
+func (r *encReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if !r.started {
+			r.started = true
+			r.buf = r.f.hdr.raw
+			break
+		}
+		if r.idx >= r.nChunks {
+			return 0, io.EOF
+		}
+		off := r.idx * r.f.hdr.chunkSize
+		n := r.f.PlainSize - off
+		if n > r.f.hdr.chunkSize {
+			n = r.f.hdr.chunkSize
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > 0 {
+			if m, err := r.f.src.ReadAt(r.plain[:n], off); err != nil && !(err == io.EOF && int64(m) == n) {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+		}
+		last := r.idx == r.nChunks-1
+		r.buf = r.f.key.content.Seal(nil, r.f.hdr.nonce(r.idx), r.plain[:n], r.f.hdr.aad(last))
+		r.idx++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// NewDecryptReader 从头解密密文数据流，最后一块校验通过之后才会返回 io.EOF
+func (k *Key) NewDecryptReader(r io.Reader) (io.Reader, error) {
+	b := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	hdr, err := k.parseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	return k.newDecReader(hdr, r, 0, -1, 0, -1), nil
+}
+
+func (k *Key) newDecReader(hdr *header, r io.Reader, idx, nChunks, skip, remain int64) *decReader {
+	return &decReader{
+		key: k,
+		hdr: hdr,
+		r: r,
+		idx: idx,
+		nChunks: nChunks,
+		skip: skip,
+		remain: remain,
+		cbuf: make([]byte, hdr.chunkSize+tagSize),
+	}
+}
+
+func (d *decReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.remain == 0 {
+			return 0, io.EOF
+		}
+		if d.done {
+			// 最后一块之后不能再有数据
+			if n, _ := d.r.Read(d.cbuf[:1]); n > 0 {
+				d.err = ErrAuth
+				return 0, d.err
+			}
+			return 0, io.EOF
+		}
+		if d.err = d.nextChunk(); d.err != nil {
+			return 0, d.err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decReader) nextChunk() error {
+	n, err := io.ReadFull(d.r, d.cbuf)
+	if err == io.EOF {
+		return ErrTruncated
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n < tagSize {
+		return ErrTruncated
+	}
+	ct := d.cbuf[:n]
+	var plain []byte
+	switch {
+	case d.nChunks >= 0:
+		last := d.idx == d.nChunks-1
+		plain, err = d.key.content.Open(nil, d.hdr.nonce(d.idx), ct, d.hdr.aad(last))
+		d.done = last
+	case n < len(d.cbuf):
+		// 不完整的块只能是最后一块
+		plain, err = d.key.content.Open(nil, d.hdr.nonce(d.idx), ct, d.hdr.aad(true))
+		d.done = true
+	default:
+		plain, err = d.key.content.Open(nil, d.hdr.nonce(d.idx), ct, d.hdr.aad(false))
+		if err != nil {
+			plain, err = d.key.content.Open(nil, d.hdr.nonce(d.idx), ct, d.hdr.aad(true))
+			d.done = true
+		}
+	}
+	if err != nil {
+		return ErrAuth
+	}
+	d.idx++
+	if d.skip > 0 {
+		if d.skip >= int64(len(plain)) {
+			d.skip -= int64(len(plain))
+			plain = nil
+		} else {
+			plain = plain[d.skip:]
+			d.skip = 0
+		}
+	}
+	if d.remain >= 0 && int64(len(plain)) > d.remain {
+		plain = plain[:d.remain]
+	}
+	if d.remain > 0 {
+		d.remain -= int64(len(plain))
+	}
+	d.buf = plain
+	return nil
+}
+
+// RangeOpener 打开密文的 [offset, end] 区间，end 包含在内
+type RangeOpener func(offset, end int64) (io.ReadCloser, error)
+
+// NewRangeReader 解密明文 [offset, end] 区间的数据，end 包含在内，end<=0 读取到文件末尾。
+// 只会下载区间所在的分块以及文件头
+func (k *Key) NewRangeReader(open RangeOpener, plainSize, offset, end int64) (io.ReadCloser, error) {
+	rc, err := open(0, HeaderSize-1)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, HeaderSize)
+	_, err = io.ReadFull(rc, b)
+	rc.Close()
+	if err != nil {
+		return nil, ErrNotEncrypted
+	}
+	hdr, err := k.parseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if end <= 0 || end >= plainSize {
+		end = plainSize - 1
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > end {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	nChunks := chunkCount(plainSize, hdr.chunkSize)
+	first := offset / hdr.chunkSize
+	lastChunk := end / hdr.chunkSize
+	full := hdr.chunkSize + tagSize
+	cipherOffset := HeaderSize + first*full
+	cipherEnd := HeaderSize + (lastChunk+1)*full - 1
+	if lastChunk == nChunks-1 {
+		cipherEnd = HeaderSize + plainSize + nChunks*tagSize - 1
+	}
+	rc, err = open(cipherOffset, cipherEnd)
+	if err != nil {
+		return nil, err
+	}
+	d := k.newDecReader(hdr, rc, first, nChunks, offset-first*hdr.chunkSize, end-offset+1)
+	return &readCloser{Reader: d, Closer: rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pancrypt
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T, b byte) *Key {
+	k, err := NewKey(bytes.Repeat([]byte{b}, 32))
+	assert.Nil(t, err)
+	return k
+}
+
+func encryptBytes(t *testing.T, k *Key, plain []byte) (*EncryptedFile, []byte) {
+	f, err := k.Encrypt(bytes.NewReader(plain), int64(len(plain)))
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(f.Reader())
+	assert.Nil(t, err)
+	return f, data
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	k := testKey(t, 1)
+	for _, size := range []int{0, 1, DefaultChunkSize - 1, DefaultChunkSize, DefaultChunkSize + 1, 3*DefaultChunkSize - 5} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i * 7)
+		}
+		f, data := encryptBytes(t, k, plain)
+		assert.Equal(t, f.Size, int64(len(data)), size)
+		assert.Equal(t, EncryptedSize(int64(size)), f.Size)
+		ps, err := PlainSize(f.Size)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(size), ps)
+		sum := md5.Sum(data)
+		assert.Equal(t, strings.ToUpper(hex.EncodeToString(sum[:])), f.Md5)
+
+		// 加密结果是确定的
+		f2, _ := encryptBytes(t, k, plain)
+		assert.Equal(t, f.Md5, f2.Md5)
+
+		r, err := k.NewDecryptReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(r)
+		assert.Nil(t, err, size)
+		assert.Equal(t, plain, out)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	k := testKey(t, 1)
+	plain := bytes.Repeat([]byte("0123456789"), DefaultChunkSize/5)
+	_, data := encryptBytes(t, k, plain)
+
+	// 密钥不匹配
+	_, err := testKey(t, 2).NewDecryptReader(bytes.NewReader(data))
+	assert.Equal(t, ErrKeyMismatch, err)
+
+	// 不是加密文件
+	_, err = k.NewDecryptReader(strings.NewReader("hello"))
+	assert.Equal(t, ErrNotEncrypted, err)
+
+	// 篡改
+	tampered := append([]byte{}, data...)
+	tampered[HeaderSize+10] ^= 1
+	r, _ := k.NewDecryptReader(bytes.NewReader(tampered))
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrAuth, err)
+
+	// 截断到完整的分块边界
+	truncated := data[:HeaderSize+DefaultChunkSize+tagSize]
+	r, _ = k.NewDecryptReader(bytes.NewReader(truncated))
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrTruncated, err)
+
+	// 文件头中的分块大小没有经过认证，不能按它分配内存
+	badChunk := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(badChunk[8:12], 0xffffffff)
+	_, err = k.NewDecryptReader(bytes.NewReader(badChunk))
+	assert.Equal(t, ErrChunkSize, err)
+}
+
+func TestRangeReader(t *testing.T) {
+	k := testKey(t, 1)
+	plain := make([]byte, 3*DefaultChunkSize+100)
+	for i := range plain {
+		plain[i] = byte(i % 251)
+	}
+	_, data := encryptBytes(t, k, plain)
+	opened := 0
+	open := func(offset, end int64) (io.ReadCloser, error) {
+		opened++
+		return ioutil.NopCloser(bytes.NewReader(data[offset : end+1])), nil
+	}
+	size := int64(len(plain))
+	cases := [][2]int64{
+		{0, 0},
+		{0, 9},
+		{5, DefaultChunkSize + 5},
+		{DefaultChunkSize, 2*DefaultChunkSize - 1},
+		{3 * DefaultChunkSize, 0},
+		{size - 1, size - 1},
+		{100, size + 100},
+	}
+	for _, c := range cases {
+		rc, err := k.NewRangeReader(open, size, c[0], c[1])
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		rc.Close()
+		end := c[1]
+		if end <= 0 || end >= size {
+			end = size - 1
+		}
+		assert.Equal(t, plain[c[0]:end+1], out, c)
+	}
+	assert.Equal(t, 2*len(cases), opened)
+}
+
+func TestEncryptName(t *testing.T) {
+	k := testKey(t, 1)
+	enc := k.EncryptName("合同 2021.pdf")
+	assert.Equal(t, enc, k.EncryptName("合同 2021.pdf"))
+	assert.NotContains(t, enc, "合同")
+	assert.NotContains(t, enc, "/")
+	name, err := k.DecryptName(enc)
+	assert.Nil(t, err)
+	assert.Equal(t, "合同 2021.pdf", name)
+
+	_, err = k.DecryptName("readme.txt")
+	assert.Equal(t, ErrNameNotEncrypted, err)
+	_, err = testKey(t, 2).DecryptName(enc)
+	assert.Equal(t, ErrAuth, err)
+
+	p := k.EncryptPath("/文档/合同 2021.pdf")
+	assert.Equal(t, "/"+k.EncryptName("文档")+"/"+enc, p)
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pancrypt
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// Client 透明加解密的云盘客户端，上传时加密，下载以及获取文件列表时解密
+	Client struct {
+		client   *cloudpan.PanClient
+		familyId int64
+		key      *Key
+
+		// EncryptNames 是否加密文件名和文件夹名，不加密时文件名加上 EncryptedFileSuffix 后缀
+		EncryptNames bool
+	}
+)
+
+const (
+	// EncryptedFileSuffix 没有加密文件名时，加密文件在云盘中的文件名后缀，用于区分加密文件和普通文件
+	EncryptedFileSuffix = ".ctec"
+)
+
+// NewClient 创建加密客户端，familyId<=0 为个人云
+func NewClient(client *cloudpan.PanClient, familyId int64, key *Key) *Client {
+	return &Client{
+		client: client,
+		familyId: familyId,
+		key: key,
+	}
+}
+
+// encodeFileName 加密文件在云盘中保存的文件名
+func (c *Client) encodeFileName(name string) string {
+	if !c.EncryptNames {
+		return name + EncryptedFileSuffix
+	}
+	return c.key.EncryptName(name)
+}
+
+// encodePath 云盘中保存的路径
+func (c *Client) encodePath(p string) string {
+	if !c.EncryptNames {
+		return p
+	}
+	return c.key.EncryptPath(p)
+}
+
+// decodeEntity 返回解密文件名以及明文大小后的文件信息。只有文件名可以用密钥解密或者带有
+// EncryptedFileSuffix 后缀的文件才是加密文件，其他文件的文件名和大小保持不变
+func (c *Client) decodeEntity(fe *cloudpan.AppFileEntity, parentPath string) *cloudpan.AppFileEntity {
+	e := *fe
+	encrypted := false
+	if name, err := c.key.DecryptName(fe.FileName); err == nil {
+		e.FileName = name
+		encrypted = true
+	} else if !fe.IsFolder && len(fe.FileName) > len(EncryptedFileSuffix) && strings.HasSuffix(fe.FileName, EncryptedFileSuffix) {
+		e.FileName = strings.TrimSuffix(fe.FileName, EncryptedFileSuffix)
+		encrypted = true
+	}
+	if encrypted && !fe.IsFolder {
+		if size, err := PlainSize(fe.FileSize); err == nil {
+			e.FileSize = size
+		}
+	}
+	if parentPath != "" {
+		e.Path = path.Join(parentPath, e.FileName)
+	}
+	return &e
+}
+
+// FileInfoByPath 通过明文路径获取文件信息，没有加密文件名时优先查找带有 EncryptedFileSuffix 后缀的加密文件
+func (c *Client) FileInfoByPath(p string) (*cloudpan.AppFileEntity, error) {
+	p = path.Clean("/" + p)
+	var fe *cloudpan.AppFileEntity
+	var apiErr *apierror.ApiError
+	if !c.EncryptNames && p != "/" {
+		fe, apiErr = c.client.AppFileInfoByPath(c.familyId, p+EncryptedFileSuffix)
+		if apiErr != nil && apiErr.Code != apierror.ApiCodeFileNotFoundCode {
+			return nil, apiErr
+		}
+	}
+	if fe == nil {
+		// 文件夹以及没有加密的文件
+		fe, apiErr = c.client.AppFileInfoByPath(c.familyId, c.encodePath(p))
+		if apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	e := c.decodeEntity(fe, "")
+	e.Path = p
+	return e, nil
+}
+
+// List 获取文件夹下的所有文件，文件名和大小都是解密后的
+func (c *Client) List(folder *cloudpan.AppFileEntity) (cloudpan.AppFileList, error) {
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = c.familyId
+	param.FileId = folder.FileId
+	r, apiErr := c.client.AppGetAllFileList(param)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	list := cloudpan.AppFileList{}
+	for _, fe := range r.FileList {
+		list = append(list, c.decodeEntity(fe, folder.Path))
+	}
+	return list, nil
+}
+
+// MkdirAll 创建明文路径对应的文件夹
+func (c *Client) MkdirAll(p string) (*cloudpan.AppMkdirResult, error) {
+	r, apiErr := c.client.AppMkdirAll(c.familyId, c.encodePath(path.Clean("/"+p)))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return r, nil
+}
+
+// Upload 加密并上传文件到指定文件夹，密文MD5相同的文件会秒传
+func (c *Client) Upload(parentId, name string, src io.ReaderAt, size int64, overwrite bool) (*cloudpan.AppUploadFileCommitResult, error) {
+	f, err := c.key.Encrypt(src, size)
+	if err != nil {
+		return nil, err
+	}
+	param := &cloudpan.AppCreateUploadFileParam{
+		FamilyId: c.familyId,
+		ParentFolderId: parentId,
+		FileName: c.encodeFileName(name),
+		Size: f.Size,
+		Md5: f.Md5,
+		LastWrite: apiutil.FormatTime(time.Now()),
+	}
+	r, apiErr := c.client.AppUploadFileFromReader(param, f.Reader(), overwrite)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return r, nil
+}
+
+// Open 解密文件明文 [offset, end] 区间的数据，end 包含在内，end<=0 读取到文件末尾。
+// fe 为 List 或者 FileInfoByPath 返回的文件信息
+func (c *Client) Open(fe *cloudpan.AppFileEntity, offset, end int64) (io.ReadCloser, error) {
+	open := func(cipherOffset, cipherEnd int64) (io.ReadCloser, error) {
+		rc, apiErr := c.client.AppDownloadFileReader(c.familyId, fe.FileId, cloudpan.AppFileDownloadRange{
+			Offset: cipherOffset,
+			End: cipherEnd,
+		})
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return rc, nil
+	}
+	return c.key.NewRangeReader(open, fe.FileSize, offset, end)
+}
+
+// Download 下载并解密整个文件写入 writer，返回写入的明文字节数
+func (c *Client) Download(fe *cloudpan.AppFileEntity, writer io.Writer) (int64, error) {
+	rc, err := c.Open(fe, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(writer, rc)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pancrypt
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeEntity(t *testing.T) {
+	k := testKey(t, 1)
+	c := NewClient(nil, 0, k)
+	encSize := EncryptedSize(100)
+
+	// 带有后缀的加密文件
+	e := c.decodeEntity(&cloudpan.AppFileEntity{FileName: "a.txt" + EncryptedFileSuffix, FileSize: encSize}, "/docs")
+	assert.Equal(t, "a.txt", e.FileName)
+	assert.Equal(t, int64(100), e.FileSize)
+	assert.Equal(t, "/docs/a.txt", e.Path)
+
+	// 大小刚好符合密文大小的普通文件保持不变
+	e = c.decodeEntity(&cloudpan.AppFileEntity{FileName: "b.bin", FileSize: encSize}, "/docs")
+	assert.Equal(t, "b.bin", e.FileName)
+	assert.Equal(t, encSize, e.FileSize)
+
+	// 加密文件名
+	e = c.decodeEntity(&cloudpan.AppFileEntity{FileName: k.EncryptName("c.txt"), FileSize: encSize}, "")
+	assert.Equal(t, "c.txt", e.FileName)
+	assert.Equal(t, int64(100), e.FileSize)
+
+	// 文件夹只解密名称，不处理后缀
+	e = c.decodeEntity(&cloudpan.AppFileEntity{FileName: k.EncryptName("dir"), IsFolder: true, FileSize: encSize}, "")
+	assert.Equal(t, "dir", e.FileName)
+	assert.Equal(t, encSize, e.FileSize)
+	e = c.decodeEntity(&cloudpan.AppFileEntity{FileName: "d" + EncryptedFileSuffix, IsFolder: true}, "")
+	assert.Equal(t, "d"+EncryptedFileSuffix, e.FileName)
+
+	assert.Equal(t, "a.txt"+EncryptedFileSuffix, c.encodeFileName("a.txt"))
+	c.EncryptNames = true
+	assert.Equal(t, k.EncryptName("a.txt"), c.encodeFileName("a.txt"))
+}
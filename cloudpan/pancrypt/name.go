@@ -0,0 +1,69 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pancrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	// namePrefix 加密文件名的前缀，用于区分没有加密的文件名
+	namePrefix = "ct."
+	nameNonceSize = 12
+)
+
+var (
+	// ErrNameNotEncrypted 文件名没有加密
+	ErrNameNotEncrypted = errors.New("文件名没有加密")
+
+	nameEncoding = base64.RawURLEncoding
+)
+
+// EncryptName 加密文件名。相同的文件名加密结果相同，所以可以通过加密后的路径直接查找文件。
+// 加密后的文件名长度约为原来的4/3再加上40个字符
+func (k *Key) EncryptName(name string) string {
+	nonce := hmacSum(k.nameNonceKey, []byte(name))[:nameNonceSize]
+	sealed := k.name.Seal(nil, nonce, []byte(name), nil)
+	return namePrefix + nameEncoding.EncodeToString(append(nonce, sealed...))
+}
+
+// DecryptName 解密文件名，不是加密文件名时返回 ErrNameNotEncrypted
+func (k *Key) DecryptName(encName string) (string, error) {
+	if !strings.HasPrefix(encName, namePrefix) {
+		return "", ErrNameNotEncrypted
+	}
+	data, err := nameEncoding.DecodeString(encName[len(namePrefix):])
+	if err != nil || len(data) < nameNonceSize+tagSize {
+		return "", ErrNameNotEncrypted
+	}
+	plain, err := k.name.Open(nil, data[:nameNonceSize], data[nameNonceSize:], nil)
+	if err != nil {
+		return "", ErrAuth
+	}
+	return string(plain), nil
+}
+
+// EncryptPath 逐级加密绝对路径中的文件名
+func (k *Key) EncryptPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if s != "" {
+			segments[i] = k.EncryptName(s)
+		}
+	}
+	return strings.Join(segments, "/")
+}
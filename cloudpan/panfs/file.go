@@ -0,0 +1,243 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package panfs
+
+import (
+	"errors"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"io"
+	"io/fs"
+	"time"
+)
+
+type (
+	// fileInfo 云盘文件信息，Sys() 返回 *cloudpan.AppFileEntity
+	fileInfo struct {
+		name    string
+		entity  *cloudpan.AppFileEntity
+		modTime time.Time
+	}
+
+	dir struct {
+		fs      *FS
+		name    string
+		info    *fileInfo
+		entries []fs.DirEntry
+		offset  int
+		closed  bool
+	}
+
+	file struct {
+		fs     *FS
+		name   string
+		info   *fileInfo
+		offset int64
+		rc     io.ReadCloser
+		closed bool
+	}
+)
+
+func newFileInfo(fe *cloudpan.AppFileEntity, name string) *fileInfo {
+	return &fileInfo{
+		name: name,
+		entity: fe,
+		modTime: apiutil.ParseTimeStr(fe.LastOpTime),
+	}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	if fi.entity.IsFolder {
+		return 0
+	}
+	return fi.entity.FileSize
+}
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.entity.IsFolder {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.entity.IsFolder
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return fi.entity
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return fs.ErrClosed
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir 实现 fs.ReadDirFile
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if d.entries == nil {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+
+	remain := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remain, nil
+	}
+	if len(remain) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remain) {
+		n = len(remain)
+	}
+	d.offset += n
+	return remain[:n], nil
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read 按需打开文件数据流，从当前位置开始读取
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	size := f.info.Size()
+	if f.offset >= size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.rc == nil {
+		rc, err := f.fs.b.Open(f.fs.familyId, f.info.entity.FileId, f.offset, -1)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.rc = rc
+	}
+	if int64(len(p)) > size-f.offset {
+		p = p[:size-f.offset]
+	}
+	n, err := f.rc.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && f.offset >= size {
+		f.closeStream()
+	}
+	return n, err
+}
+
+// ReadAt 实现 io.ReaderAt，每次调用都会单独打开一个区间数据流
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	size := f.info.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	want := p
+	if int64(len(want)) > size-off {
+		want = want[:size-off]
+	}
+	rc, err := f.fs.b.Open(f.fs.familyId, f.info.entity.FileId, off, -1)
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, want)
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek 实现 io.Seeker，位置改变后下一次读取会重新打开数据流
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.info.Size() + offset
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if abs < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if abs != f.offset {
+		f.closeStream()
+		f.offset = abs
+	}
+	return abs, nil
+}
+
+func (f *file) closeStream() {
+	if f.rc != nil {
+		f.rc.Close()
+		f.rc = nil
+	}
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.closeStream()
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package panfs 基于 PanClient 的只读 io/fs 文件系统实现，支持个人云和家庭云
+package panfs
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	listCacheItem struct {
+		list     cloudpan.AppFileList
+		expireAt time.Time
+	}
+
+	// FS 云盘文件系统，实现了 fs.FS、fs.ReadDirFS、fs.StatFS 以及 fs.ReadFileFS
+	FS struct {
+		b        panbackend.Backend
+		familyId int64
+		root     string
+
+		// CacheTTL 文件夹列表缓存时长，默认5秒，小于0则不缓存
+		CacheTTL time.Duration
+
+		rootInfo  *cloudpan.AppFileEntity
+		cache     map[string]*listCacheItem
+		cacheLock sync.Mutex
+	}
+)
+
+const (
+	// DefaultCacheTTL 默认的文件夹列表缓存时长
+	DefaultCacheTTL = 5 * time.Second
+)
+
+// New 创建云盘文件系统，root 为云盘中作为文件系统根目录的绝对路径，familyId<=0 为个人云
+func New(client *cloudpan.PanClient, familyId int64, root string) *FS {
+	return newFS(panbackend.NewClient(client), familyId, root)
+}
+
+func newFS(b panbackend.Backend, familyId int64, root string) *FS {
+	if root == "" {
+		root = "/"
+	}
+	return &FS{
+		b: b,
+		familyId: familyId,
+		root: path.Clean(root),
+		CacheTTL: DefaultCacheTTL,
+		cache: map[string]*listCacheItem{},
+	}
+}
+
+// InvalidateCache 清空文件夹列表缓存
+func (f *FS) InvalidateCache() {
+	f.cacheLock.Lock()
+	defer f.cacheLock.Unlock()
+	f.cache = map[string]*listCacheItem{}
+	f.rootInfo = nil
+}
+
+func (f *FS) listDir(folderId string) (cloudpan.AppFileList, error) {
+	f.cacheLock.Lock()
+	item, ok := f.cache[folderId]
+	f.cacheLock.Unlock()
+	if ok && time.Now().Before(item.expireAt) {
+		return item.list, nil
+	}
+
+	list, err := f.b.List(f.familyId, folderId)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FileName < list[j].FileName
+	})
+	if f.CacheTTL >= 0 {
+		f.cacheLock.Lock()
+		f.cache[folderId] = &listCacheItem{list: list, expireAt: time.Now().Add(f.CacheTTL)}
+		f.cacheLock.Unlock()
+	}
+	return list, nil
+}
+
+// lookup 通过文件系统路径查找文件信息
+func (f *FS) lookup(op, name string) (*cloudpan.AppFileEntity, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	f.cacheLock.Lock()
+	root := f.rootInfo
+	f.cacheLock.Unlock()
+	if root == nil {
+		fe, err := f.b.Stat(f.familyId, f.root)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: convertError(err)}
+		}
+		if !fe.IsFolder {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+		}
+		root = fe
+		f.cacheLock.Lock()
+		f.rootInfo = root
+		f.cacheLock.Unlock()
+	}
+	if name == "." {
+		return root, nil
+	}
+
+	cur := root
+	for _, elem := range strings.Split(name, "/") {
+		if !cur.IsFolder {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		list, err := f.listDir(cur.FileId)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: convertError(err)}
+		}
+		idx := sort.Search(len(list), func(i int) bool {
+			return list[i].FileName >= elem
+		})
+		if idx >= len(list) || list[idx].FileName != elem {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		cur = list[idx]
+	}
+	return cur, nil
+}
+
+func convertError(err error) error {
+	if panbackend.IsNotFound(err) {
+		return fs.ErrNotExist
+	}
+	return err
+}
+
+// Open 打开文件或文件夹
+func (f *FS) Open(name string) (fs.File, error) {
+	fe, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := newFileInfo(fe, path.Base(name))
+	if fe.IsFolder {
+		return &dir{fs: f, name: name, info: info}, nil
+	}
+	return &file{fs: f, name: name, info: info}, nil
+}
+
+// Stat 获取文件信息
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	fe, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(fe, path.Base(name)), nil
+}
+
+// ReadDir 获取文件夹下的文件列表，按文件名排序
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fe, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fe.IsFolder {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	list, err := f.listDir(fe.FileId)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: convertError(err)}
+	}
+	entries := make([]fs.DirEntry, 0, len(list))
+	for _, item := range list {
+		entries = append(entries, fs.FileInfoToDirEntry(newFileInfo(item, item.FileName)))
+	}
+	return entries, nil
+}
+
+// ReadFile 读取文件的全部内容
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	fe, err := f.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if fe.IsFolder {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	if fe.FileSize == 0 {
+		return []byte{}, nil
+	}
+	rc, err := f.b.Open(f.familyId, fe.FileId, 0, -1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	defer rc.Close()
+	data := make([]byte, fe.FileSize)
+	if _, err := io.ReadFull(rc, data); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
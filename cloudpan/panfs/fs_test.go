@@ -0,0 +1,50 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package panfs
+
+import (
+	"bytes"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	m := panbackend.NewMemory()
+	m.Add(0, "/docs/readme.txt", []byte("hello world"))
+	m.Add(0, "/docs/empty.txt", []byte{})
+	m.Add(0, "/docs/sub/deep.bin", bytes.Repeat([]byte("0123456789"), 1000))
+	m.MkdirAll(0, "/photos")
+	m.Add(0, "/other.txt", []byte("outside root"))
+
+	fsys := newFS(m, 0, "/docs")
+	if err := fstest.TestFS(fsys, "readme.txt", "empty.txt", "sub/deep.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fsys.ReadFile("readme.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	fi, err := fsys.Stat("sub/deep.bin")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10000), fi.Size())
+	assert.Equal(t, 2021, fi.ModTime().Year())
+
+	_, err = fsys.Open("other.txt")
+	assert.True(t, err != nil && strings.Contains(err.Error(), "not exist"))
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package panindex 云盘目录树的本地索引，文件信息保存在本地 bbolt 数据库中，
+// 通过文件夹版本号增量刷新，查询时不需要访问云盘接口
+package panindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctlibgo/logger"
+	bolt "go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+type (
+	// source 目录树快照来源，默认使用 PanClient 实现
+	source interface {
+		// snapshot 获取最新快照，old 不为空时只重新获取版本号变化的文件夹
+		snapshot(old *cloudpan.AppFileSnapshot) (*cloudpan.AppFileSnapshot, error)
+	}
+
+	clientSource struct {
+		client   *cloudpan.PanClient
+		familyId int64
+		rootPath string
+	}
+
+	// Index 云盘目录树的本地索引
+	Index struct {
+		db  *bolt.DB
+		src source
+	}
+
+	// indexMeta 索引的元信息
+	indexMeta struct {
+		FamilyId int64 `json:"familyId"`
+		RootFileId string `json:"rootFileId"`
+		RootPath string `json:"rootPath"`
+		SnapshotTime time.Time `json:"snapshotTime"`
+	}
+
+	// RefreshResult 刷新索引的结果
+	RefreshResult struct {
+		// Changes 本次刷新检测到的文件变更
+		Changes cloudpan.AppFileChangeList
+		// Written 写入的文件数量
+		Written int
+		// Deleted 删除的文件数量
+		Deleted int
+	}
+)
+
+var (
+	bucketMeta = []byte("meta")
+	// bucketEntries 文件ID -> AppSnapshotEntry
+	bucketEntries = []byte("entries")
+	// bucketFolders 文件夹ID -> AppSnapshotFolder
+	bucketFolders = []byte("folders")
+	// bucketPaths 路径 -> 文件ID
+	bucketPaths = []byte("paths")
+	// bucketMd5 MD5 + "/" + 文件ID -> 空
+	bucketMd5 = []byte("md5")
+
+	keyMeta = []byte("meta")
+
+	// ErrNotIndexed 索引还没有刷新过
+	ErrNotIndexed = errors.New("索引为空，请先刷新索引")
+	// ErrNotFound 索引中不存在该文件
+	ErrNotFound = errors.New("索引中不存在该文件")
+	// ErrRootChanged 数据库中已经保存了其他目录的索引
+	ErrRootChanged = errors.New("数据库中已保存其他目录的索引")
+)
+
+// Open 打开或者创建索引数据库，rootPath 为需要建立索引的云盘目录，familyId<=0 为个人云。
+// 一个数据库只保存一个目录的索引
+func Open(dbPath string, client *cloudpan.PanClient, familyId int64, rootPath string) (*Index, error) {
+	if rootPath == "" {
+		rootPath = "/"
+	}
+	return open(dbPath, &clientSource{client: client, familyId: familyId, rootPath: rootPath})
+}
+
+func open(dbPath string, src source) (*Index, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketEntries, bucketFolders, bucketPaths, bucketMd5} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Index{db: db, src: src}, nil
+}
+
+func (c *clientSource) snapshot(old *cloudpan.AppFileSnapshot) (*cloudpan.AppFileSnapshot, error) {
+	if old == nil {
+		s, apiErr := c.client.AppTakeFileSnapshot(c.familyId, c.rootPath)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return s, nil
+	}
+	if old.FamilyId != c.familyId || old.RootPath != c.rootPath {
+		return nil, ErrRootChanged
+	}
+	_, s, apiErr := c.client.AppFileChangesSince(old)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return s, nil
+}
+
+// Close 关闭数据库
+func (idx *Index) Close() error {
+	return idx.db.Close()
+}
+
+// Refresh 刷新索引。第一次刷新会遍历整个目录树，之后只重新获取版本号变化的文件夹，
+// 并且只写入发生变化的文件
+func (idx *Index) Refresh() (*RefreshResult, error) {
+	old, err := idx.Snapshot()
+	if err != nil && err != ErrNotIndexed {
+		return nil, err
+	}
+	cur, err := idx.src.snapshot(old)
+	if err != nil {
+		return nil, err
+	}
+	result := &RefreshResult{}
+	if old != nil {
+		result.Changes = cloudpan.DiffAppFileSnapshot(old, cur)
+	}
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		return applySnapshot(tx, old, cur, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Verboseln("index refreshed, written: ", result.Written, ", deleted: ", result.Deleted)
+	return result, nil
+}
+
+// applySnapshot 将新快照和旧快照的差异写入数据库
+func applySnapshot(tx *bolt.Tx, old, cur *cloudpan.AppFileSnapshot, result *RefreshResult) error {
+	entries := tx.Bucket(bucketEntries)
+	folders := tx.Bucket(bucketFolders)
+	paths := tx.Bucket(bucketPaths)
+	md5s := tx.Bucket(bucketMd5)
+
+	var oldEntries map[string]*cloudpan.AppSnapshotEntry
+	var oldFolders map[string]*cloudpan.AppSnapshotFolder
+	if old != nil {
+		oldEntries = old.Entries
+		oldFolders = old.Folders
+	}
+
+	// 删除已经不存在或者发生变化的文件的旧记录
+	for fileId, oe := range oldEntries {
+		ne, ok := cur.Entries[fileId]
+		if ok && *ne == *oe {
+			continue
+		}
+		if err := deleteEntry(entries, paths, md5s, oe); err != nil {
+			return err
+		}
+		if !ok {
+			result.Deleted++
+		}
+	}
+	for fileId, ne := range cur.Entries {
+		if oe, ok := oldEntries[fileId]; ok && *ne == *oe {
+			continue
+		}
+		data, err := json.Marshal(ne)
+		if err != nil {
+			return err
+		}
+		if err := entries.Put([]byte(fileId), data); err != nil {
+			return err
+		}
+		if err := paths.Put([]byte(ne.Path), []byte(fileId)); err != nil {
+			return err
+		}
+		if !ne.IsFolder && ne.FileMd5 != "" {
+			if err := md5s.Put(md5Key(ne.FileMd5, fileId), []byte{}); err != nil {
+				return err
+			}
+		}
+		result.Written++
+	}
+
+	for folderId := range oldFolders {
+		if _, ok := cur.Folders[folderId]; !ok {
+			if err := folders.Delete([]byte(folderId)); err != nil {
+				return err
+			}
+		}
+	}
+	for folderId, nf := range cur.Folders {
+		data, err := json.Marshal(nf)
+		if err != nil {
+			return err
+		}
+		if of, ok := oldFolders[folderId]; ok {
+			if od, _ := json.Marshal(of); bytes.Equal(od, data) {
+				continue
+			}
+		}
+		if err := folders.Put([]byte(folderId), data); err != nil {
+			return err
+		}
+	}
+
+	meta, err := json.Marshal(&indexMeta{
+		FamilyId: cur.FamilyId,
+		RootFileId: cur.RootFileId,
+		RootPath: cur.RootPath,
+		SnapshotTime: cur.SnapshotTime,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketMeta).Put(keyMeta, meta)
+}
+
+func deleteEntry(entries, paths, md5s *bolt.Bucket, e *cloudpan.AppSnapshotEntry) error {
+	if err := entries.Delete([]byte(e.FileId)); err != nil {
+		return err
+	}
+	// 其他文件可能已经使用了这个路径
+	if v := paths.Get([]byte(e.Path)); v != nil && string(v) == e.FileId {
+		if err := paths.Delete([]byte(e.Path)); err != nil {
+			return err
+		}
+	}
+	if e.FileMd5 != "" {
+		return md5s.Delete(md5Key(e.FileMd5, e.FileId))
+	}
+	return nil
+}
+
+func md5Key(md5, fileId string) []byte {
+	return []byte(strings.ToUpper(md5) + "/" + fileId)
+}
+
+// Snapshot 从数据库中读取完整的目录树快照，可以配合 cloudpan.DiffAppFileSnapshot 使用
+func (idx *Index) Snapshot() (*cloudpan.AppFileSnapshot, error) {
+	var s *cloudpan.AppFileSnapshot
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketMeta).Get(keyMeta)
+		if data == nil {
+			return ErrNotIndexed
+		}
+		meta := &indexMeta{}
+		if err := json.Unmarshal(data, meta); err != nil {
+			return err
+		}
+		s = &cloudpan.AppFileSnapshot{
+			FamilyId: meta.FamilyId,
+			RootFileId: meta.RootFileId,
+			RootPath: meta.RootPath,
+			SnapshotTime: meta.SnapshotTime,
+			Entries: map[string]*cloudpan.AppSnapshotEntry{},
+			Folders: map[string]*cloudpan.AppSnapshotFolder{},
+		}
+		err := tx.Bucket(bucketEntries).ForEach(func(k, v []byte) error {
+			e := &cloudpan.AppSnapshotEntry{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			s.Entries[string(k)] = e
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketFolders).ForEach(func(k, v []byte) error {
+			f := &cloudpan.AppSnapshotFolder{}
+			if err := json.Unmarshal(v, f); err != nil {
+				return err
+			}
+			s.Folders[string(k)] = f
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package panindex
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// memSource 内存中的目录树，每次调用 snapshot 返回当前的目录树
+type memSource struct {
+	entries []*cloudpan.AppSnapshotEntry
+	calls int
+}
+
+func (m *memSource) snapshot(old *cloudpan.AppFileSnapshot) (*cloudpan.AppFileSnapshot, error) {
+	m.calls++
+	s := &cloudpan.AppFileSnapshot{
+		RootFileId: "-11",
+		RootPath: "/",
+		SnapshotTime: time.Now(),
+		Entries: map[string]*cloudpan.AppSnapshotEntry{},
+		Folders: map[string]*cloudpan.AppSnapshotFolder{"-11": {FileId: "-11", Rev: "1"}},
+	}
+	for _, e := range m.entries {
+		c := *e
+		s.Entries[c.FileId] = &c
+		if c.IsFolder {
+			s.Folders[c.FileId] = &cloudpan.AppSnapshotFolder{FileId: c.FileId, Rev: c.Rev}
+		}
+	}
+	return s, nil
+}
+
+func entry(fileId, parentId, p string, size int64, md5 string) *cloudpan.AppSnapshotEntry {
+	return &cloudpan.AppSnapshotEntry{
+		FileId: fileId,
+		ParentId: parentId,
+		FileName: path.Base(p),
+		Path: p,
+		IsFolder: md5 == "",
+		FileSize: size,
+		FileMd5: md5,
+		Rev: "1",
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panindex")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := &memSource{entries: []*cloudpan.AppSnapshotEntry{
+		entry("1", "-11", "/备份", 0, ""),
+		entry("2", "1", "/备份/2020", 0, ""),
+		entry("3", "2", "/备份/2020/a.jpg", 100, "AAA"),
+		entry("4", "2", "/备份/2020/b.jpg", 300, "BBB"),
+		entry("5", "1", "/备份/c.txt", 50, "AAA"),
+		entry("6", "-11", "/文档", 0, ""),
+		entry("7", "6", "/文档/d.pdf", 1000, "DDD"),
+	}}
+	idx, err := open(filepath.Join(dir, "index.db"), src)
+	assert.Nil(t, err)
+	defer idx.Close()
+
+	_, err = idx.Stat("/备份")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = idx.Snapshot()
+	assert.Equal(t, ErrNotIndexed, err)
+
+	r, err := idx.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, r.Written)
+
+	e, err := idx.Stat("/备份/2020/b.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, "4", e.FileId)
+
+	list, err := idx.FindByName("*.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "/备份/2020/a.jpg", list[0].Path)
+
+	list, err = idx.FindByPath("/备份/*")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+
+	list, err = idx.FindByMd5("aaa")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "/备份/2020/a.jpg", list[0].Path)
+
+	list, err = idx.LargestFiles(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"7", "4"}, []string{list[0].FileId, list[1].FileId})
+
+	fs, err := idx.FolderSize("/备份")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(450), fs.Size)
+	assert.Equal(t, int64(3), fs.FileCount)
+	assert.Equal(t, int64(1), fs.FolderCount)
+	assert.Equal(t, "1", fs.FileId)
+
+	sizes, err := idx.SubFolderSizes("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sizes))
+	assert.Equal(t, "/文档", sizes[0].Path)
+	assert.Equal(t, int64(1000), sizes[0].Size)
+	assert.Equal(t, int64(450), sizes[1].Size)
+
+	// 增量刷新：删除、修改、重命名
+	src.entries = []*cloudpan.AppSnapshotEntry{
+		entry("1", "-11", "/备份", 0, ""),
+		entry("2", "1", "/备份/2021", 0, ""),
+		entry("3", "2", "/备份/2021/a.jpg", 100, "AAA"),
+		entry("4", "2", "/备份/2021/b.jpg", 300, "BBB"),
+		entry("5", "1", "/备份/c.txt", 80, "CCC"),
+		entry("6", "-11", "/文档", 0, ""),
+	}
+	r, err = idx.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, r.Written)
+	assert.Equal(t, 1, r.Deleted)
+	assert.True(t, len(r.Changes) > 0)
+
+	_, err = idx.Stat("/备份/2020/a.jpg")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = idx.Stat("/文档/d.pdf")
+	assert.Equal(t, ErrNotFound, err)
+	list, err = idx.FindByMd5("AAA")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list))
+	list, err = idx.FindByMd5("DDD")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(list))
+
+	s, err := idx.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(s.Entries))
+
+	// 重新打开数据库，索引仍然可用
+	assert.Nil(t, idx.Close())
+	idx, err = open(filepath.Join(dir, "index.db"), src)
+	assert.Nil(t, err)
+	fs, err = idx.FolderSize("/")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(480), fs.Size)
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package panindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/phpc0de/ctapi/cloudpan"
+	bolt "go.etcd.io/bbolt"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// FolderSize 文件夹的大小统计
+	FolderSize struct {
+		Path string
+		FileId string
+		// Size 文件夹下所有文件的总大小
+		Size int64
+		// FileCount 文件夹下所有文件的数量，包括子文件夹中的文件
+		FileCount int64
+		// FolderCount 子文件夹的数量，包括所有层级
+		FolderCount int64
+	}
+
+	// EntryList 索引中的文件列表
+	EntryList []*cloudpan.AppSnapshotEntry
+)
+
+// Stat 通过路径获取文件信息
+func (idx *Index) Stat(pathStr string) (*cloudpan.AppSnapshotEntry, error) {
+	var e *cloudpan.AppSnapshotEntry
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		fileId := tx.Bucket(bucketPaths).Get([]byte(path.Clean(pathStr)))
+		if fileId == nil {
+			return ErrNotFound
+		}
+		var err error
+		e, err = getEntry(tx, fileId)
+		return err
+	})
+	return e, err
+}
+
+// FindById 通过文件ID获取文件信息
+func (idx *Index) FindById(fileId string) (*cloudpan.AppSnapshotEntry, error) {
+	var e *cloudpan.AppSnapshotEntry
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		var err error
+		e, err = getEntry(tx, []byte(fileId))
+		return err
+	})
+	return e, err
+}
+
+// FindByName 查找文件名匹配通配符的文件和文件夹，规则同 path.Match，结果按路径排序
+func (idx *Index) FindByName(pattern string) (EntryList, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return idx.filter(func(e *cloudpan.AppSnapshotEntry) bool {
+		ok, _ := path.Match(pattern, e.FileName)
+		return ok
+	})
+}
+
+// FindByPath 查找完整路径匹配通配符的文件和文件夹，例如 /照片/*/*.jpg，结果按路径排序
+func (idx *Index) FindByPath(pattern string) (EntryList, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return idx.filter(func(e *cloudpan.AppSnapshotEntry) bool {
+		ok, _ := path.Match(pattern, e.Path)
+		return ok
+	})
+}
+
+// FindByMd5 查找指定MD5的文件，MD5不区分大小写，结果按路径排序
+func (idx *Index) FindByMd5(md5 string) (EntryList, error) {
+	result := EntryList{}
+	prefix := []byte(strings.ToUpper(md5) + "/")
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketMd5).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			e, err := getEntry(tx, k[len(prefix):])
+			if err != nil {
+				return err
+			}
+			result = append(result, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.sortByPath()
+	return result, nil
+}
+
+// LargestFiles 获取最大的 n 个文件，按大小降序排列
+func (idx *Index) LargestFiles(n int) (EntryList, error) {
+	files, err := idx.filter(func(e *cloudpan.AppSnapshotEntry) bool {
+		return !e.IsFolder
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].FileSize > files[j].FileSize
+	})
+	if n >= 0 && len(files) > n {
+		files = files[:n]
+	}
+	return files, nil
+}
+
+// FolderSize 统计文件夹下所有文件的总大小
+func (idx *Index) FolderSize(dirPath string) (*FolderSize, error) {
+	dirPath = path.Clean(dirPath)
+	fs := &FolderSize{Path: dirPath}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		if fileId := tx.Bucket(bucketPaths).Get([]byte(dirPath)); fileId != nil {
+			fs.FileId = string(fileId)
+		}
+		return forEachEntry(tx, func(e *cloudpan.AppSnapshotEntry) {
+			if !isUnder(e.Path, dirPath) {
+				return
+			}
+			if e.IsFolder {
+				fs.FolderCount++
+			} else {
+				fs.FileCount++
+				fs.Size += e.FileSize
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// SubFolderSizes 统计文件夹下每个子文件夹的大小，按大小降序排列
+func (idx *Index) SubFolderSizes(dirPath string) ([]*FolderSize, error) {
+	dirPath = path.Clean(dirPath)
+	sizes := map[string]*FolderSize{}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return forEachEntry(tx, func(e *cloudpan.AppSnapshotEntry) {
+			if !isUnder(e.Path, dirPath) {
+				return
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(e.Path, dirPath), "/")
+			idx := strings.Index(rel, "/")
+			if idx < 0 {
+				// 直接子文件夹
+				if e.IsFolder {
+					fs := folderSizeOf(sizes, path.Join(dirPath, rel))
+					fs.FileId = e.FileId
+				}
+				return
+			}
+			fs := folderSizeOf(sizes, path.Join(dirPath, rel[:idx]))
+			if e.IsFolder {
+				fs.FolderCount++
+			} else {
+				fs.FileCount++
+				fs.Size += e.FileSize
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := []*FolderSize{}
+	for _, fs := range sizes {
+		result = append(result, fs)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Size != result[j].Size {
+			return result[i].Size > result[j].Size
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+func folderSizeOf(sizes map[string]*FolderSize, p string) *FolderSize {
+	fs, ok := sizes[p]
+	if !ok {
+		fs = &FolderSize{Path: p}
+		sizes[p] = fs
+	}
+	return fs
+}
+
+// isUnder p 是否在文件夹 dir 下，不包括 dir 本身
+func isUnder(p, dir string) bool {
+	if dir == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+func (idx *Index) filter(match func(e *cloudpan.AppSnapshotEntry) bool) (EntryList, error) {
+	result := EntryList{}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return forEachEntry(tx, func(e *cloudpan.AppSnapshotEntry) {
+			if match(e) {
+				result = append(result, e)
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.sortByPath()
+	return result, nil
+}
+
+func (l EntryList) sortByPath() {
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Path < l[j].Path
+	})
+}
+
+func forEachEntry(tx *bolt.Tx, fn func(e *cloudpan.AppSnapshotEntry)) error {
+	return tx.Bucket(bucketEntries).ForEach(func(k, v []byte) error {
+		e := &cloudpan.AppSnapshotEntry{}
+		if err := json.Unmarshal(v, e); err != nil {
+			return err
+		}
+		fn(e)
+		return nil
+	})
+}
+
+func getEntry(tx *bolt.Tx, fileId []byte) (*cloudpan.AppSnapshotEntry, error) {
+	data := tx.Bucket(bucketEntries).Get(fileId)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	e := &cloudpan.AppSnapshotEntry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
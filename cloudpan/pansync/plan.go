@@ -0,0 +1,306 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pansync
+
+import (
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// ActionType 同步动作类型
+	ActionType string
+
+	// LocalFile 本地文件信息
+	LocalFile struct {
+		// RelPath 相对同步目录的路径，使用 / 分隔
+		RelPath string
+		// AbsPath 本地绝对路径
+		AbsPath string
+		Size int64
+		ModTime time.Time
+		IsDir bool
+		// Md5 文件MD5，大写，为空时按需计算
+		Md5 string
+	}
+
+	// PanFile 云盘文件信息
+	PanFile struct {
+		// RelPath 相对同步目录的路径
+		RelPath string
+		*cloudpan.AppFileEntity
+	}
+
+	// Action 同步动作
+	Action struct {
+		Type ActionType
+		// RelPath 相对同步目录的路径
+		RelPath string
+		// Reason 产生该动作的原因
+		Reason string
+		Local *LocalFile
+		Pan *PanFile
+		// Err 执行出错的错误信息
+		Err error
+	}
+
+	// Plan 同步计划
+	Plan struct {
+		Actions []*Action
+	}
+
+	// md5Func 按需计算本地文件MD5
+	md5Func func(lf *LocalFile) (string, error)
+)
+
+const (
+	// ActionUpload 上传本地文件到云盘，云盘已存在则覆盖
+	ActionUpload ActionType = "upload"
+	// ActionDownload 下载云盘文件到本地，本地已存在则覆盖
+	ActionDownload ActionType = "download"
+	// ActionDeleteLocal 删除本地文件
+	ActionDeleteLocal ActionType = "delete-local"
+	// ActionDeletePan 删除云盘文件
+	ActionDeletePan ActionType = "delete-pan"
+	// ActionMkdirLocal 创建本地文件夹
+	ActionMkdirLocal ActionType = "mkdir-local"
+	// ActionMkdirPan 创建云盘文件夹
+	ActionMkdirPan ActionType = "mkdir-pan"
+	// ActionKeepBoth 冲突时保留两端的文件，本地文件加后缀后上传，云盘文件下载到原路径
+	ActionKeepBoth ActionType = "keep-both"
+	// ActionSkip 冲突跳过
+	ActionSkip ActionType = "skip"
+	// actionRecord 两端一致，只更新同步状态
+	actionRecord ActionType = "record"
+)
+
+// Count 统计指定类型的动作数量
+func (pl *Plan) Count(actionType ActionType) int {
+	n := 0
+	for _, a := range pl.Actions {
+		if a.Type == actionType {
+			n++
+		}
+	}
+	return n
+}
+
+// String 输出同步计划，用于 dry-run 模式展示
+func (pl *Plan) String() string {
+	builder := &strings.Builder{}
+	for _, a := range pl.Actions {
+		if a.Type == actionRecord {
+			continue
+		}
+		fmt.Fprintf(builder, "%-12s %s", a.Type, a.RelPath)
+		if a.Reason != "" {
+			fmt.Fprintf(builder, " (%s)", a.Reason)
+		}
+		if a.Err != nil {
+			fmt.Fprintf(builder, " 错误: %s", a.Err)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+func panModTime(pf *PanFile) time.Time {
+	return apiutil.ParseTimeStr(pf.LastOpTime)
+}
+
+// localEqualsState 本地文件是否和上次同步时一致，大小和修改时间都一致则认为没有修改
+func localEqualsState(lf *LocalFile, st *StateEntry) bool {
+	return st != nil && lf.Size == st.Size && lf.ModTime.Unix() == st.LocalModTime
+}
+
+// panEqualsState 云盘文件是否和上次同步时一致
+func panEqualsState(pf *PanFile, st *StateEntry) bool {
+	return st != nil && pf.FileSize == st.Size && strings.EqualFold(pf.FileMd5, st.Md5)
+}
+
+// sameContent 比较本地文件和云盘文件的内容是否一致：大小不同则不一致，
+// 大小相同且本地文件和同步状态一致时使用状态中的MD5，否则计算本地文件的MD5
+func sameContent(lf *LocalFile, pf *PanFile, st *StateEntry, localMd5 md5Func) (bool, error) {
+	if lf.Size != pf.FileSize {
+		return false, nil
+	}
+	if localEqualsState(lf, st) && st.Md5 != "" {
+		return strings.EqualFold(st.Md5, pf.FileMd5), nil
+	}
+	md5, err := localMd5(lf)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(md5, pf.FileMd5), nil
+}
+
+// buildPlan 根据本地文件、云盘文件以及同步状态生成同步计划
+func buildPlan(opt *Option, locals map[string]*LocalFile, pans map[string]*PanFile, state *State, localMd5 md5Func) (*Plan, error) {
+	paths := map[string]bool{}
+	for p := range locals {
+		paths[p] = true
+	}
+	for p := range pans {
+		paths[p] = true
+	}
+	sortedPaths := make([]string, 0, len(paths))
+	for p := range paths {
+		sortedPaths = append(sortedPaths, p)
+	}
+	sort.Strings(sortedPaths)
+
+	plan := &Plan{Actions: []*Action{}}
+	add := func(t ActionType, rel, reason string, lf *LocalFile, pf *PanFile) {
+		plan.Actions = append(plan.Actions, &Action{Type: t, RelPath: rel, Reason: reason, Local: lf, Pan: pf})
+	}
+	// folderDeletes 删除文件夹的动作，在文件夹内的文件处理完后执行
+	var folderDeletes []*Action
+
+	for _, rel := range sortedPaths {
+		lf := locals[rel]
+		pf := pans[rel]
+		st := state.Get(rel)
+
+		// 文件夹
+		if (lf != nil && lf.IsDir) || (pf != nil && pf.IsFolder) {
+			if lf != nil && pf != nil {
+				if lf.IsDir != pf.IsFolder {
+					add(ActionSkip, rel, "本地和云盘一端是文件一端是文件夹", lf, pf)
+				} else {
+					add(actionRecord, rel, "", lf, pf)
+				}
+				continue
+			}
+			// 双向同步时上次同步过的文件夹只剩一端，说明另一端已删除
+			synced := st != nil && st.IsDir
+			if lf != nil {
+				if (opt.Mode == ModeTwoWay && synced) || (opt.Mode == ModeDownload && opt.Delete) {
+					folderDeletes = append(folderDeletes, &Action{Type: ActionDeleteLocal, RelPath: rel, Reason: "云盘不存在该文件夹", Local: lf})
+				} else if opt.Mode != ModeDownload {
+					add(ActionMkdirPan, rel, "云盘不存在该文件夹", lf, nil)
+				}
+			} else {
+				if (opt.Mode == ModeTwoWay && synced) || (opt.Mode == ModeUpload && opt.Delete) {
+					folderDeletes = append(folderDeletes, &Action{Type: ActionDeletePan, RelPath: rel, Reason: "本地不存在该文件夹", Pan: pf})
+				} else if opt.Mode != ModeUpload {
+					add(ActionMkdirLocal, rel, "本地不存在该文件夹", nil, pf)
+				}
+			}
+			continue
+		}
+
+		switch {
+		case lf != nil && pf != nil:
+			same, err := sameContent(lf, pf, st, localMd5)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				add(actionRecord, rel, "", lf, pf)
+				continue
+			}
+			switch opt.Mode {
+			case ModeUpload:
+				add(ActionUpload, rel, "内容不一致", lf, pf)
+			case ModeDownload:
+				add(ActionDownload, rel, "内容不一致", lf, pf)
+			default:
+				localChanged := !localEqualsState(lf, st)
+				panChanged := !panEqualsState(pf, st)
+				if localChanged && !panChanged {
+					add(ActionUpload, rel, "本地文件已修改", lf, pf)
+				} else if panChanged && !localChanged {
+					add(ActionDownload, rel, "云盘文件已修改", lf, pf)
+				} else {
+					resolveConflict(opt, plan, rel, lf, pf)
+				}
+			}
+
+		case lf != nil:
+			if opt.Mode == ModeTwoWay && st != nil && localEqualsState(lf, st) {
+				add(ActionDeleteLocal, rel, "云盘文件已删除", lf, nil)
+			} else if opt.Mode == ModeDownload {
+				if opt.Delete {
+					add(ActionDeleteLocal, rel, "云盘不存在该文件", lf, nil)
+				}
+			} else {
+				add(ActionUpload, rel, "云盘不存在该文件", lf, nil)
+			}
+
+		case pf != nil:
+			if opt.Mode == ModeTwoWay && st != nil && panEqualsState(pf, st) {
+				add(ActionDeletePan, rel, "本地文件已删除", nil, pf)
+			} else if opt.Mode == ModeUpload {
+				if opt.Delete {
+					add(ActionDeletePan, rel, "本地不存在该文件", nil, pf)
+				}
+			} else {
+				add(ActionDownload, rel, "本地不存在该文件", nil, pf)
+			}
+		}
+	}
+	addFolderDeletes(plan, folderDeletes)
+	return plan, nil
+}
+
+// addFolderDeletes 把删除文件夹的动作按路径从深到浅加到计划的最后。文件夹内还有要保留的文件时
+// （例如另一端删除文件夹后这一端又修改了其中的文件）不删除文件夹，避免误删
+func addFolderDeletes(plan *Plan, folderDeletes []*Action) {
+	sort.Slice(folderDeletes, func(i, j int) bool {
+		return folderDeletes[i].RelPath > folderDeletes[j].RelPath
+	})
+	for _, fd := range folderDeletes {
+		prefix := fd.RelPath + "/"
+		for _, a := range plan.Actions {
+			if strings.HasPrefix(a.RelPath, prefix) && a.Type != fd.Type {
+				fd.Type = ActionSkip
+				fd.Reason = "文件夹内还有需要保留的文件，不删除文件夹"
+				break
+			}
+		}
+		plan.Actions = append(plan.Actions, fd)
+	}
+}
+
+// resolveConflict 两端都修改了文件，根据冲突策略处理
+func resolveConflict(opt *Option, plan *Plan, rel string, lf *LocalFile, pf *PanFile) {
+	action := &Action{RelPath: rel, Local: lf, Pan: pf}
+	switch opt.ConflictPolicy {
+	case ConflictNewerWins:
+		lt := lf.ModTime
+		pt := panModTime(pf)
+		if lt.After(pt) {
+			action.Type = ActionUpload
+			action.Reason = "冲突，本地文件较新"
+		} else if pt.After(lt) {
+			action.Type = ActionDownload
+			action.Reason = "冲突，云盘文件较新"
+		} else {
+			action.Type = ActionSkip
+			action.Reason = "冲突，修改时间相同"
+		}
+	case ConflictKeepBoth:
+		action.Type = ActionKeepBoth
+		action.Reason = "冲突，保留两端文件"
+	default:
+		action.Type = ActionSkip
+		action.Reason = "冲突，跳过"
+	}
+	plan.Actions = append(plan.Actions, action)
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pansync
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildPlanTwoWay(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	local := func(rel string, size int64, md5 string, mt time.Time) *LocalFile {
+		return &LocalFile{RelPath: rel, Size: size, Md5: md5, ModTime: mt}
+	}
+	pan := func(rel string, size int64, md5 string) *PanFile {
+		return &PanFile{RelPath: rel, AppFileEntity: &cloudpan.AppFileEntity{FileName: rel, FileSize: size, FileMd5: md5, LastOpTime: "2021-01-01 08:30:00"}}
+	}
+
+	locals := map[string]*LocalFile{
+		"same.txt": local("same.txt", 1, "AA", t0),
+		"local-changed.txt": local("local-changed.txt", 2, "BB", t1),
+		"pan-changed.txt": local("pan-changed.txt", 1, "CC", t0),
+		"both-changed.txt": local("both-changed.txt", 3, "DD", t1),
+		"pan-deleted.txt": local("pan-deleted.txt", 1, "EE", t0),
+		"new-local.txt": local("new-local.txt", 1, "FF", t0),
+	}
+	pans := map[string]*PanFile{
+		"same.txt": pan("same.txt", 1, "aa"),
+		"local-changed.txt": pan("local-changed.txt", 1, "B0"),
+		"pan-changed.txt": pan("pan-changed.txt", 2, "C1"),
+		"both-changed.txt": pan("both-changed.txt", 4, "D1"),
+		"local-deleted.txt": pan("local-deleted.txt", 1, "GG"),
+		"new-pan.txt": pan("new-pan.txt", 1, "HH"),
+	}
+	state, _ := LoadState("")
+	for _, rel := range []string{"same.txt", "local-changed.txt", "pan-changed.txt", "both-changed.txt", "pan-deleted.txt", "local-deleted.txt"} {
+		st := &StateEntry{Size: 1, LocalModTime: t0.Unix()}
+		switch rel {
+		case "local-changed.txt":
+			st.Md5 = "B0"
+		case "pan-changed.txt":
+			st.Md5 = "CC"
+		case "pan-deleted.txt":
+			st.Md5 = "EE"
+		case "local-deleted.txt":
+			st.Md5 = "GG"
+		case "same.txt":
+			st.Md5 = "AA"
+		default:
+			st.Md5 = "D0"
+		}
+		state.Set(rel, st)
+	}
+
+	opt := &Option{Mode: ModeTwoWay, ConflictPolicy: ConflictNewerWins}
+	plan, err := buildPlan(opt, locals, pans, state, func(lf *LocalFile) (string, error) { return lf.Md5, nil })
+	assert.Nil(t, err)
+
+	types := map[string]ActionType{}
+	for _, a := range plan.Actions {
+		types[a.RelPath] = a.Type
+	}
+	assert.Equal(t, actionRecord, types["same.txt"])
+	assert.Equal(t, ActionUpload, types["local-changed.txt"])
+	assert.Equal(t, ActionDownload, types["pan-changed.txt"])
+	assert.Equal(t, ActionUpload, types["both-changed.txt"])
+	assert.Equal(t, ActionDeleteLocal, types["pan-deleted.txt"])
+	assert.Equal(t, ActionDeletePan, types["local-deleted.txt"])
+	assert.Equal(t, ActionUpload, types["new-local.txt"])
+	assert.Equal(t, ActionDownload, types["new-pan.txt"])
+
+	opt.ConflictPolicy = ConflictKeepBoth
+	plan, _ = buildPlan(opt, locals, pans, state, func(lf *LocalFile) (string, error) { return lf.Md5, nil })
+	for _, a := range plan.Actions {
+		if a.RelPath == "both-changed.txt" {
+			assert.Equal(t, ActionKeepBoth, a.Type)
+		}
+	}
+}
+
+func TestBuildPlanFolders(t *testing.T) {
+	localDir := func(rel string) *LocalFile {
+		return &LocalFile{RelPath: rel, IsDir: true}
+	}
+	panDir := func(rel string) *PanFile {
+		return &PanFile{RelPath: rel, AppFileEntity: &cloudpan.AppFileEntity{FileName: rel, IsFolder: true}}
+	}
+	locals := map[string]*LocalFile{
+		"both": localDir("both"),
+		"new-local": localDir("new-local"),
+		"pan-deleted": localDir("pan-deleted"),
+		"pan-deleted/sub": localDir("pan-deleted/sub"),
+		"pan-deleted/sub/a.txt": {RelPath: "pan-deleted/sub/a.txt", Size: 1, Md5: "AA", ModTime: time.Unix(100, 0)},
+		"pan-deleted-kept": localDir("pan-deleted-kept"),
+		"pan-deleted-kept/new.txt": {RelPath: "pan-deleted-kept/new.txt", Size: 1, Md5: "BB", ModTime: time.Unix(100, 0)},
+	}
+	pans := map[string]*PanFile{
+		"both": panDir("both"),
+		"new-pan": panDir("new-pan"),
+		"local-deleted": panDir("local-deleted"),
+	}
+	state, _ := LoadState("")
+	for _, rel := range []string{"both", "pan-deleted", "pan-deleted/sub", "pan-deleted-kept", "local-deleted"} {
+		state.Set(rel, &StateEntry{IsDir: true})
+	}
+	state.Set("pan-deleted/sub/a.txt", &StateEntry{Size: 1, Md5: "AA", LocalModTime: 100})
+
+	md5 := func(lf *LocalFile) (string, error) { return lf.Md5, nil }
+	plan, err := buildPlan(&Option{Mode: ModeTwoWay}, locals, pans, state, md5)
+	assert.Nil(t, err)
+	types := map[string]ActionType{}
+	order := []string{}
+	for _, a := range plan.Actions {
+		types[a.RelPath] = a.Type
+		order = append(order, a.RelPath)
+	}
+	assert.Equal(t, actionRecord, types["both"])
+	assert.Equal(t, ActionMkdirPan, types["new-local"])
+	assert.Equal(t, ActionMkdirLocal, types["new-pan"])
+	assert.Equal(t, ActionDeletePan, types["local-deleted"])
+	assert.Equal(t, ActionDeleteLocal, types["pan-deleted/sub/a.txt"])
+	assert.Equal(t, ActionDeleteLocal, types["pan-deleted/sub"])
+	assert.Equal(t, ActionDeleteLocal, types["pan-deleted"])
+	// 文件夹中有新文件，不删除文件夹
+	assert.Equal(t, ActionUpload, types["pan-deleted-kept/new.txt"])
+	assert.Equal(t, ActionSkip, types["pan-deleted-kept"])
+	// 文件先删除，子文件夹在上级文件夹之前删除
+	index := func(rel string) int {
+		for i, p := range order {
+			if p == rel {
+				return i
+			}
+		}
+		return -1
+	}
+	assert.True(t, index("pan-deleted/sub/a.txt") < index("pan-deleted/sub"))
+	assert.True(t, index("pan-deleted/sub") < index("pan-deleted"))
+
+	// 单向同步只在开启删除时删除多余的文件夹
+	plan, _ = buildPlan(&Option{Mode: ModeUpload}, locals, pans, state, md5)
+	assert.Equal(t, 0, plan.Count(ActionDeletePan))
+	assert.Equal(t, 0, plan.Count(ActionMkdirLocal))
+	plan, _ = buildPlan(&Option{Mode: ModeUpload, Delete: true}, locals, pans, state, md5)
+	assert.Equal(t, 2, plan.Count(ActionDeletePan))
+}
+
+func TestStateSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pansync")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	s, err := LoadState(statePath)
+	assert.Nil(t, err)
+	s.Set("a", &StateEntry{IsDir: true})
+	s.Set("a/b.txt", &StateEntry{Size: 1, Md5: "AA"})
+	assert.Nil(t, s.saveIfDue())
+	// 间隔内不重复保存
+	s.Set("c.txt", &StateEntry{Size: 2})
+	assert.Nil(t, s.saveIfDue())
+
+	s2, err := LoadState(statePath)
+	assert.Nil(t, err)
+	assert.True(t, s2.Get("a").IsDir)
+	assert.Equal(t, "AA", s2.Get("a/b.txt").Md5)
+	assert.Nil(t, s2.Get("c.txt"))
+}
+
+func TestNewEngineTwoWayRequiresState(t *testing.T) {
+	_, err := NewEngine(nil, &Option{})
+	assert.NotNil(t, err)
+
+	// 默认为双向同步，同样需要状态数据库文件
+	_, err = NewEngine(nil, &Option{LocalDir: "local", PanDir: "/sync"})
+	assert.NotNil(t, err)
+
+	engine, err := NewEngine(nil, &Option{LocalDir: "local", PanDir: "/sync", Mode: ModeUpload})
+	assert.Nil(t, err)
+	assert.Equal(t, ModeUpload, engine.opt.Mode)
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pansync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// StateEntry 上一次同步完成时文件的状态
+	StateEntry struct {
+		// Size 文件大小
+		Size int64 `json:"size"`
+		// Md5 文件MD5，大写
+		Md5 string `json:"md5"`
+		// LocalModTime 本地文件修改时间，unix时间戳
+		LocalModTime int64 `json:"localModTime"`
+		// PanFileId 云盘文件ID
+		PanFileId string `json:"panFileId"`
+		// PanRev 云盘文件版本号
+		PanRev string `json:"panRev"`
+		// IsDir 是否是文件夹
+		IsDir bool `json:"isDir"`
+	}
+
+	// State 同步状态数据库，保存在本地文件中，记录每个文件上一次同步完成时的状态，
+	// 用于双向同步时判断哪一端发生了修改或者删除
+	State struct {
+		// Entries 相对路径 -> 文件状态
+		Entries map[string]*StateEntry `json:"entries"`
+
+		path   string
+		locker sync.Mutex
+		// lastSave 上次保存的时间，unix纳秒
+		lastSave int64
+	}
+)
+
+const (
+	// stateSaveInterval 同步过程中保存状态数据库的最小间隔
+	stateSaveInterval = 2 * time.Second
+)
+
+// LoadState 加载同步状态数据库，文件不存在则返回空的状态
+func LoadState(statePath string) (*State, error) {
+	s := &State{
+		Entries: map[string]*StateEntry{},
+		path: statePath,
+	}
+	if statePath == "" {
+		return s, nil
+	}
+	f, err := os.Open(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(s); err != nil {
+		return nil, err
+	}
+	if s.Entries == nil {
+		s.Entries = map[string]*StateEntry{}
+	}
+	return s, nil
+}
+
+// Get 获取文件状态
+func (s *State) Get(relPath string) *StateEntry {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.Entries[relPath]
+}
+
+// Set 设置文件状态
+func (s *State) Set(relPath string, entry *StateEntry) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.Entries[relPath] = entry
+}
+
+// Delete 删除文件状态
+func (s *State) Delete(relPath string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	delete(s.Entries, relPath)
+}
+
+// Save 保存同步状态数据库，先写入临时文件再替换，避免中断导致数据库损坏
+func (s *State) Save() error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.save()
+}
+
+// saveIfDue 距离上次保存超过一定时间时保存，同步中断后已完成的文件不需要重新比较
+func (s *State) saveIfDue() error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if time.Duration(time.Now().UnixNano()-s.lastSave) < stateSaveInterval {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *State) save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.lastSave = time.Now().UnixNano()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,429 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pansync 本地文件夹和云盘文件夹的同步，支持个人云和家庭云
+package pansync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"github.com/phpc0de/ctlibgo/logger"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// Mode 同步模式
+	Mode int
+
+	// ConflictPolicy 双向同步时两端都修改了文件的冲突处理策略
+	ConflictPolicy int
+
+	// Option 同步参数
+	Option struct {
+		// FamilyId 家庭云ID，个人云为0
+		FamilyId int64
+		// LocalDir 本地文件夹路径
+		LocalDir string
+		// PanDir 云盘文件夹绝对路径
+		PanDir string
+		// Mode 同步模式
+		Mode Mode
+		// ConflictPolicy 冲突处理策略
+		ConflictPolicy ConflictPolicy
+		// KeepBothSuffix 保留两端文件时本地文件添加的后缀，默认为 .conflict
+		KeepBothSuffix string
+		// Delete 单向同步时是否删除目标端多余的文件
+		Delete bool
+		// StateFile 同步状态数据库文件路径，为空则不保存状态，双向同步必须指定
+		StateFile string
+		// DryRun 只生成同步计划，不执行
+		DryRun bool
+	}
+
+	// Engine 同步引擎
+	Engine struct {
+		client *cloudpan.PanClient
+		opt    *Option
+		state  *State
+
+		// panFolders 云盘文件夹相对路径 -> 文件夹ID
+		panFolders map[string]string
+	}
+)
+
+const (
+	// ModeUpload 本地同步到云盘
+	ModeUpload Mode = 1
+	// ModeDownload 云盘同步到本地
+	ModeDownload Mode = 2
+	// ModeTwoWay 双向同步
+	ModeTwoWay Mode = 3
+
+	// ConflictNewerWins 修改时间较新的一端覆盖另一端
+	ConflictNewerWins ConflictPolicy = 1
+	// ConflictKeepBoth 保留两端的文件
+	ConflictKeepBoth ConflictPolicy = 2
+	// ConflictSkip 跳过冲突文件
+	ConflictSkip ConflictPolicy = 3
+
+	// DefaultKeepBothSuffix 默认的冲突文件后缀
+	DefaultKeepBothSuffix = ".conflict"
+)
+
+// NewEngine 创建同步引擎
+func NewEngine(client *cloudpan.PanClient, opt *Option) (*Engine, error) {
+	if opt.LocalDir == "" || opt.PanDir == "" {
+		return nil, errors.New("本地文件夹和云盘文件夹不能为空")
+	}
+	if !path.IsAbs(opt.PanDir) {
+		return nil, errors.New("云盘文件夹必须是绝对路径")
+	}
+	if opt.Mode == 0 {
+		opt.Mode = ModeTwoWay
+	}
+	if opt.Mode == ModeTwoWay && opt.StateFile == "" {
+		return nil, errors.New("双向同步必须指定同步状态数据库文件")
+	}
+	if opt.ConflictPolicy == 0 {
+		opt.ConflictPolicy = ConflictSkip
+	}
+	if opt.KeepBothSuffix == "" {
+		opt.KeepBothSuffix = DefaultKeepBothSuffix
+	}
+	absDir, err := filepath.Abs(opt.LocalDir)
+	if err != nil {
+		return nil, err
+	}
+	opt.LocalDir = absDir
+	opt.PanDir = path.Clean(opt.PanDir)
+
+	state, err := LoadState(opt.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{
+		client: client,
+		opt: opt,
+		state: state,
+		panFolders: map[string]string{},
+	}, nil
+}
+
+// Plan 扫描两端文件并生成同步计划，不执行任何修改
+func (e *Engine) Plan() (*Plan, error) {
+	locals, err := e.scanLocal()
+	if err != nil {
+		return nil, err
+	}
+	pans, err := e.scanPan()
+	if err != nil {
+		return nil, err
+	}
+	return buildPlan(e.opt, locals, pans, e.state, e.localMd5)
+}
+
+// Run 执行同步，DryRun 模式下只返回同步计划。单个文件出错不会中断同步，错误记录在对应的 Action 中
+func (e *Engine) Run() (*Plan, error) {
+	plan, err := e.Plan()
+	if err != nil {
+		return nil, err
+	}
+	if e.opt.DryRun {
+		return plan, nil
+	}
+
+	for _, a := range plan.Actions {
+		a.Err = e.execute(a)
+		if a.Err != nil {
+			logger.Verboseln("sync action failed: ", a.Type, a.RelPath, a.Err)
+		}
+		if err := e.state.saveIfDue(); err != nil {
+			logger.Verboseln("save sync state failed: ", err)
+		}
+	}
+	return plan, e.state.Save()
+}
+
+func (e *Engine) localPath(rel string) string {
+	return filepath.Join(e.opt.LocalDir, filepath.FromSlash(rel))
+}
+
+func (e *Engine) scanLocal() (map[string]*LocalFile, error) {
+	locals := map[string]*LocalFile{}
+	if _, err := os.Stat(e.opt.LocalDir); os.IsNotExist(err) {
+		return locals, nil
+	}
+	stateFile, _ := filepath.Abs(e.opt.StateFile)
+	err := filepath.Walk(e.opt.LocalDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == e.opt.LocalDir || p == stateFile || p == stateFile+".tmp" || strings.HasSuffix(p, downloadTmpSuffix) {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(e.opt.LocalDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		locals[rel] = &LocalFile{
+			RelPath: rel,
+			AbsPath: p,
+			Size: info.Size(),
+			ModTime: info.ModTime(),
+			IsDir: info.IsDir(),
+		}
+		return nil
+	})
+	return locals, err
+}
+
+func (e *Engine) scanPan() (map[string]*PanFile, error) {
+	pans := map[string]*PanFile{}
+	root, apiErr := e.client.AppFileInfoByPath(e.opt.FamilyId, e.opt.PanDir)
+	if apiErr != nil {
+		if apiErr.Code == apierror.ApiCodeFileNotFoundCode {
+			return pans, nil
+		}
+		return nil, apiErr
+	}
+	if !root.IsFolder {
+		return nil, errors.New("云盘路径不是文件夹: " + e.opt.PanDir)
+	}
+	e.panFolders[""] = root.FileId
+	return pans, e.scanPanFolder(root.FileId, "", pans)
+}
+
+func (e *Engine) scanPanFolder(folderId, rel string, pans map[string]*PanFile) error {
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = e.opt.FamilyId
+	param.FileId = folderId
+	r, apiErr := e.client.AppGetAllFileList(param)
+	if apiErr != nil {
+		return apiErr
+	}
+	for _, fe := range r.FileList {
+		childRel := path.Join(rel, fe.FileName)
+		pans[childRel] = &PanFile{RelPath: childRel, AppFileEntity: fe}
+		if fe.IsFolder {
+			e.panFolders[childRel] = fe.FileId
+			time.Sleep(time.Duration(200) * time.Millisecond)
+			if err := e.scanPanFolder(fe.FileId, childRel, pans); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// localMd5 计算本地文件MD5，结果缓存在 LocalFile 中
+func (e *Engine) localMd5(lf *LocalFile) (string, error) {
+	if lf.Md5 != "" {
+		return lf.Md5, nil
+	}
+	f, err := os.Open(lf.AbsPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	lf.Md5 = strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+	return lf.Md5, nil
+}
+
+// panFolderId 获取云盘文件夹ID，不存在则创建
+func (e *Engine) panFolderId(rel string) (string, error) {
+	if id, ok := e.panFolders[rel]; ok {
+		return id, nil
+	}
+	r, apiErr := e.client.AppMkdirAll(e.opt.FamilyId, path.Join(e.opt.PanDir, rel))
+	if apiErr != nil {
+		return "", apiErr
+	}
+	e.panFolders[rel] = r.FileId
+	return r.FileId, nil
+}
+
+func (e *Engine) execute(a *Action) error {
+	switch a.Type {
+	case actionRecord:
+		if a.Local.IsDir {
+			e.recordFolder(a.RelPath, a.Pan.FileId)
+		} else {
+			e.record(a.RelPath, a.Local, a.Pan.AppFileEntity)
+		}
+	case ActionSkip:
+	case ActionMkdirPan:
+		id, err := e.panFolderId(a.RelPath)
+		if err != nil {
+			return err
+		}
+		e.recordFolder(a.RelPath, id)
+	case ActionMkdirLocal:
+		if err := os.MkdirAll(e.localPath(a.RelPath), 0755); err != nil {
+			return err
+		}
+		e.recordFolder(a.RelPath, a.Pan.FileId)
+	case ActionUpload:
+		return e.upload(a.RelPath, a.Local, a.Pan)
+	case ActionDownload:
+		return e.download(a.RelPath, a.Pan)
+	case ActionDeleteLocal:
+		// 文件夹在其中的文件删除后才执行，使用 Remove 而不是 RemoveAll，文件夹不为空时报错而不是误删
+		if err := os.Remove(a.Local.AbsPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		e.state.Delete(a.RelPath)
+	case ActionDeletePan:
+		if err := e.deletePan(a.Pan); err != nil {
+			return err
+		}
+		if a.Pan.IsFolder {
+			delete(e.panFolders, a.RelPath)
+		}
+		e.state.Delete(a.RelPath)
+	case ActionKeepBoth:
+		return e.keepBoth(a)
+	}
+	return nil
+}
+
+func (e *Engine) record(rel string, lf *LocalFile, fe *cloudpan.AppFileEntity) {
+	e.state.Set(rel, &StateEntry{
+		Size: lf.Size,
+		Md5: strings.ToUpper(fe.FileMd5),
+		LocalModTime: lf.ModTime.Unix(),
+		PanFileId: fe.FileId,
+		PanRev: fe.Rev,
+	})
+}
+
+// recordFolder 记录文件夹已同步，双向同步时用于判断文件夹是否被删除
+func (e *Engine) recordFolder(rel, panFileId string) {
+	e.state.Set(rel, &StateEntry{
+		PanFileId: panFileId,
+		IsDir: true,
+	})
+}
+
+func (e *Engine) upload(rel string, lf *LocalFile, existed *PanFile) error {
+	md5, err := e.localMd5(lf)
+	if err != nil {
+		return err
+	}
+	parentId, err := e.panFolderId(path.Dir(rel))
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(lf.AbsPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, apiErr := e.client.AppUploadFileFromReader(&cloudpan.AppCreateUploadFileParam{
+		FamilyId: e.opt.FamilyId,
+		ParentFolderId: parentId,
+		FileName: path.Base(rel),
+		Size: lf.Size,
+		Md5: md5,
+		LastWrite: apiutil.FormatTime(lf.ModTime),
+		LocalPath: lf.AbsPath,
+	}, f, true)
+	if apiErr != nil {
+		return apiErr
+	}
+	if existed != nil && e.opt.FamilyId > 0 {
+		// 家庭云上传不支持覆盖，新文件上传成功后再删除旧文件，上传失败时保留旧文件
+		if apiErr := e.client.AppFamilyReplaceFile(e.opt.FamilyId, existed.AppFileEntity, r.Id, r.Name); apiErr != nil {
+			return apiErr
+		}
+	}
+	e.record(rel, lf, &cloudpan.AppFileEntity{FileId: r.Id, FileMd5: md5, Rev: r.Rev})
+	return nil
+}
+
+func (e *Engine) download(rel string, pf *PanFile) error {
+	localPath := e.localPath(rel)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := localPath + downloadTmpSuffix
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, apiErr := e.client.AppDownloadFileToWriter(e.opt.FamilyId, pf.FileId, cloudpan.AppFileDownloadRange{}, f)
+	if err := f.Close(); err != nil && apiErr == nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if apiErr != nil {
+		os.Remove(tmpPath)
+		return apiErr
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return err
+	}
+	modTime := panModTime(pf)
+	if !modTime.IsZero() {
+		os.Chtimes(localPath, modTime, modTime)
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	e.record(rel, &LocalFile{Size: info.Size(), ModTime: info.ModTime()}, pf.AppFileEntity)
+	return nil
+}
+
+func (e *Engine) deletePan(pf *PanFile) error {
+	if apiErr := e.client.AppDeleteFileByFamily(e.opt.FamilyId, cloudpan.AppFileList{pf.AppFileEntity}); apiErr != nil {
+		return apiErr
+	}
+	return nil
+}
+
+// keepBoth 本地文件加后缀重命名后上传，云盘文件下载到原路径
+func (e *Engine) keepBoth(a *Action) error {
+	ext := path.Ext(a.RelPath)
+	conflictRel := strings.TrimSuffix(a.RelPath, ext) + e.opt.KeepBothSuffix + ext
+	conflictPath := e.localPath(conflictRel)
+	if err := os.Rename(a.Local.AbsPath, conflictPath); err != nil {
+		return err
+	}
+	conflictLocal := *a.Local
+	conflictLocal.RelPath = conflictRel
+	conflictLocal.AbsPath = conflictPath
+	if err := e.upload(conflictRel, &conflictLocal, nil); err != nil {
+		return err
+	}
+	return e.download(a.RelPath, a.Pan)
+}
+
+const downloadTmpSuffix = ".pansync.tmp"
@@ -0,0 +1,284 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// RecycleIterator 按需翻页遍历回收站的所有文件
+	//
+	//	it := client.RecycleIterator()
+	//	for it.Next() {
+	//		fe := it.Item()
+	//	}
+	//	if it.Err() != nil {
+	//	}
+	RecycleIterator struct {
+		fetch recyclePageFunc
+		pageNum int
+		page *RecycleFileListResult
+		idx int
+		total int
+		done bool
+		err *apierror.ApiError
+	}
+
+	recyclePageFunc func(pageNum int) (*RecycleFileListResult, *apierror.ApiError)
+
+	// RecycleFilter 回收站文件过滤条件，零值的条件不生效，所有条件都满足才匹配
+	RecycleFilter struct {
+		// NamePattern 文件名通配符，规则同 path.Match，例如 *.jpg
+		NamePattern string
+		// PathPrefix 文件删除前所在的路径，只匹配该路径本身以及其下的文件，例如 /项目X
+		PathPrefix string
+		// DeletedAfter 只匹配在该时间之后删除的文件
+		DeletedAfter time.Time
+		// DeletedBefore 只匹配在该时间之前删除的文件
+		DeletedBefore time.Time
+		// MinSize 文件大小下限，文件夹大小为0
+		MinSize int64
+		// MaxSize 文件大小上限，<=0 不限制
+		MaxSize int64
+	}
+
+	// RecycleItem 回收站文件以及所属的家庭云
+	RecycleItem struct {
+		*RecycleFileInfo
+		// FamilyId 家庭云ID，个人云为0
+		FamilyId int64
+	}
+
+	RecycleItemList []*RecycleItem
+)
+
+const (
+	recyclePageSize = 100
+)
+
+// RecycleIterator 创建回收站遍历器，第一次调用 Next 时才会请求第一页
+func (p *PanClient) RecycleIterator() *RecycleIterator {
+	return newRecycleIterator(func(pageNum int) (*RecycleFileListResult, *apierror.ApiError) {
+		return p.RecycleList(pageNum, recyclePageSize)
+	})
+}
+
+func newRecycleIterator(fetch recyclePageFunc) *RecycleIterator {
+	return &RecycleIterator{fetch: fetch}
+}
+
+// Next 移动到下一个文件，没有更多文件或者出错时返回 false
+func (it *RecycleIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.page != nil && it.idx+1 < len(it.page.Data) {
+		it.idx++
+		return true
+	}
+	if it.done {
+		return false
+	}
+	it.pageNum++
+	page, apiErr := it.fetch(it.pageNum)
+	if apiErr != nil {
+		it.err = apiErr
+		return false
+	}
+	it.page = page
+	it.idx = 0
+	it.total += len(page.Data)
+	if len(page.Data) == 0 || it.total >= int(page.RecordCount) {
+		it.done = true
+	}
+	return len(page.Data) > 0
+}
+
+// Item 当前文件
+func (it *RecycleIterator) Item() *RecycleFileInfo {
+	return it.page.Data[it.idx]
+}
+
+// FamilyId 当前文件所属的家庭云，个人云为0
+func (it *RecycleIterator) FamilyId() int64 {
+	if it.Item().IsFamilyFile {
+		return it.page.FamilyId
+	}
+	return 0
+}
+
+// Err 遍历过程中发生的错误
+func (it *RecycleIterator) Err() *apierror.ApiError {
+	return it.err
+}
+
+// DeletedTime 文件删除的时间，即最后修改时间
+func (fe *RecycleFileInfo) DeletedTime() time.Time {
+	return apiutil.ParseTimeStr(fe.LastOpTime)
+}
+
+// Match 文件是否满足过滤条件
+func (f *RecycleFilter) Match(fe *RecycleFileInfo) bool {
+	if f.NamePattern != "" {
+		if ok, _ := path.Match(f.NamePattern, fe.FileName); !ok {
+			return false
+		}
+	}
+	if f.PathPrefix != "" {
+		prefix := path.Clean("/" + f.PathPrefix)
+		p := path.Clean("/" + fe.PathStr)
+		if prefix != "/" && p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	}
+	if !f.DeletedAfter.IsZero() || !f.DeletedBefore.IsZero() {
+		t := fe.DeletedTime()
+		if t.IsZero() {
+			return false
+		}
+		if !f.DeletedAfter.IsZero() && t.Before(f.DeletedAfter) {
+			return false
+		}
+		if !f.DeletedBefore.IsZero() && !t.Before(f.DeletedBefore) {
+			return false
+		}
+	}
+	if fe.FileSize < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && fe.FileSize > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+// FileInfoList 回收站文件列表
+func (l RecycleItemList) FileInfoList() RecycleFileInfoList {
+	r := RecycleFileInfoList{}
+	for _, item := range l {
+		r = append(r, item.RecycleFileInfo)
+	}
+	return r
+}
+
+// RecycleFind 获取回收站中满足过滤条件的所有文件，filter 为 nil 返回所有文件
+func (p *PanClient) RecycleFind(filter *RecycleFilter) (RecycleItemList, *apierror.ApiError) {
+	return findRecycleItems(p.RecycleIterator(), filter)
+}
+
+func findRecycleItems(it *RecycleIterator, filter *RecycleFilter) (RecycleItemList, *apierror.ApiError) {
+	result := RecycleItemList{}
+	for it.Next() {
+		fe := it.Item()
+		if filter != nil && !filter.Match(fe) {
+			continue
+		}
+		result = append(result, &RecycleItem{
+			RecycleFileInfo: fe,
+			FamilyId: it.FamilyId(),
+		})
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	return result, nil
+}
+
+// RecycleRestoreByFilter 还原回收站中满足过滤条件的文件到原来的位置，并等待还原任务结束，
+// 例如还原最近24小时从 /项目X 删除的文件：
+//
+//	client.RecycleRestoreByFilter(ctx, &RecycleFilter{PathPrefix: "/项目X", DeletedAfter: time.Now().Add(-24 * time.Hour)}, nil)
+func (p *PanClient) RecycleRestoreByFilter(ctx context.Context, filter *RecycleFilter, opt *BatchTaskWaitOption) (RecycleItemList, *BatchTaskSummary, *apierror.ApiError) {
+	items, apiErr := p.RecycleFind(filter)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	summary := &BatchTaskSummary{}
+	// 先获取全部文件再还原，避免还原过程中翻页错位
+	infoList := makeBatchTaskInfoList(items.FileInfoList())
+	for _, chunk := range splitBatchTaskInfoList(infoList, BatchTaskMaxFileCount) {
+		taskId, apiErr := p.CreateBatchTask(&BatchTaskParam{
+			TypeFlag: BatchTaskTypeRecycleRestore,
+			TaskInfos: chunk,
+		})
+		if apiErr != nil {
+			return items, summary, apiErr
+		}
+		r, apiErr := p.WaitBatchTask(ctx, 0, BatchTaskTypeRecycleRestore, taskId, opt)
+		summary.add(taskId, r)
+		if apiErr != nil {
+			return items, summary, apiErr
+		}
+	}
+	return items, summary, nil
+}
+
+// RecyclePurgeByFilter 彻底删除回收站中满足过滤条件的文件，返回已删除的文件
+func (p *PanClient) RecyclePurgeByFilter(filter *RecycleFilter) (RecycleItemList, *apierror.ApiError) {
+	items, apiErr := p.RecycleFind(filter)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	purged := RecycleItemList{}
+	for _, group := range groupRecycleItemsByFamily(items) {
+		for start := 0; start < len(group); start += BatchTaskMaxFileCount {
+			end := start + BatchTaskMaxFileCount
+			if end > len(group) {
+				end = len(group)
+			}
+			fileIdList := []string{}
+			for _, item := range group[start:end] {
+				fileIdList = append(fileIdList, item.FileId)
+			}
+			if apiErr := p.RecycleDelete(group[start].FamilyId, fileIdList); apiErr != nil {
+				return purged, apiErr
+			}
+			purged = append(purged, group[start:end]...)
+		}
+	}
+	return purged, nil
+}
+
+// RecyclePurgeOlderThan 彻底删除回收站中删除时间超过 days 天的文件
+func (p *PanClient) RecyclePurgeOlderThan(days int) (RecycleItemList, *apierror.ApiError) {
+	if days < 0 {
+		return nil, apierror.NewFailedApiError("天数不能小于0")
+	}
+	return p.RecyclePurgeByFilter(&RecycleFilter{
+		DeletedBefore: time.Now().AddDate(0, 0, -days),
+	})
+}
+
+// groupRecycleItemsByFamily 按所属的家庭云分组，保持原来的顺序
+func groupRecycleItemsByFamily(items RecycleItemList) []RecycleItemList {
+	groups := []RecycleItemList{}
+	index := map[int64]int{}
+	for _, item := range items {
+		i, ok := index[item.FamilyId]
+		if !ok {
+			i = len(groups)
+			index[item.FamilyId] = i
+			groups = append(groups, RecycleItemList{})
+		}
+		groups[i] = append(groups[i], item)
+	}
+	return groups
+}
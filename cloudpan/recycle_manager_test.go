@@ -0,0 +1,168 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRecycleIterator(t *testing.T) {
+	// 5个文件，每页2个
+	all := RecycleFileInfoList{}
+	for i := 0; i < 5; i++ {
+		all = append(all, &RecycleFileInfo{FileId: strconv.Itoa(i), IsFamilyFile: i == 4})
+	}
+	fetched := 0
+	it := newRecycleIterator(func(pageNum int) (*RecycleFileListResult, *apierror.ApiError) {
+		fetched++
+		start := (pageNum - 1) * 2
+		end := start + 2
+		if end > len(all) {
+			end = len(all)
+		}
+		return &RecycleFileListResult{Data: all[start:end], RecordCount: uint(len(all)), FamilyId: 99}, nil
+	})
+	assert.Equal(t, 0, fetched)
+
+	ids := []string{}
+	families := []int64{}
+	for it.Next() {
+		ids = append(ids, it.Item().FileId)
+		families = append(families, it.FamilyId())
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, ids)
+	assert.Equal(t, []int64{0, 0, 0, 0, 99}, families)
+	assert.Equal(t, 3, fetched)
+	assert.False(t, it.Next())
+
+	it = newRecycleIterator(func(pageNum int) (*RecycleFileListResult, *apierror.ApiError) {
+		return nil, apierror.NewFailedApiError("failed")
+	})
+	_, apiErr := findRecycleItems(it, nil)
+	assert.NotNil(t, apiErr)
+}
+
+func TestRecycleFilter(t *testing.T) {
+	now := time.Now()
+	fe := &RecycleFileInfo{
+		FileName: "报告.docx",
+		FileSize: 1024,
+		PathStr: "/项目X/文档/报告.docx",
+		LastOpTime: apiutil.FormatTime(now.Add(-time.Hour)),
+	}
+	assert.True(t, (&RecycleFilter{}).Match(fe))
+	assert.True(t, (&RecycleFilter{PathPrefix: "/项目X", DeletedAfter: now.Add(-24 * time.Hour)}).Match(fe))
+	assert.True(t, (&RecycleFilter{PathPrefix: "/"}).Match(fe))
+	assert.False(t, (&RecycleFilter{PathPrefix: "/项目"}).Match(fe))
+	assert.True(t, (&RecycleFilter{NamePattern: "*.docx"}).Match(fe))
+	assert.False(t, (&RecycleFilter{NamePattern: "*.jpg"}).Match(fe))
+	assert.False(t, (&RecycleFilter{DeletedAfter: now.Add(-time.Minute)}).Match(fe))
+	assert.True(t, (&RecycleFilter{DeletedBefore: now}).Match(fe))
+	assert.False(t, (&RecycleFilter{DeletedBefore: now.AddDate(0, 0, -30)}).Match(fe))
+	assert.False(t, (&RecycleFilter{MinSize: 2048}).Match(fe))
+	assert.False(t, (&RecycleFilter{MaxSize: 100}).Match(fe))
+
+	fe.LastOpTime = ""
+	assert.False(t, (&RecycleFilter{DeletedBefore: now}).Match(fe))
+
+	groups := groupRecycleItemsByFamily(RecycleItemList{
+		{RecycleFileInfo: &RecycleFileInfo{FileId: "1"}},
+		{RecycleFileInfo: &RecycleFileInfo{FileId: "2"}, FamilyId: 9},
+		{RecycleFileInfo: &RecycleFileInfo{FileId: "3"}},
+	})
+	assert.Equal(t, 2, len(groups))
+	assert.Equal(t, "3", groups[0][1].FileId)
+}
+
+func TestPlanRecycleRestore(t *testing.T) {
+	fileList := RecycleFileInfoList{
+		{FileId: "1", FileName: "a.txt", PathStr: "/项目X/文档/a.txt"},
+		{FileId: "2", FileName: "b.txt", PathStr: "/项目X/文档"},
+		{FileId: "3", FileName: "c.txt", PathStr: "/c.txt"},
+	}
+	groups := planRecycleRestore(fileList, "")
+	assert.Equal(t, 2, len(groups))
+	// 上级文件夹先还原
+	assert.Equal(t, "/", groups[0].targetPath)
+	assert.Equal(t, "/项目X/文档", groups[1].targetPath)
+	assert.Equal(t, 2, len(groups[1].fileList))
+
+	groups = planRecycleRestore(fileList, "/恢复/")
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, "/恢复", groups[0].targetPath)
+	assert.Equal(t, 3, len(groups[0].fileList))
+}
+
+func TestRecycleRestoreNotInFolder(t *testing.T) {
+	fileList := RecycleFileInfoList{
+		{FileId: "1", FileName: "a.txt"},
+		{FileId: "2", FileName: "b.txt"},
+		{FileId: "3", FileName: "c.txt"},
+	}
+	list := AppFileList{
+		{FileId: "2", FileName: "b.txt"},
+		{FileId: "9", FileName: "a.txt"},
+	}
+	r := notInFolder(list, fileList)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, "1", r[0].FileId)
+	assert.Equal(t, "3", r[1].FileId)
+}
+
+func TestPlanRecycleRestoreTarget(t *testing.T) {
+	fileList := RecycleFileInfoList{
+		{FileId: "1", FileName: "a.txt"},
+		{FileId: "2", FileName: "b.txt"},
+		{FileId: "3", FileName: "c.txt"},
+		{FileId: "4", FileName: "d.txt"},
+	}
+	// 目标文件夹中的同名文件，d.txt 是已经还原过的同一个文件
+	targetList := AppFileList{
+		{FileId: "9", FileName: "a.txt"},
+		{FileId: "8", FileName: "b.txt"},
+		{FileId: "4", FileName: "d.txt"},
+	}
+
+	_, apiErr := planRecycleRestoreTarget(targetList, fileList, nil)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, apierror.ApiCodeBatchTaskConflict, apiErr.Code)
+	assert.Contains(t, apiErr.Error(), "a.txt, b.txt")
+
+	plan, apiErr := planRecycleRestoreTarget(targetList, fileList, &BatchTaskConflictOption{DealWay: BatchTaskConflictSkip})
+	assert.Nil(t, apiErr)
+	assert.Equal(t, []string{"1", "2"}, plan.skippedFileIdList)
+	assert.Equal(t, 2, len(plan.restoreList))
+
+	plan, apiErr = planRecycleRestoreTarget(targetList, fileList, &BatchTaskConflictOption{
+		Resolver: func(item *BatchTaskConflictItem) BatchTaskConflictDealWay {
+			if item.FileName == "a.txt" {
+				return BatchTaskConflictOverwrite
+			}
+			return BatchTaskConflictKeepBoth
+		},
+	})
+	assert.Nil(t, apiErr)
+	assert.Equal(t, 0, len(plan.skippedFileIdList))
+	assert.Equal(t, 4, len(plan.restoreList))
+	assert.Equal(t, 1, len(plan.overwriteList))
+	assert.Equal(t, "9", plan.overwriteList[0].FileId)
+	assert.Equal(t, map[string]bool{"2": true}, plan.keepBoth)
+}
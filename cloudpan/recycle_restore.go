@@ -0,0 +1,307 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// RecycleRestoreParam 还原回收站文件到指定路径的参数，只支持个人云
+	RecycleRestoreParam struct {
+		FileList RecycleFileInfoList
+		// TargetPath 还原到的文件夹路径，为空时还原到删除前所在的文件夹，
+		// 文件夹不存在时根据 PathStr 重新创建
+		TargetPath string
+		// Conflict 同名文件的处理方式，为 nil 时遇到同名文件返回 ApiCodeBatchTaskConflict 错误。
+		// 指定 TargetPath 时按目标文件夹中的同名文件处理，覆盖的文件删除到回收站
+		Conflict *BatchTaskConflictOption
+	}
+
+	// RecycleRestoreResult 还原结果
+	RecycleRestoreResult struct {
+		// Summary 还原任务、移动任务以及覆盖同名文件时删除任务的结果
+		Summary *BatchTaskSummary
+		// MovedFileIdList 还原后移动到目标文件夹的文件
+		MovedFileIdList []string
+		// SkippedFileIdList 没有还原的文件，例如同名冲突时跳过的文件
+		SkippedFileIdList []string
+	}
+
+	// recycleRestoreGroup 还原到同一个文件夹的文件
+	recycleRestoreGroup struct {
+		targetPath string
+		fileList RecycleFileInfoList
+	}
+
+	// recycleRestoreTargetPlan 按目标文件夹中的同名文件处理后的还原计划
+	recycleRestoreTargetPlan struct {
+		// restoreList 需要还原的文件
+		restoreList RecycleFileInfoList
+		// skippedFileIdList 同名跳过的文件
+		skippedFileIdList []string
+		// overwriteList 目标文件夹中需要被覆盖的同名文件
+		overwriteList AppFileList
+		// keepBoth 目标文件夹中有同名文件、保留两者的文件ID
+		keepBoth map[string]bool
+	}
+)
+
+// OriginalParentPath 文件删除前所在文件夹的路径
+func (fe *RecycleFileInfo) OriginalParentPath() string {
+	p := path.Clean("/" + fe.PathStr)
+	if path.Base(p) == fe.FileName {
+		return path.Dir(p)
+	}
+	// 部分接口返回的 PathStr 是所在文件夹的路径
+	return p
+}
+
+// planRecycleRestore 按目标文件夹对文件分组，上级文件夹的分组在前，同一层级保持原来的顺序。
+// 这样同时还原的文件夹会先还原，里面的文件还原时文件夹已经存在，不会再重新创建
+func planRecycleRestore(fileList RecycleFileInfoList, targetPath string) []*recycleRestoreGroup {
+	groups := []*recycleRestoreGroup{}
+	index := map[string]*recycleRestoreGroup{}
+	for _, fe := range fileList {
+		dir := targetPath
+		if dir == "" {
+			dir = fe.OriginalParentPath()
+		}
+		dir = path.Clean("/" + dir)
+		g, ok := index[dir]
+		if !ok {
+			g = &recycleRestoreGroup{targetPath: dir}
+			index[dir] = g
+			groups = append(groups, g)
+		}
+		g.fileList = append(g.fileList, fe)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return pathDepth(groups[i].targetPath) < pathDepth(groups[j].targetPath)
+	})
+	return groups
+}
+
+func pathDepth(p string) int {
+	if p == "/" {
+		return 0
+	}
+	return strings.Count(p, "/")
+}
+
+// notInFolder 不在文件夹文件列表 list 中的文件
+func notInFolder(list AppFileList, fileList RecycleFileInfoList) RecycleFileInfoList {
+	ids := map[string]bool{}
+	for _, fe := range list {
+		ids[fe.FileId] = true
+	}
+	r := RecycleFileInfoList{}
+	for _, fe := range fileList {
+		if !ids[fe.FileId] {
+			r = append(r, fe)
+		}
+	}
+	return r
+}
+
+// planRecycleRestoreTarget 检查目标文件夹 targetList 中的同名文件，按 conflict 决定跳过、覆盖还是保留两者。
+// conflict 为 nil 时存在同名文件返回 ApiCodeBatchTaskConflict 错误
+func planRecycleRestoreTarget(targetList AppFileList, fileList RecycleFileInfoList, conflict *BatchTaskConflictOption) (*recycleRestoreTargetPlan, *apierror.ApiError) {
+	existed := map[string]*AppFileEntity{}
+	for _, fe := range targetList {
+		existed[fe.FileName] = fe
+	}
+	plan := &recycleRestoreTargetPlan{
+		restoreList: RecycleFileInfoList{},
+		skippedFileIdList: []string{},
+		overwriteList: AppFileList{},
+		keepBoth: map[string]bool{},
+	}
+	conflictNames := []string{}
+	for _, fe := range fileList {
+		old, ok := existed[fe.FileName]
+		if !ok || old.FileId == fe.FileId {
+			plan.restoreList = append(plan.restoreList, fe)
+			continue
+		}
+		if conflict == nil {
+			conflictNames = append(conflictNames, fe.FileName)
+			continue
+		}
+		dealWay := conflict.DealWay
+		if conflict.Resolver != nil {
+			item := &BatchTaskConflictItem{IsConflict: 1}
+			item.BatchTaskInfo = *makeBatchTaskInfoList(RecycleFileInfoList{fe})[0]
+			dealWay = conflict.Resolver(item)
+		}
+		switch dealWay {
+		case BatchTaskConflictOverwrite:
+			plan.overwriteList = append(plan.overwriteList, old)
+			plan.restoreList = append(plan.restoreList, fe)
+		case BatchTaskConflictKeepBoth:
+			plan.keepBoth[fe.FileId] = true
+			plan.restoreList = append(plan.restoreList, fe)
+		default:
+			plan.skippedFileIdList = append(plan.skippedFileIdList, fe.FileId)
+		}
+	}
+	if len(conflictNames) > 0 {
+		return nil, apierror.NewApiError(apierror.ApiCodeBatchTaskConflict, "目标文件夹存在同名文件: "+strings.Join(conflictNames, ", "))
+	}
+	return plan, nil
+}
+
+// RecycleRestoreToPath 还原回收站文件，原来的文件夹已删除时会按 PathStr 重新创建，
+// 也可以通过 TargetPath 还原到其他文件夹。服务端总是还原到原来的文件夹(即使已经被删除)，
+// 还原后不在目标文件夹的文件会再移动到目标文件夹，没有还原的文件记录在 SkippedFileIdList 中。
+// 指定 TargetPath 时还原前先按目标文件夹中的同名文件处理 Conflict，原来的文件夹中的同名文件不影响还原：
+// 还原时保留两者，移动到目标文件夹后再改回原来的名称
+func (p *PanClient) RecycleRestoreToPath(ctx context.Context, param *RecycleRestoreParam) (*RecycleRestoreResult, *apierror.ApiError) {
+	result := &RecycleRestoreResult{
+		Summary: &BatchTaskSummary{},
+		MovedFileIdList: []string{},
+		SkippedFileIdList: []string{},
+	}
+	for _, g := range planRecycleRestore(param.FileList, param.TargetPath) {
+		target, apiErr := p.AppMkdirAll(0, g.targetPath)
+		if apiErr != nil {
+			return result, apiErr
+		}
+
+		restoreList := g.fileList
+		restoreConflict := param.Conflict
+		targetPlan := &recycleRestoreTargetPlan{keepBoth: map[string]bool{}}
+		if param.TargetPath != "" {
+			targetList, apiErr := p.appListFolder(0, &AppFileEntity{FileId: target.FileId})
+			if apiErr != nil {
+				return result, apiErr
+			}
+			targetPlan, apiErr = planRecycleRestoreTarget(targetList, g.fileList, param.Conflict)
+			if apiErr != nil {
+				return result, apiErr
+			}
+			result.SkippedFileIdList = append(result.SkippedFileIdList, targetPlan.skippedFileIdList...)
+			restoreList = targetPlan.restoreList
+			restoreConflict = &BatchTaskConflictOption{DealWay: BatchTaskConflictKeepBoth}
+			if param.Conflict != nil {
+				restoreConflict.WaitOption = param.Conflict.WaitOption
+			}
+		}
+		if len(restoreList) == 0 {
+			continue
+		}
+
+		for _, chunk := range splitBatchTaskInfoList(makeBatchTaskInfoList(restoreList), BatchTaskMaxFileCount) {
+			r, apiErr := p.ExecuteBatchTask(ctx, &BatchTaskParam{
+				TypeFlag: BatchTaskTypeRecycleRestore,
+				TaskInfos: chunk,
+			}, restoreConflict)
+			if r != nil {
+				result.Summary.add(r.TaskId, r)
+			}
+			if apiErr != nil {
+				return result, apiErr
+			}
+		}
+
+		// 找出没有还原到目标文件夹的文件，只有不在目标文件夹中的文件才单独查询
+		targetList, apiErr := p.appListFolder(0, &AppFileEntity{FileId: target.FileId})
+		if apiErr != nil {
+			return result, apiErr
+		}
+		restoredList := append(AppFileList{}, targetList...)
+		moveList := AppFileList{}
+		for _, fe := range notInFolder(targetList, restoreList) {
+			restored, apiErr := p.AppFileInfoById(0, fe.FileId)
+			if apiErr != nil && apiErr.Code != apierror.ApiCodeFileNotFoundCode {
+				return result, apiErr
+			}
+			if restored == nil {
+				// 冲突时跳过的文件不会还原
+				result.SkippedFileIdList = append(result.SkippedFileIdList, fe.FileId)
+				continue
+			}
+			moveList = append(moveList, restored)
+			restoredList = append(restoredList, restored)
+		}
+
+		// 覆盖目标文件夹中的同名文件，文件还原成功后才删除
+		for _, chunk := range splitBatchTaskInfoList(newBatchTaskInfoList(targetPlan.overwriteList), BatchTaskMaxFileCount) {
+			r, apiErr := p.ExecuteBatchTask(ctx, &BatchTaskParam{
+				TypeFlag: BatchTaskTypeDelete,
+				TaskInfos: chunk,
+			}, nil)
+			if r != nil {
+				result.Summary.add(r.TaskId, r)
+			}
+			if apiErr != nil {
+				return result, apiErr
+			}
+		}
+
+		for _, chunk := range splitBatchTaskInfoList(newBatchTaskInfoList(moveList), BatchTaskMaxFileCount) {
+			r, apiErr := p.ExecuteBatchTask(ctx, &BatchTaskParam{
+				TypeFlag: BatchTaskTypeMove,
+				TaskInfos: chunk,
+				TargetFolderId: target.FileId,
+			}, param.Conflict)
+			if r != nil {
+				result.Summary.add(r.TaskId, r)
+			}
+			if apiErr != nil {
+				return result, apiErr
+			}
+			for _, info := range chunk {
+				result.MovedFileIdList = append(result.MovedFileIdList, info.FileId)
+			}
+		}
+
+		if param.TargetPath != "" {
+			if apiErr := p.renameRestoredFiles(restoredList, restoreList, targetPlan.keepBoth); apiErr != nil {
+				return result, apiErr
+			}
+		}
+	}
+	return result, nil
+}
+
+// renameRestoredFiles 还原时因为原来的文件夹中有同名文件而被自动改名的文件改回原来的名称，
+// 目标文件夹中有同名文件、保留两者的文件不改名
+func (p *PanClient) renameRestoredFiles(restoredList AppFileList, fileList RecycleFileInfoList, keepBoth map[string]bool) *apierror.ApiError {
+	names := map[string]string{}
+	for _, fe := range fileList {
+		names[fe.FileId] = fe.FileName
+	}
+	for _, fe := range restoredList {
+		name, ok := names[fe.FileId]
+		if !ok || keepBoth[fe.FileId] || fe.FileName == name {
+			continue
+		}
+		var apiErr *apierror.ApiError
+		if fe.IsFolder {
+			_, apiErr = p.AppRenameFolder(fe.FileId, name)
+		} else {
+			_, apiErr = p.AppRenameFile(fe.FileId, name)
+		}
+		if apiErr != nil {
+			return apiErr
+		}
+	}
+	return nil
+}
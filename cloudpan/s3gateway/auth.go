@@ -0,0 +1,240 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// sigV4 从请求中解析出的 AWS Signature Version 4 签名信息
+	sigV4 struct {
+		accessKeyId   string
+		date          string
+		region        string
+		service       string
+		signedHeaders []string
+		signature     string
+		amzDate       time.Time
+		// expires 预签名URL的有效期，0表示签名在请求头中
+		expires time.Duration
+	}
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateLayout  = "20060102T150405Z"
+
+	// maxRequestSkew 请求头签名的时间和网关时间的最大误差
+	maxRequestSkew = 15 * time.Minute
+	// maxPresignExpires 预签名URL的最长有效期，和 S3 一致为7天
+	maxPresignExpires = 7 * 24 * time.Hour
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var (
+	errSignatureDoesNotMatch = &s3Error{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden}
+	errRequestTimeTooSkewed  = &s3Error{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large", http.StatusForbidden}
+	errExpiredRequest        = &s3Error{"AccessDenied", "Request has expired", http.StatusForbidden}
+	errInvalidAccessKeyId    = &s3Error{"InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records", http.StatusForbidden}
+	errSignatureVersion      = &s3Error{"AccessDenied", "Only AWS Signature Version 4 is supported", http.StatusForbidden}
+)
+
+// checkAuth 校验请求的 AWS Signature Version 4 签名，支持签名在 Authorization 请求头中和预签名URL两种方式。
+// 没有配置 AccessKeyId 时不校验；配置了 AccessKeyId 但没有配置 SecretAccessKey 时拒绝所有请求
+func (g *Gateway) checkAuth(r *http.Request) error {
+	if g.AccessKeyId == "" {
+		return nil
+	}
+	if g.SecretAccessKey == "" {
+		return errAccessDenied
+	}
+	sig, err := parseSigV4(r)
+	if err != nil {
+		return err
+	}
+	if sig.accessKeyId != g.AccessKeyId {
+		return errInvalidAccessKeyId
+	}
+	now := g.now()
+	if sig.expires > 0 {
+		if now.Before(sig.amzDate.Add(-maxRequestSkew)) || now.After(sig.amzDate.Add(sig.expires)) {
+			return errExpiredRequest
+		}
+	} else if now.Sub(sig.amzDate) > maxRequestSkew || sig.amzDate.Sub(now) > maxRequestSkew {
+		return errRequestTimeTooSkewed
+	}
+
+	expected := sig.sign(g.SecretAccessKey, canonicalRequest(r, sig))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(sig.signature)) != 1 {
+		return errSignatureDoesNotMatch
+	}
+	return nil
+}
+
+// parseSigV4 解析 Authorization 请求头或者预签名URL中的签名信息
+func parseSigV4(r *http.Request) (*sigV4, error) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, amzDate string
+	sig := &sigV4{}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+			return nil, errSignatureVersion
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				return nil, errAccessDenied
+			}
+			switch kv[0] {
+			case "Credential":
+				credential = kv[1]
+			case "SignedHeaders":
+				signedHeaders = kv[1]
+			case "Signature":
+				signature = kv[1]
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+	} else if query.Get("X-Amz-Algorithm") != "" {
+		if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+			return nil, errSignatureVersion
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		seconds, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxPresignExpires {
+			return nil, errAccessDenied
+		}
+		sig.expires = time.Duration(seconds) * time.Second
+	} else if query.Get("AWSAccessKeyId") != "" {
+		return nil, errSignatureVersion
+	} else {
+		return nil, errAccessDenied
+	}
+
+	// Credential=AKID/20130524/us-east-1/s3/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[3] != "s3" || scope[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return nil, errAccessDenied
+	}
+	t, err := time.Parse(amzDateLayout, amzDate)
+	if err != nil || t.Format("20060102") != scope[1] {
+		return nil, errAccessDenied
+	}
+	sig.accessKeyId = scope[0]
+	sig.date = scope[1]
+	sig.region = scope[2]
+	sig.service = scope[3]
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+	sig.signature = strings.ToLower(signature)
+	sig.amzDate = t
+	return sig, nil
+}
+
+// canonicalRequest 按 Signature Version 4 的规则生成规范请求
+func canonicalRequest(r *http.Request, sig *sigV4) string {
+	builder := &strings.Builder{}
+	builder.WriteString(r.Method)
+	builder.WriteString("\n")
+	builder.WriteString(uriEncode(r.URL.Path, false))
+	builder.WriteString("\n")
+	builder.WriteString(canonicalQuery(r.URL.Query()))
+	builder.WriteString("\n")
+	for _, name := range sig.signedHeaders {
+		var value string
+		if name == "host" {
+			value = r.Host
+		} else {
+			value = strings.Join(r.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		builder.WriteString(name)
+		builder.WriteString(":")
+		builder.WriteString(strings.Join(strings.Fields(value), " "))
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\n")
+	builder.WriteString(strings.Join(sig.signedHeaders, ";"))
+	builder.WriteString("\n")
+	if sig.expires > 0 {
+		builder.WriteString(unsignedPayload)
+	} else if payload := r.Header.Get("X-Amz-Content-Sha256"); payload != "" {
+		builder.WriteString(payload)
+	} else {
+		builder.WriteString(unsignedPayload)
+	}
+	return builder.String()
+}
+
+// canonicalQuery 按名称和值排序的查询参数，预签名URL的签名参数本身不参与签名
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for k, values := range query {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode 按 RFC 3986 编码，只保留非保留字符，encodeSlash 为 false 时保留路径中的 /
+func uriEncode(s string, encodeSlash bool) string {
+	builder := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign 计算规范请求的签名
+func (sig *sigV4) sign(secretAccessKey, canonical string) string {
+	scope := strings.Join([]string{sig.date, sig.region, sig.service, "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{sigV4Algorithm, sig.amzDate.Format(amzDateLayout), scope, hex.EncodeToString(hash[:])}, "\n")
+	key := hmacSha256([]byte("AWS4"+secretAccessKey), sig.date)
+	key = hmacSha256(key, sig.region)
+	key = hmacSha256(key, sig.service)
+	key = hmacSha256(key, "aws4_request")
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
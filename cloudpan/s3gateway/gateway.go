@@ -0,0 +1,284 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3gateway 基于云盘实现的 S3 兼容网关，只支持 path-style 的请求地址，
+// 个人云的每个一级文件夹是一个 bucket，家庭云以 family-<familyId> 作为 bucket 名称
+package s3gateway
+
+import (
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"github.com/phpc0de/ctlibgo/logger"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Gateway S3 兼容网关的 http.Handler
+	Gateway struct {
+		b panbackend.Backend
+
+		// AccessKeyId 和 SecretAccessKey 不为空时，请求必须使用它们进行 AWS Signature Version 4 签名，
+		// 只配置 AccessKeyId 会拒绝所有请求。都为空时不做任何认证，只能在可信的网络环境中使用。
+		// 请求体的 SHA256 以及 aws-chunked 编码中每个数据块的签名不校验，需要防篡改时请使用 HTTPS
+		AccessKeyId     string
+		SecretAccessKey string
+		// TempDir 暂存上传数据的本地目录，为空使用系统临时目录
+		TempDir string
+		// UploadExpiry 分片上传会话的过期时间，超过该时间没有上传分片的会话会被删除，默认为 DefaultUploadExpiry
+		UploadExpiry time.Duration
+
+		uploads     map[string]*multipartUpload
+		uploadsLock sync.Mutex
+		now         func() time.Time
+	}
+
+	// bucket bucket 对应的云盘位置
+	bucket struct {
+		name     string
+		familyId int64
+		root     string
+	}
+)
+
+const (
+	// FamilyBucketPrefix 家庭云 bucket 名称前缀
+	FamilyBucketPrefix = "family-"
+	// DefaultUploadExpiry 默认的分片上传会话过期时间
+	DefaultUploadExpiry = 24 * time.Hour
+
+	s3TimeLayout = "2006-01-02T15:04:05.000Z"
+)
+
+// NewGateway 创建 S3 兼容网关
+func NewGateway(client *cloudpan.PanClient) *Gateway {
+	return newGateway(panbackend.NewClient(client))
+}
+
+func newGateway(b panbackend.Backend) *Gateway {
+	return &Gateway{
+		b: b,
+		UploadExpiry: DefaultUploadExpiry,
+		uploads: map[string]*multipartUpload{},
+		now: time.Now,
+	}
+}
+
+// list 通过绝对路径获取文件夹下的所有文件
+func (g *Gateway) list(familyId int64, pathStr string) (cloudpan.AppFileList, error) {
+	fe, err := g.b.Stat(familyId, pathStr)
+	if err != nil {
+		return nil, err
+	}
+	return g.b.List(familyId, fe.FileId)
+}
+
+// put 上传文件，同名文件会被覆盖，父文件夹不存在时自动创建
+func (g *Gateway) put(familyId int64, pathStr string, size int64, md5 string, reader io.Reader) (*cloudpan.AppFileEntity, error) {
+	parentId, err := g.b.MkdirAll(familyId, path.Dir(pathStr))
+	if err != nil {
+		return nil, err
+	}
+	var old *cloudpan.AppFileEntity
+	if familyId > 0 {
+		// 家庭云不支持覆盖上传，上传成功后再替换旧文件
+		old, _ = g.b.Stat(familyId, pathStr)
+	}
+	fe, err := g.b.Upload(familyId, parentId, path.Base(pathStr), size, md5, reader, old)
+	if err != nil {
+		return nil, err
+	}
+	fe.Path = pathStr
+	return fe, nil
+}
+
+// resolveBucket 获取 bucket 对应的云盘位置
+func (g *Gateway) resolveBucket(name string) (*bucket, error) {
+	if strings.HasPrefix(name, FamilyBucketPrefix) {
+		familyId, err := strconv.ParseInt(strings.TrimPrefix(name, FamilyBucketPrefix), 10, 64)
+		if err == nil && familyId > 0 {
+			return &bucket{name: name, familyId: familyId, root: "/"}, nil
+		}
+	}
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return nil, errNoSuchBucket
+	}
+	fe, err := g.b.Stat(0, "/"+name)
+	if err != nil {
+		if panbackend.IsNotFound(err) {
+			return nil, errNoSuchBucket
+		}
+		return nil, err
+	}
+	if !fe.IsFolder {
+		return nil, errNoSuchBucket
+	}
+	return &bucket{name: name, root: "/" + name}, nil
+}
+
+// validKey 检查对象 key 或者前缀，包含 . 或 .. 路径段时路径会跳出 bucket 文件夹。
+// allowRoot 为 false 时 key 必须包含非空的路径段，否则对象路径就是 bucket 文件夹本身
+func validKey(key string, allowRoot bool) bool {
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return allowRoot || strings.Trim(key, "/") != ""
+}
+
+// objectPath 获取对象在云盘中的绝对路径，key 需要先经过 validKey 检查
+func (b *bucket) objectPath(key string) string {
+	return path.Join(b.root, key)
+}
+
+// ServeHTTP 实现 http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Verboseln("s3 gateway request: ", r.Method, " ", r.URL.String())
+	if err := g.checkAuth(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	bucketName, key := splitPath(r.URL.Path)
+	if bucketName == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		g.listBuckets(w, r)
+		return
+	}
+
+	if key == "" {
+		if r.Method == http.MethodPut {
+			g.createBucket(w, r, bucketName)
+			return
+		}
+		b, err := g.resolveBucket(bucketName)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			if r.URL.Query().Get("list-type") != "2" {
+				writeError(w, r, errNotImplemented)
+				return
+			}
+			g.listObjectsV2(w, r, b)
+		default:
+			writeError(w, r, errMethodNotAllowed)
+		}
+		return
+	}
+
+	if !validKey(key, false) {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	b, err := g.resolveBucket(bucketName)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		g.getObject(w, r, b, key)
+	case http.MethodPut:
+		if query.Get("uploadId") != "" {
+			g.uploadPart(w, r, b, key)
+		} else if r.Header.Get("X-Amz-Copy-Source") != "" {
+			g.copyObject(w, r, b, key)
+		} else {
+			g.putObject(w, r, b, key)
+		}
+	case http.MethodPost:
+		if _, ok := query["uploads"]; ok {
+			g.createMultipartUpload(w, r, b, key)
+		} else if query.Get("uploadId") != "" {
+			g.completeMultipartUpload(w, r, b, key)
+		} else {
+			writeError(w, r, errNotImplemented)
+		}
+	case http.MethodDelete:
+		if query.Get("uploadId") != "" {
+			g.abortMultipartUpload(w, r, b, key)
+		} else {
+			g.deleteObject(w, r, b, key)
+		}
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+// splitPath 将请求路径拆分为 bucket 和对象 key
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(p, "/")
+	idx := strings.Index(p, "/")
+	if idx < 0 {
+		return p, ""
+	}
+	return p[:idx], p[idx+1:]
+}
+
+// listBuckets 列出个人云的一级文件夹以及所有家庭云
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	result := &listAllMyBucketsResult{}
+	list, err := g.list(0, "/")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, fe := range list {
+		if fe.IsFolder {
+			result.Buckets = append(result.Buckets, bucketInfo{Name: fe.FileName, CreationDate: formatS3Time(fe.CreateTime)})
+		}
+	}
+	if families, err := g.b.Families(); err != nil {
+		logger.Verboseln("s3 gateway list families error: ", err)
+	} else {
+		for _, f := range families {
+			result.Buckets = append(result.Buckets, bucketInfo{Name: fmt.Sprintf("%s%d", FamilyBucketPrefix, f.FamilyId), CreationDate: formatS3Time(f.CreateTime)})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// createBucket 在个人云根目录创建一级文件夹，家庭云 bucket 不能创建
+func (g *Gateway) createBucket(w http.ResponseWriter, r *http.Request, name string) {
+	if strings.HasPrefix(name, FamilyBucketPrefix) || strings.ContainsAny(name, "/\\") {
+		writeError(w, r, errInvalidBucketName)
+		return
+	}
+	if _, err := g.b.Stat(0, "/"+name); err == nil {
+		writeError(w, r, errBucketAlreadyOwnedByYou)
+		return
+	}
+	if _, err := g.b.MkdirAll(0, "/"+name); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+}
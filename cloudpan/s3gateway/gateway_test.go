@@ -0,0 +1,162 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func doRequest(g *Gateway, method, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	return w
+}
+
+func TestGateway(t *testing.T) {
+	m := panbackend.NewMemory()
+	m.AddFamily(8)
+	m.MkdirAll(0, "/bucket")
+	g := newGateway(m)
+	g.TempDir = t.TempDir()
+
+	// PUT 以及 aws-chunked 编码的 PUT
+	w := doRequest(g, http.MethodPut, "/bucket/docs/a.txt", "hello world", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, w.Header().Get("ETag"))
+	w = doRequest(g, http.MethodPut, "/bucket/docs/sub/b.txt", "5;chunk-signature=x\r\nhello\r\n0;chunk-signature=y\r\n\r\n", map[string]string{
+		"X-Amz-Content-Sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+		"X-Amz-Decoded-Content-Length": "5",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(g, http.MethodPut, "/family-8/c.txt", "family", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// GET Range 以及 HEAD
+	w = doRequest(g, http.MethodGet, "/bucket/docs/a.txt", "", map[string]string{"Range": "bytes=6-"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "world", w.Body.String())
+	assert.Equal(t, "bytes 6-10/11", w.Header().Get("Content-Range"))
+	w = doRequest(g, http.MethodHead, "/bucket/docs/sub/b.txt", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Content-Length"))
+	w = doRequest(g, http.MethodGet, "/bucket/missing.txt", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "NoSuchKey")
+
+	// ListObjectsV2
+	w = doRequest(g, http.MethodGet, "/bucket?list-type=2&prefix=docs/&delimiter=/", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<Key>docs/a.txt</Key>")
+	assert.Contains(t, w.Body.String(), "<CommonPrefixes><Prefix>docs/sub/</Prefix></CommonPrefixes>")
+	w = doRequest(g, http.MethodGet, "/bucket?list-type=2&max-keys=1", "", nil)
+	assert.Contains(t, w.Body.String(), "<Key>docs/a.txt</Key>")
+	assert.Contains(t, w.Body.String(), "<IsTruncated>true</IsTruncated>")
+	w = doRequest(g, http.MethodGet, "/bucket?list-type=2&start-after=docs/a.txt", "", nil)
+	assert.Contains(t, w.Body.String(), "<Key>docs/sub/b.txt</Key>")
+	assert.NotContains(t, w.Body.String(), "<Key>docs/a.txt</Key>")
+
+	// CopyObject
+	w = doRequest(g, http.MethodPut, "/bucket/copy.txt", "", map[string]string{"X-Amz-Copy-Source": "/family-8/c.txt"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(g, http.MethodGet, "/bucket/copy.txt", "", nil)
+	assert.Equal(t, "family", w.Body.String())
+
+	// 分片上传
+	w = doRequest(g, http.MethodPost, "/bucket/big.bin?uploads", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	uploadId := body[strings.Index(body, "<UploadId>")+10 : strings.Index(body, "</UploadId>")]
+	w = doRequest(g, http.MethodPut, "/bucket/big.bin?partNumber=2&uploadId="+uploadId, "-part2", nil)
+	etag2 := w.Header().Get("ETag")
+	w = doRequest(g, http.MethodPut, "/bucket/big.bin?partNumber=1&uploadId="+uploadId, "part1", nil)
+	etag1 := w.Header().Get("ETag")
+	complete := fmt.Sprintf("<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part><Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>", etag1, etag2)
+	w = doRequest(g, http.MethodPost, "/bucket/big.bin?uploadId="+uploadId, complete, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(g, http.MethodGet, "/bucket/big.bin", "", nil)
+	assert.Equal(t, "part1-part2", w.Body.String())
+	w = doRequest(g, http.MethodPost, "/bucket/big.bin?uploadId="+uploadId, complete, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// DeleteObject
+	w = doRequest(g, http.MethodDelete, "/bucket/docs/a.txt", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(g, http.MethodHead, "/bucket/docs/a.txt", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// ListBuckets
+	w = doRequest(g, http.MethodGet, "/", "", nil)
+	assert.Contains(t, w.Body.String(), "<Name>bucket</Name>")
+	assert.Contains(t, w.Body.String(), "<Name>family-8</Name>")
+}
+
+func TestGatewayKeyOutsideBucket(t *testing.T) {
+	m := panbackend.NewMemory()
+	g := newGateway(m)
+	g.TempDir = t.TempDir()
+	m.Add(0, "/a/x.txt", []byte("a"))
+	m.Add(0, "/b/x.txt", []byte("b"))
+	m.Add(0, "/b/sub/y.txt", []byte("y"))
+
+	cases := []struct {
+		method  string
+		target  string
+		headers map[string]string
+	}{
+		{http.MethodGet, "/a/../b/x.txt", nil},
+		{http.MethodHead, "/a/./x.txt", nil},
+		{http.MethodPut, "/a/../b/new.txt", nil},
+		{http.MethodPut, "/a/copy.txt", map[string]string{"X-Amz-Copy-Source": "/a/../b/x.txt"}},
+		{http.MethodPost, "/a/../b/big.bin?uploads", nil},
+		{http.MethodDelete, "/b/sub/..", nil},
+		{http.MethodDelete, "/b/sub/../", nil},
+		{http.MethodDelete, "/b/../", nil},
+		{http.MethodDelete, "/b//", nil},
+		{http.MethodGet, "/a?list-type=2&prefix=../b/", nil},
+	}
+	for _, c := range cases {
+		w := doRequest(g, c.method, c.target, "", c.headers)
+		assert.Equal(t, http.StatusBadRequest, w.Code, c.method+" "+c.target)
+		if c.method != http.MethodHead {
+			assert.Contains(t, w.Body.String(), "InvalidArgument", c.method+" "+c.target)
+		}
+	}
+
+	// bucket 以及其他 bucket 的文件都没有被修改
+	for _, p := range []string{"/", "/a/x.txt", "/b", "/b/x.txt", "/b/sub", "/b/sub/y.txt"} {
+		_, err := m.Stat(0, p)
+		assert.Nil(t, err, p)
+	}
+	_, err := m.Stat(0, "/b/new.txt")
+	assert.NotNil(t, err)
+	_, err = m.Stat(0, "/a/copy.txt")
+	assert.NotNil(t, err)
+
+	// 名称中包含 . 的对象不受影响
+	w := doRequest(g, http.MethodPut, "/a/..x/.y", "z", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(g, http.MethodGet, "/a?list-type=2&prefix=..x/.", "", nil)
+	assert.Contains(t, w.Body.String(), "<Key>..x/.y</Key>")
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// multipartUpload 分片上传会话。
+	// 云盘的上传会话在创建时就需要整个文件的MD5，所以分片先暂存到本地，完成时再一次性上传
+	multipartUpload struct {
+		bucket string
+		key    string
+		dir    string
+		parts  map[int]*uploadPart
+		lock   sync.Mutex
+		// lastActive 创建或者最后一次上传分片的时间，用于清理过期的会话
+		lastActive time.Time
+	}
+
+	uploadPart struct {
+		path string
+		size int64
+		md5  string
+	}
+)
+
+const (
+	maxPartNumber = 10000
+)
+
+func newUploadId() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// getUpload 获取分片上传会话，bucket 和 key 必须和创建时一致
+func (g *Gateway) getUpload(r *http.Request, b *bucket, key string) (string, *multipartUpload, error) {
+	g.removeExpiredUploads()
+	uploadId := r.URL.Query().Get("uploadId")
+	g.uploadsLock.Lock()
+	defer g.uploadsLock.Unlock()
+	upload, ok := g.uploads[uploadId]
+	if !ok || upload.bucket != b.name || upload.key != key {
+		return "", nil, errNoSuchUpload
+	}
+	upload.lastActive = g.now()
+	return uploadId, upload, nil
+}
+
+// removeExpiredUploads 删除超过 UploadExpiry 没有活动的分片上传会话以及暂存的分片，
+// 客户端没有完成也没有取消的上传不会一直占用磁盘
+func (g *Gateway) removeExpiredUploads() {
+	if g.UploadExpiry <= 0 {
+		return
+	}
+	deadline := g.now().Add(-g.UploadExpiry)
+	expired := []*multipartUpload{}
+	g.uploadsLock.Lock()
+	for uploadId, upload := range g.uploads {
+		if upload.lastActive.Before(deadline) {
+			delete(g.uploads, uploadId)
+			expired = append(expired, upload)
+		}
+	}
+	g.uploadsLock.Unlock()
+	for _, upload := range expired {
+		// 等待正在进行的完成操作结束
+		upload.lock.Lock()
+		os.RemoveAll(upload.dir)
+		upload.lock.Unlock()
+	}
+}
+
+// createMultipartUpload 创建分片上传会话
+func (g *Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	g.removeExpiredUploads()
+	dir, err := ioutil.TempDir(g.TempDir, "ctapi-s3-multipart-")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	uploadId := newUploadId()
+	g.uploadsLock.Lock()
+	g.uploads[uploadId] = &multipartUpload{
+		bucket: b.name,
+		key: key,
+		dir: dir,
+		parts: map[int]*uploadPart{},
+		lastActive: g.now(),
+	}
+	g.uploadsLock.Unlock()
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{
+		Bucket: b.name,
+		Key: key,
+		UploadId: uploadId,
+	})
+}
+
+// uploadPart 上传分片，相同序号的分片会被覆盖
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	_, upload, err := g.getUpload(r, b, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, declaredSize := requestBody(r)
+	partPath := filepath.Join(upload.dir, strconv.Itoa(partNumber))
+	f, err := ioutil.TempFile(upload.dir, "part-")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(f, h), body)
+	f.Close()
+	if err == nil && declaredSize >= 0 && size != declaredSize {
+		err = errIncompleteBody
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), partPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		writeError(w, r, err)
+		return
+	}
+
+	part := &uploadPart{path: partPath, size: size, md5: hex.EncodeToString(h.Sum(nil))}
+	upload.lock.Lock()
+	upload.parts[partNumber] = part
+	upload.lock.Unlock()
+	w.Header().Set("ETag", etag(part.md5))
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload 按请求中的分片顺序合并分片并上传到云盘
+func (g *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	uploadId, upload, err := g.getUpload(r, b, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req := &completeMultipartUpload{}
+	if err := xml.NewDecoder(r.Body).Decode(req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	upload.lock.Lock()
+	defer upload.lock.Unlock()
+	parts := make([]*uploadPart, 0, len(req.Parts))
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+		part, ok := upload.parts[p.PartNumber]
+		if !ok || strings.ToLower(strings.Trim(p.ETag, `"`)) != part.md5 {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		parts = append(parts, part)
+	}
+
+	// 计算合并后文件的MD5
+	h := md5.New()
+	size := int64(0)
+	for _, part := range parts {
+		f, err := os.Open(part.path)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		size += n
+	}
+	md5Str := hex.EncodeToString(h.Sum(nil))
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		f, err := os.Open(part.path)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if _, err := g.put(b.familyId, b.objectPath(key), size, md5Str, io.MultiReader(readers...)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	g.removeUpload(uploadId, upload)
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Location: "/" + b.name + "/" + key,
+		Bucket: b.name,
+		Key: key,
+		ETag: etag(md5Str),
+	})
+}
+
+// abortMultipartUpload 取消分片上传，删除暂存的分片
+func (g *Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	uploadId, upload, err := g.getUpload(r, b, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	g.removeUpload(uploadId, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) removeUpload(uploadId string, upload *multipartUpload) {
+	g.uploadsLock.Lock()
+	delete(g.uploads, uploadId)
+	g.uploadsLock.Unlock()
+	os.RemoveAll(upload.dir)
+}
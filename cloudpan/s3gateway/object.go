@@ -0,0 +1,483 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// listEntry 列表中的一项，文件夹的 key 以 / 结尾
+	listEntry struct {
+		key    string
+		entity *cloudpan.AppFileEntity
+	}
+
+	// chunkedReader 解析 aws-chunked 编码的请求体
+	chunkedReader struct {
+		r       *bufio.Reader
+		remain  int64
+		started bool
+		done    bool
+	}
+
+	// lazyReader 第一次读取时才打开数据流，秒传成功时不会下载源文件
+	lazyReader struct {
+		open func() (io.ReadCloser, error)
+		rc   io.ReadCloser
+	}
+)
+
+const (
+	defaultMaxKeys = 1000
+)
+
+// listObjectsV2 列出对象，delimiter 为 / 时只列出前缀所在的一级文件夹，否则递归遍历
+func (g *Gateway) listObjectsV2(w http.ResponseWriter, r *http.Request, b *bucket) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	encodingType := query.Get("encoding-type")
+	maxKeys := defaultMaxKeys
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	marker := query.Get("start-after")
+	token := query.Get("continuation-token")
+	if token != "" {
+		data, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		marker = string(data)
+	}
+
+	// 前缀的最后一段只用于匹配名称，只检查所在的文件夹
+	baseDir := prefix[:strings.LastIndex(prefix, "/")+1]
+	if !validKey(baseDir, true) {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	entries := []*listEntry{}
+	err := g.walk(b, baseDir, prefix, delimiter != "/", &entries)
+	if err != nil && !panbackend.IsNotFound(err) {
+		writeError(w, r, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	encode := func(s string) string {
+		if encodingType == "url" {
+			return strings.ReplaceAll(url.QueryEscape(s), "%2F", "/")
+		}
+		return s
+	}
+	result := &listBucketV2Result{
+		Name: b.name,
+		Prefix: encode(prefix),
+		Delimiter: encode(delimiter),
+		StartAfter: encode(query.Get("start-after")),
+		ContinuationToken: token,
+		EncodingType: encodingType,
+		MaxKeys: maxKeys,
+	}
+	lastKey := ""
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.key, prefix) {
+			continue
+		}
+		key := entry.key
+		isPrefix := false
+		if delimiter != "" {
+			if idx := strings.Index(key[len(prefix):], delimiter); idx >= 0 {
+				key = key[:len(prefix)+idx+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if key <= marker || key == lastKey {
+			continue
+		}
+		if result.KeyCount >= maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(lastKey))
+			break
+		}
+		lastKey = key
+		result.KeyCount++
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(key)})
+			continue
+		}
+		result.Contents = append(result.Contents, object{
+			Key: encode(key),
+			LastModified: formatS3Time(entry.entity.LastOpTime),
+			ETag: etag(entry.entity.FileMd5),
+			Size: entry.entity.FileSize,
+			StorageClass: "STANDARD",
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// walk 遍历 dir 下可能匹配 prefix 的文件，recursive=false 时文件夹作为以 / 结尾的 key 返回
+func (g *Gateway) walk(b *bucket, dir, prefix string, recursive bool, entries *[]*listEntry) error {
+	list, err := g.list(b.familyId, b.objectPath(dir))
+	if err != nil {
+		return err
+	}
+	for _, fe := range list {
+		key := dir + fe.FileName
+		if !fe.IsFolder {
+			*entries = append(*entries, &listEntry{key: key, entity: fe})
+			continue
+		}
+		key += "/"
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+			continue
+		}
+		if !recursive {
+			*entries = append(*entries, &listEntry{key: key, entity: fe})
+			continue
+		}
+		if err := g.walk(b, key, prefix, recursive, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getObject 下载对象，支持 HEAD 以及单个 Range 区间
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	fe, err := g.b.Stat(b.familyId, b.objectPath(key))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if fe.IsFolder {
+		writeError(w, r, errNoSuchKey)
+		return
+	}
+
+	size := fe.FileSize
+	start, end := int64(0), size-1
+	statusCode := http.StatusOK
+	if rangeStr := r.Header.Get("Range"); rangeStr != "" && size > 0 {
+		start, end, err = parseRange(rangeStr, size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(w, r, err)
+			return
+		}
+		statusCode = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("ETag", etag(fe.FileMd5))
+	w.Header().Set("Last-Modified", lastModified(fe.LastOpTime))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Method == http.MethodHead || size == 0 {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	rc, err := g.b.Open(b.familyId, fe.FileId, start, end)
+	if err != nil {
+		w.Header().Del("Content-Range")
+		writeError(w, r, err)
+		return
+	}
+	defer rc.Close()
+	w.WriteHeader(statusCode)
+	io.Copy(w, rc)
+}
+
+// parseRange 解析 Range 请求头，只支持单个区间，返回包含的起止位置
+func parseRange(rangeStr string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(rangeStr, "bytes=") || strings.Contains(rangeStr, ",") {
+		return 0, 0, errInvalidRange
+	}
+	spec := strings.SplitN(strings.TrimPrefix(rangeStr, "bytes="), "-", 2)
+	if len(spec) != 2 {
+		return 0, 0, errInvalidRange
+	}
+	var start, end int64
+	var err error
+	if spec[0] == "" {
+		// bytes=-n 表示最后n个字节
+		n, err := strconv.ParseInt(spec[1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+	if start, err = strconv.ParseInt(spec[0], 10, 64); err != nil || start < 0 || start >= size {
+		return 0, 0, errInvalidRange
+	}
+	end = size - 1
+	if spec[1] != "" {
+		if end, err = strconv.ParseInt(spec[1], 10, 64); err != nil || end < start {
+			return 0, 0, errInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, nil
+}
+
+// putObject 上传对象，数据先暂存到本地临时文件计算MD5，以 / 结尾的空对象创建为文件夹
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	body, declaredSize := requestBody(r)
+	tmp, size, md5Str, err := g.spool(body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer removeTempFile(tmp)
+	if declaredSize >= 0 && size != declaredSize {
+		writeError(w, r, errIncompleteBody)
+		return
+	}
+	if contentMd5 := r.Header.Get("Content-Md5"); contentMd5 != "" {
+		data, err := base64.StdEncoding.DecodeString(contentMd5)
+		if err != nil || hex.EncodeToString(data) != md5Str {
+			writeError(w, r, errBadDigest)
+			return
+		}
+	}
+
+	if strings.HasSuffix(key, "/") && size == 0 {
+		if _, err := g.b.MkdirAll(b.familyId, b.objectPath(key)); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	} else if _, err := g.put(b.familyId, b.objectPath(key), size, md5Str, tmp); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(md5Str))
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyObject 复制对象，目标对象使用源文件的MD5秒传，秒传失败时才下载源文件数据
+func (g *Gateway) copyObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	if idx := strings.Index(source, "?"); idx >= 0 {
+		source = source[:idx]
+	}
+	srcBucketName, srcKey := splitPath(source)
+	if !validKey(srcKey, false) {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	srcBucket, err := g.resolveBucket(srcBucketName)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	src, err := g.b.Stat(srcBucket.familyId, srcBucket.objectPath(srcKey))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if src.IsFolder {
+		writeError(w, r, errNoSuchKey)
+		return
+	}
+
+	reader := &lazyReader{open: func() (io.ReadCloser, error) {
+		return g.b.Open(srcBucket.familyId, src.FileId, 0, -1)
+	}}
+	defer reader.Close()
+	fe, err := g.put(b.familyId, b.objectPath(key), src.FileSize, strings.ToLower(src.FileMd5), reader)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeXML(w, http.StatusOK, &copyObjectResult{
+		LastModified: formatS3Time(fe.LastOpTime),
+		ETag: etag(src.FileMd5),
+	})
+}
+
+// deleteObject 删除对象，删除的文件进入回收站，对象不存在时同样返回成功
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	fe, err := g.b.Stat(b.familyId, b.objectPath(key))
+	if err != nil {
+		if panbackend.IsNotFound(err) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+	// 文件夹只有以 / 结尾的 key 才能删除
+	if fe.IsFolder != strings.HasSuffix(key, "/") {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := g.b.Remove(b.familyId, fe); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestBody 获取请求数据，处理 aws-chunked 编码，返回数据和声明的数据长度，长度未知时为-1
+func requestBody(r *http.Request) (io.Reader, int64) {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		size := int64(-1)
+		if v := r.Header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				size = n
+			}
+		}
+		return &chunkedReader{r: bufio.NewReader(r.Body)}, size
+	}
+	return r.Body, r.ContentLength
+}
+
+// spool 将数据写入临时文件并计算MD5，返回的文件已定位到开头
+func (g *Gateway) spool(reader io.Reader) (*os.File, int64, string, error) {
+	tmp, err := ioutil.TempFile(g.TempDir, "ctapi-s3-")
+	if err != nil {
+		return nil, 0, "", err
+	}
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), reader)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTempFile(tmp)
+		return nil, 0, "", err
+	}
+	return tmp, size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func removeTempFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// Read 读取 chunk 数据，忽略 chunk 签名以及结尾的 trailer
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remain == 0 {
+		if c.started {
+			if err := c.readCRLF(); err != nil {
+				return 0, err
+			}
+		}
+		c.started = true
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if idx := strings.Index(line, ";"); idx >= 0 {
+			line = line[:idx]
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil || size < 0 {
+			return 0, errors.New("invalid aws-chunked encoding")
+		}
+		if size == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remain = size
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.r.Read(p)
+	c.remain -= int64(n)
+	if err == io.EOF && c.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (c *chunkedReader) readCRLF() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil || strings.TrimRight(line, "\r\n") != "" {
+		return errors.New("invalid aws-chunked encoding")
+	}
+	return nil
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.rc == nil {
+		rc, err := l.open()
+		if err != nil {
+			return 0, err
+		}
+		l.rc = rc
+	}
+	return l.rc.Read(p)
+}
+
+func (l *lazyReader) Close() error {
+	if l.rc != nil {
+		return l.rc.Close()
+	}
+	return nil
+}
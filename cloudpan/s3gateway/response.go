@@ -0,0 +1,187 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"github.com/phpc0de/ctlibgo/logger"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// s3Error S3 错误响应
+	s3Error struct {
+		Code       string
+		Message    string
+		StatusCode int
+	}
+
+	errorResponse struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string   `xml:"Code"`
+		Message  string   `xml:"Message"`
+		Resource string   `xml:"Resource"`
+	}
+
+	bucketInfo struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}
+
+	listAllMyBucketsResult struct {
+		XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+		Owner   owner        `xml:"Owner"`
+		Buckets []bucketInfo `xml:"Buckets>Bucket"`
+	}
+
+	owner struct {
+		ID          string `xml:"ID"`
+		DisplayName string `xml:"DisplayName"`
+	}
+
+	object struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+
+	commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+
+	listBucketV2Result struct {
+		XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		EncodingType          string         `xml:"EncodingType,omitempty"`
+		KeyCount              int            `xml:"KeyCount"`
+		MaxKeys               int            `xml:"MaxKeys"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}
+
+	copyObjectResult struct {
+		XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+		LastModified string   `xml:"LastModified"`
+		ETag         string   `xml:"ETag"`
+	}
+
+	initiateMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadId string   `xml:"UploadId"`
+	}
+
+	completeMultipartUpload struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}
+
+	completePart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	completeMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}
+)
+
+var (
+	errAccessDenied            = &s3Error{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errNoSuchBucket            = &s3Error{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchKey               = &s3Error{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	errNoSuchUpload            = &s3Error{"NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound}
+	errInvalidPart             = &s3Error{"InvalidPart", "One or more of the specified parts could not be found", http.StatusBadRequest}
+	errInvalidPartOrder        = &s3Error{"InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest}
+	errInvalidArgument         = &s3Error{"InvalidArgument", "Invalid Argument", http.StatusBadRequest}
+	errInvalidRange            = &s3Error{"InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable}
+	errInvalidBucketName       = &s3Error{"InvalidBucketName", "The specified bucket is not valid", http.StatusBadRequest}
+	errBucketAlreadyOwnedByYou = &s3Error{"BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded", http.StatusConflict}
+	errMalformedXML            = &s3Error{"MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest}
+	errBadDigest               = &s3Error{"BadDigest", "The Content-MD5 you specified did not match what we received", http.StatusBadRequest}
+	errIncompleteBody          = &s3Error{"IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header", http.StatusBadRequest}
+	errMethodNotAllowed        = &s3Error{"MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed}
+	errNotImplemented          = &s3Error{"NotImplemented", "A header or query you provided implies functionality that is not implemented", http.StatusNotImplemented}
+)
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// writeError 输出 S3 错误响应，云盘接口返回的文件不存在错误转换为 NoSuchKey
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := err.(*s3Error)
+	if !ok {
+		if panbackend.IsNotFound(err) {
+			e = errNoSuchKey
+		} else {
+			logger.Verboseln("s3 gateway error: ", err)
+			e = &s3Error{"InternalError", err.Error(), http.StatusInternalServerError}
+		}
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.StatusCode)
+		return
+	}
+	writeXML(w, e.StatusCode, &errorResponse{Code: e.Code, Message: e.Message, Resource: r.URL.Path})
+}
+
+func writeXML(w http.ResponseWriter, statusCode int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// formatS3Time 将云盘的时间字符串转换为 S3 使用的 UTC 时间格式
+func formatS3Time(timeStr string) string {
+	return apiutil.ParseTimeStr(timeStr).UTC().Format(s3TimeLayout)
+}
+
+// etag 使用文件MD5作为 ETag
+func etag(md5 string) string {
+	return fmt.Sprintf(`"%s"`, strings.ToLower(md5))
+}
+
+func lastModified(timeStr string) string {
+	t := apiutil.ParseTimeStr(timeStr)
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(http.TimeFormat)
+}
@@ -89,6 +89,10 @@ type (
 		ShareType int `json:"shareType"`
 		// ShortShareUrl 分享的访问路径，和 AccessURL 一致
 		ShortShareUrl string `json:"shortShareUrl"`
+		// ExpireType 有效期类型，1-永久有效
+		ExpireType int `json:"expireType"`
+		// ExpireTime 有效天数，从 ShareTime 开始计算，0表示未知
+		ExpireTime int `json:"expireTime"`
 	}
 
 	ShareItemList []*ShareItem
@@ -154,6 +158,8 @@ const (
 	ShareExpiredTime1Day ShareExpiredTime = 1
 	// 7天期限
 	ShareExpiredTime7Day ShareExpiredTime = 7
+	// 30天期限，其他天数可以直接用 ShareExpiredTime(n) 指定
+	ShareExpiredTime30Day ShareExpiredTime = 30
 	// 永久期限
 	ShareExpiredTimeForever ShareExpiredTime = 2099
 
@@ -179,7 +185,7 @@ func (p *PanClient) SharePrivate(fileId string, expiredTime ShareExpiredTime) (*
 		if errResp.ErrorVO.ErrorCode != "" {
 			logger.Verboseln("SharePrivate response failed")
 			if errResp.ErrorVO.ErrorCode == "ShareCreateOverload" {
-				return nil, apierror.NewFailedApiError("您分享的次数已达上限，请明天再来吧")
+				return nil, apierror.NewApiError(apierror.ApiCodeShareCreateOverload, "您分享的次数已达上限，请明天再来吧")
 			}
 			return nil, apierror.NewApiErrorWithError(err)
 		}
@@ -203,6 +209,11 @@ func (p *PanClient) SharePublic(fileId string, expiredTime ShareExpiredTime) (*P
 		logger.Verboseln("SharePublic failed")
 		return nil, apierror.NewApiErrorWithError(err)
 	}
+	errResp := &errResp{}
+	if err := json.Unmarshal(body, errResp); err == nil && errResp.ErrorVO.ErrorCode == "ShareCreateOverload" {
+		logger.Verboseln("SharePublic response failed")
+		return nil, apierror.NewApiError(apierror.ApiCodeShareCreateOverload, "您分享的次数已达上限，请明天再来吧")
+	}
 	item := &PublicShareResult{}
 	if err := json.Unmarshal(body, item); err != nil {
 		logger.Verboseln("SharePublic response failed")
@@ -274,109 +285,28 @@ func (p *PanClient) ShareCancel(shareIdList []int64) (bool, *apierror.ApiError)
 	return item.Success, nil
 }
 
-// ShareSave 转存分享到对应的文件夹
+// ShareSave 转存分享的所有文件到对应的文件夹，只提交转存任务，不等待任务结束。
+// 需要等待任务结束、处理同名文件或者只转存部分文件时使用 ShareSaveWithParam
 func (p *PanClient) ShareSave(accessUrl string, accessCode string, savePanDirId string) (bool, *apierror.ApiError) {
-	shareCode := ""
-	idx := strings.LastIndex(accessUrl, "/")
-	if idx > 0 {
-		rs := []rune(accessUrl)
-		shareCode = string(rs[idx+1:])
-	}
-	fullUrl := &strings.Builder{}
-	header := map[string]string {
-		"accept": "application/json;charset=UTF-8",
-		"origin": "https://cloud.189.cn",
-		"Referer": "https://cloud.189.cn/web/share?code=" + shareCode,
-		"user-agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 11_3_0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.96 Safari/537.36",
-	}
-
-	// 获取分享基础信息
-	fmt.Fprintf(fullUrl, "%s/api/open/share/getShareInfoByCode.action?&shareCode=%s",
-		WEB_URL, shareCode)
-
-	logger.Verboseln("do request url: " + fullUrl.String())
-	body, err := client.Fetch("GET", fullUrl.String(), nil, header)
-	if err != nil {
-		logger.Verboseln("ShareListDirDetail failed")
-		return false, apierror.NewApiErrorWithError(err)
-	}
-
-	type shareInfoByCode struct {
-		ResCode    int    `json:"res_code"`
-		ResMessage string `json:"res_message"`
-		AccessCode string `json:"accessCode"`
-		ExpireTime     int    `json:"expireTime"`
-		ExpireType     int    `json:"expireType"`
-		FileId         string `json:"fileId"`
-		FileName       string `json:"fileName"`
-		FileSize       int    `json:"fileSize"`
-		IsFolder       bool   `json:"isFolder"`
-		NeedAccessCode int    `json:"needAccessCode"`
-		ShareDate      int64  `json:"shareDate"`
-		ShareId        int64    `json:"shareId"`
-		ShareMode      int    `json:"shareMode"`
-		ShareType      int    `json:"shareType"`
-	}
-	shareInfoEnity := &shareInfoByCode{}
-	if err := json.Unmarshal(body, shareInfoEnity); err != nil {
-		logger.Verboseln("getShareInfoByCode response failed")
-		return false, apierror.NewApiErrorWithError(err)
-	}
-
-
-	// 获取分享文件列表
-	fullUrl = &strings.Builder{}
-	if shareInfoEnity.IsFolder {
-		fmt.Fprintf(fullUrl, "%s/api/open/share/listShareDir.action?pageNum=1&pageSize=60&fileId=%s&shareDirFileId=%s&isFolder=true&shareId=%d&shareMode=%d&iconOption=5&orderBy=lastOpTime&descending=true&accessCode=%s",
-			WEB_URL, shareInfoEnity.FileId, shareInfoEnity.FileId, shareInfoEnity.ShareId, shareInfoEnity.ShareMode, accessCode)
-	} else {
-		fmt.Fprintf(fullUrl, "%s/api/open/share/listShareDir.action?fileId=%s&shareId=%d&shareMode=%d&isFolder=false&iconOption=5&pageNum=1&pageSize=10&accessCode=%s",
-			WEB_URL, shareInfoEnity.FileId, shareInfoEnity.ShareId, shareInfoEnity.ShareMode, accessCode)
-	}
-	logger.Verboseln("do request url: " + fullUrl.String())
-	body, err = client.Fetch("GET", fullUrl.String(), nil, header)
-	if err != nil {
-		logger.Verboseln("listShareDir failed")
-		return false, apierror.NewApiErrorWithError(err)
-	}
-
-	listShareDirEnity := &listShareDirResult{}
-	if err := json.Unmarshal(body, listShareDirEnity); err != nil {
-		logger.Verboseln("listShareDir response failed")
-		return false, apierror.NewApiErrorWithError(err)
-	}
-
-    // 转存分享
-	taskReqParam := &BatchTaskParam{
-		TypeFlag: BatchTaskTypeShareSave,
-		TaskInfos: makeBatchTaskInfoListForShareSave(listShareDirEnity),
-		TargetFolderId: savePanDirId,
-		ShareId: shareInfoEnity.ShareId,
-	}
-	taskId, apierror1 := p.CreateBatchTask(taskReqParam)
-	logger.Verboseln("share save taskid: ", taskId)
-	return taskId != "", apierror1
-}
-
-func makeBatchTaskInfoListForShareSave(opFileList *listShareDirResult) (infoList BatchTaskInfoList) {
-	// file
-	for _, fe := range opFileList.FileListAO.FileList {
-		infoItem := &BatchTaskInfo{
-			FileId: strconv.FormatInt(fe.Id, 10),
-			FileName: fe.Name,
-			IsFolder: 0,
+	info, apiErr := p.ShareInfoByUrl(accessUrl, accessCode)
+	if apiErr != nil {
+		return false, apiErr
+	}
+	list, apiErr := p.ShareListAllDir(info, "")
+	if apiErr != nil {
+		return false, apiErr
+	}
+	for _, chunk := range splitBatchTaskInfoList(shareBatchTaskInfoList(list), BatchTaskMaxFileCount) {
+		taskId, apiErr := p.CreateBatchTask(&BatchTaskParam{
+			TypeFlag: BatchTaskTypeShareSave,
+			TaskInfos: chunk,
+			TargetFolderId: savePanDirId,
+			ShareId: info.ShareId,
+		})
+		logger.Verboseln("share save taskid: ", taskId)
+		if apiErr != nil || taskId == "" {
+			return false, apiErr
 		}
-		infoList = append(infoList, infoItem)
 	}
-
-	// folder
-	for _, fe := range opFileList.FileListAO.FolderList {
-		infoItem := &BatchTaskInfo{
-			FileId: strconv.FormatInt(fe.Id, 10),
-			FileName: fe.Name,
-			IsFolder: 1,
-		}
-		infoList = append(infoList, infoItem)
-	}
-	return
-}
\ No newline at end of file
+	return true, nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"github.com/phpc0de/ctlibgo/logger"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type (
+	// ShareCreateParam 创建分享的参数
+	ShareCreateParam struct {
+		// FamilyId 文件所在的家庭云，<=0 为个人云
+		FamilyId int64
+		FileId string
+		// ShareMode 分享模式，默认私密分享
+		ShareMode ShareMode
+		// ExpiredTime 有效天数，可以是任意正整数，ShareExpiredTimeForever 为永久有效
+		ExpiredTime ShareExpiredTime
+		// AccessCode 自定义访问码，4位数字或字母，只对私密分享有效，为空由服务端生成。
+		// 服务端不允许自定义时会忽略，以返回结果中的访问码为准
+		AccessCode string
+	}
+
+	// ShareCreateResult 创建分享的结果
+	ShareCreateResult struct {
+		ShareId int64 `json:"shareId" xml:"shareId"`
+		ShareUrl string `json:"url" xml:"url"`
+		AccessCode string `json:"accessCode" xml:"accessCode"`
+		ShareMode ShareMode `json:"-" xml:"-"`
+		ExpiredTime ShareExpiredTime `json:"-" xml:"-"`
+	}
+
+	shareCreateLinkResult struct {
+		ResCode int `json:"res_code"`
+		ResMessage string `json:"res_message"`
+		ShareLinkList []*ShareCreateResult `json:"shareLinkList"`
+	}
+
+	appShareCreateLinkResult struct {
+		XMLName xml.Name `xml:"shareLink"`
+		ShareCreateResult
+	}
+)
+
+var (
+	shareAccessCodeFormat = regexp.MustCompile(`^[0-9A-Za-z]{4}$`)
+)
+
+// NewShareCreateParam 私密分享，7天有效
+func NewShareCreateParam(fileId string) *ShareCreateParam {
+	return &ShareCreateParam{
+		FileId: fileId,
+		ShareMode: ShareModePrivate,
+		ExpiredTime: ShareExpiredTime7Day,
+	}
+}
+
+func (param *ShareCreateParam) check() *apierror.ApiError {
+	if param.FileId == "" {
+		return apierror.NewFailedApiError("分享的文件ID不能为空")
+	}
+	if param.ExpiredTime <= 0 {
+		return apierror.NewFailedApiError("分享有效期必须大于0天")
+	}
+	if param.AccessCode != "" {
+		if param.ShareMode == ShareModePublic {
+			return apierror.NewFailedApiError("公开分享不能设置访问码")
+		}
+		if !shareAccessCodeFormat.MatchString(param.AccessCode) {
+			return apierror.NewFailedApiError("访问码必须是4位数字或字母")
+		}
+	}
+	return nil
+}
+
+// ShareCreate 创建分享，支持个人云和家庭云的文件，支持自定义有效天数和访问码
+func (p *PanClient) ShareCreate(param *ShareCreateParam) (*ShareCreateResult, *apierror.ApiError) {
+	if param.ShareMode == 0 {
+		param.ShareMode = ShareModePrivate
+	}
+	if apiErr := param.check(); apiErr != nil {
+		return nil, apiErr
+	}
+	var r *ShareCreateResult
+	var apiErr *apierror.ApiError
+	if param.FamilyId > 0 {
+		r, apiErr = p.appFamilyShareCreate(param)
+	} else {
+		r, apiErr = p.webShareCreate(param)
+	}
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	r.ShareUrl = normalizeShareUrl(r.ShareUrl)
+	r.ShareMode = param.ShareMode
+	r.ExpiredTime = param.ExpiredTime
+	return r, nil
+}
+
+func (p *PanClient) webShareCreate(param *ShareCreateParam) (*ShareCreateResult, *apierror.ApiError) {
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/api/open/share/createShareLink.action?fileId=%s&expireTime=%d&shareType=%d",
+		WEB_URL, param.FileId, param.ExpiredTime, param.ShareMode)
+	if param.AccessCode != "" {
+		fmt.Fprintf(fullUrl, "&accessCode=%s", url.QueryEscape(param.AccessCode))
+	}
+	logger.Verboseln("do request url: " + fullUrl.String())
+	body, err := p.client.Fetch("GET", fullUrl.String(), nil, map[string]string{
+		"accept": "application/json;charset=UTF-8",
+	})
+	if err != nil {
+		logger.Verboseln("ShareCreate failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	logger.Verboseln("response: " + string(body))
+	item := &shareCreateLinkResult{}
+	if err := json.Unmarshal(body, item); err != nil {
+		logger.Verboseln("ShareCreate response failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	if item.ResCode != 0 || len(item.ShareLinkList) == 0 {
+		if strings.Contains(item.ResMessage, "ShareCreateOverload") {
+			return nil, apierror.NewApiError(apierror.ApiCodeShareCreateOverload, "您分享的次数已达上限，请明天再来吧")
+		}
+		return nil, apierror.NewFailedApiError("创建分享失败: " + item.ResMessage)
+	}
+	return item.ShareLinkList[0], nil
+}
+
+func (p *PanClient) appFamilyShareCreate(param *ShareCreateParam) (*ShareCreateResult, *apierror.ApiError) {
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/family/share/createShareLink.action?familyId=%d&fileId=%s&expireTime=%d&shareType=%d",
+		API_URL, param.FamilyId, param.FileId, param.ExpiredTime, param.ShareMode)
+	if param.AccessCode != "" {
+		fmt.Fprintf(fullUrl, "&accessCode=%s", url.QueryEscape(param.AccessCode))
+	}
+	fmt.Fprintf(fullUrl, "&%s", apiutil.PcClientInfoSuffixParam())
+
+	sessionKey := p.appToken.FamilySessionKey
+	sessionSecret := p.appToken.FamilySessionSecret
+	httpMethod := "GET"
+	dateOfGmt := apiutil.DateOfGmtStr()
+	headers := map[string]string {
+		"Date": dateOfGmt,
+		"SessionKey": sessionKey,
+		"Signature": apiutil.SignatureOfHmac(sessionSecret, sessionKey, httpMethod, fullUrl.String(), dateOfGmt),
+		"X-Request-ID": apiutil.XRequestId(),
+	}
+
+	logger.Verboseln("do request url: " + fullUrl.String())
+	respBody, err1 := p.client.Fetch(httpMethod, fullUrl.String(), nil, headers)
+	if err1 != nil {
+		logger.Verboseln("appFamilyShareCreate occurs error: ", err1.Error())
+		return nil, apierror.NewApiErrorWithError(err1)
+	}
+	logger.Verboseln("response: " + string(respBody))
+
+	er := &apierror.AppErrorXmlResp{}
+	if err := xml.Unmarshal(respBody, er); err == nil {
+		if er.Code != "" {
+			if er.Code == "ShareCreateOverload" {
+				return nil, apierror.NewApiError(apierror.ApiCodeShareCreateOverload, "您分享的次数已达上限，请明天再来吧")
+			}
+			return nil, apierror.NewFailedApiError("创建家庭云分享失败: " + er.Code)
+		}
+	}
+
+	item := &appShareCreateLinkResult{}
+	if err := xml.Unmarshal(respBody, item); err != nil {
+		logger.Verboseln("appFamilyShareCreate response failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	r := item.ShareCreateResult
+	return &r, nil
+}
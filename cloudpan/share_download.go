@@ -0,0 +1,107 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"io"
+	"strings"
+)
+
+type (
+	shareFileDownloadUrlResult struct {
+		ResCode int `json:"res_code"`
+		ResMessage string `json:"res_message"`
+		FileDownloadUrl string `json:"fileDownloadUrl"`
+	}
+)
+
+// ShareGetFileDownloadUrl 获取分享中文件的下载链接，不需要先转存到自己的云盘，share 通过 ShareInfoByUrl 获取
+func (p *PanClient) ShareGetFileDownloadUrl(share *ShareInfo, fileId string) (string, *apierror.ApiError) {
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/api/open/file/getFileDownloadUrl.action?fileId=%s&dt=1&shareId=%d",
+		WEB_URL, fileId, share.ShareId)
+	logger.Verboseln("do request url: " + fullUrl.String())
+	body, err := p.client.Fetch("GET", fullUrl.String(), nil, shareWebHeader(share.ShareCode))
+	if err != nil {
+		logger.Verboseln("ShareGetFileDownloadUrl failed")
+		return "", apierror.NewApiErrorWithError(err)
+	}
+	logger.Verboseln("response: " + string(body))
+
+	item := &shareFileDownloadUrlResult{}
+	if err := json.Unmarshal(body, item); err != nil {
+		logger.Verboseln("ShareGetFileDownloadUrl response failed")
+		return "", apierror.NewApiErrorWithError(err)
+	}
+	if item.ResCode != 0 || item.FileDownloadUrl == "" {
+		return "", apierror.NewFailedApiError("获取分享文件下载链接失败: " + item.ResMessage)
+	}
+	downloadUrl := strings.ReplaceAll(item.FileDownloadUrl, "&amp;", "&")
+	if strings.HasPrefix(downloadUrl, "//") {
+		downloadUrl = "https:" + downloadUrl
+	}
+	return downloadUrl, nil
+}
+
+// ShareDownloadUrlReader 通过分享文件的下载链接打开文件数据流，支持区间下载，调用方负责关闭返回的 ReadCloser
+func (p *PanClient) ShareDownloadUrlReader(downloadUrl string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	return p.ShareDownloadUrlReaderContext(context.Background(), downloadUrl, fileRange)
+}
+
+// ShareDownloadUrlReaderContext 和 ShareDownloadUrlReader 一致，ctx 结束时停止限速等待
+func (p *PanClient) ShareDownloadUrlReaderContext(ctx context.Context, downloadUrl string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	headers := map[string]string{}
+	if rangeStr := fileRange.rangeHeader(); rangeStr != "" {
+		headers["range"] = rangeStr
+	}
+	logger.Verboseln("do request url: " + downloadUrl)
+	resp, err := p.openDownloadResponse("GET", downloadUrl, headers)
+	if err != nil {
+		logger.Verboseln("ShareDownloadUrlReader failed")
+		if apiErr, ok := err.(*apierror.ApiError); ok {
+			return nil, apiErr
+		}
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	return p.throttleDownloadReader(ctx, resp.Body), nil
+}
+
+// ShareDownloadFileReader 打开分享中文件的数据流，支持区间下载，调用方负责关闭返回的 ReadCloser
+func (p *PanClient) ShareDownloadFileReader(share *ShareInfo, fileId string, fileRange AppFileDownloadRange) (io.ReadCloser, *apierror.ApiError) {
+	downloadUrl, apiErr := p.ShareGetFileDownloadUrl(share, fileId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return p.ShareDownloadUrlReader(downloadUrl, fileRange)
+}
+
+// ShareDownloadFileToWriter 下载分享中的文件数据写入 writer，返回写入的字节数
+func (p *PanClient) ShareDownloadFileToWriter(share *ShareInfo, fileId string, fileRange AppFileDownloadRange, writer io.Writer) (int64, *apierror.ApiError) {
+	body, apiErr := p.ShareDownloadFileReader(share, fileId, fileRange)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	defer body.Close()
+	n, err := io.Copy(writer, body)
+	if err != nil {
+		return n, apierror.NewApiErrorWithError(err)
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAppFileDownloadRangeHeader(t *testing.T) {
+	assert.Equal(t, "", AppFileDownloadRange{}.rangeHeader())
+	assert.Equal(t, "bytes=100-", AppFileDownloadRange{Offset: 100}.rangeHeader())
+	assert.Equal(t, "bytes=0-99", AppFileDownloadRange{End: 99}.rangeHeader())
+	assert.Equal(t, "bytes=100-199", AppFileDownloadRange{Offset: 100, End: 199}.rangeHeader())
+}
+
+func TestShareDownloadUrlReader(t *testing.T) {
+	data := "0123456789"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Range") == "bytes=2-5" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(data[2:6]))
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer server.Close()
+	p := NewPanClient(WebLoginToken{}, AppLoginToken{})
+
+	body, apiErr := p.ShareDownloadUrlReader(server.URL+"/file", AppFileDownloadRange{})
+	assert.Nil(t, apiErr)
+	content, _ := ioutil.ReadAll(body)
+	body.Close()
+	assert.Equal(t, data, string(content))
+
+	body, apiErr = p.ShareDownloadUrlReader(server.URL+"/file", AppFileDownloadRange{Offset: 2, End: 5})
+	assert.Nil(t, apiErr)
+	content, _ = ioutil.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "2345", string(content))
+
+	body, apiErr = p.ShareDownloadUrlReader(server.URL+"/forbidden", AppFileDownloadRange{})
+	assert.Nil(t, body)
+	assert.NotNil(t, apiErr)
+}
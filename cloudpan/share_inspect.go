@@ -0,0 +1,313 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctlibgo/logger"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type (
+	// ShareCreator 分享者信息
+	ShareCreator struct {
+		NickName string `json:"nickName"`
+		OwnerAccount string `json:"ownerAccount"`
+		IconURL string `json:"iconURL"`
+	}
+
+	// ShareInfo 通过分享链接获取的分享信息
+	ShareInfo struct {
+		// ShareCode 分享链接中的分享码
+		ShareCode string `json:"shareCode"`
+		// AccessCode 提取码
+		AccessCode string `json:"accessCode"`
+		ShareId int64 `json:"shareId"`
+		// FileId 分享的文件或文件夹ID
+		FileId string `json:"fileId"`
+		FileName string `json:"fileName"`
+		// FileSize 分享的文件大小，文件夹为0
+		FileSize int64 `json:"fileSize"`
+		IsFolder bool `json:"isFolder"`
+		// NeedAccessCode 是否需要提取码，1-需要
+		NeedAccessCode int `json:"needAccessCode"`
+		// ShareMode 分享模式，1-私密，2-公开
+		ShareMode ShareMode `json:"shareMode"`
+		ShareType int `json:"shareType"`
+		// ShareDate 分享时间，时间戳ms
+		ShareDate int64 `json:"shareDate"`
+		// ExpireType 有效期类型，1-永久有效
+		ExpireType int `json:"expireType"`
+		// ExpireTime 剩余有效天数
+		ExpireTime int `json:"expireTime"`
+		Creator ShareCreator `json:"creator"`
+	}
+
+	// ShareFileEntity 分享中的文件
+	ShareFileEntity struct {
+		FileId string
+		ParentId string
+		FileName string
+		FileSize int64
+		FileMd5 string
+		IsFolder bool
+		MediaType MediaType
+		LastOpTime string
+		CreateTime string
+		// Path 文件在分享中的路径，以分享的根目录为 /
+		Path string
+	}
+
+	ShareFileList []*ShareFileEntity
+
+	// ShareInspectResult 分享内容
+	ShareInspectResult struct {
+		Info *ShareInfo
+		// FileList 分享中的所有文件和文件夹，按遍历顺序排列
+		FileList ShareFileList
+	}
+
+	shareCheckAccessCodeResult struct {
+		ResCode int `json:"res_code"`
+		ResMessage string `json:"res_message"`
+		ShareId int64 `json:"shareId"`
+	}
+)
+
+const (
+	// shareListPageSize 分享文件列表每页的数量
+	shareListPageSize = 60
+)
+
+// TotalSize 所有文件的总大小
+func (sfl ShareFileList) TotalSize() int64 {
+	var size int64
+	for _, fe := range sfl {
+		if !fe.IsFolder {
+			size += fe.FileSize
+		}
+	}
+	return size
+}
+
+// Count 文件和文件夹的数量
+func (sfl ShareFileList) Count() (fileN, directoryN int64) {
+	for _, fe := range sfl {
+		if fe.IsFolder {
+			directoryN++
+		} else {
+			fileN++
+		}
+	}
+	return
+}
+
+func shareWebHeader(shareCode string) map[string]string {
+	return map[string]string {
+		"accept": "application/json;charset=UTF-8",
+		"origin": "https://cloud.189.cn",
+		"Referer": "https://cloud.189.cn/web/share?code=" + shareCode,
+		"user-agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 11_3_0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.96 Safari/537.36",
+	}
+}
+
+// ShareInfoByUrl 通过分享链接和提取码获取分享信息，私密分享会校验提取码。
+// accessUrl 支持 ParseShareLink 能识别的所有格式，accessCode 为空时使用链接中的访问码
+func (p *PanClient) ShareInfoByUrl(accessUrl, accessCode string) (*ShareInfo, *apierror.ApiError) {
+	link, apiErr := ParseShareLink(accessUrl)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	shareCode := link.ShareCode
+	if accessCode == "" {
+		accessCode = link.AccessCode
+	}
+	header := shareWebHeader(shareCode)
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/api/open/share/getShareInfoByCode.action?shareCode=%s",
+		WEB_URL, url.QueryEscape(shareCode))
+	logger.Verboseln("do request url: " + fullUrl.String())
+	body, err := client.Fetch("GET", fullUrl.String(), nil, header)
+	if err != nil {
+		logger.Verboseln("ShareInfoByUrl failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	logger.Verboseln("response: " + string(body))
+
+	type shareInfoResp struct {
+		ResCode int `json:"res_code"`
+		ResMessage string `json:"res_message"`
+		ShareInfo
+	}
+	item := &shareInfoResp{}
+	if err := json.Unmarshal(body, item); err != nil {
+		logger.Verboseln("ShareInfoByUrl response failed")
+		return nil, apierror.NewApiErrorWithError(err)
+	}
+	if item.ResCode != 0 {
+		return nil, apierror.NewFailedApiError("获取分享信息失败: " + item.ResMessage)
+	}
+	info := &item.ShareInfo
+	info.ShareCode = shareCode
+	if info.AccessCode == "" {
+		info.AccessCode = accessCode
+	}
+
+	if info.NeedAccessCode == 1 {
+		if accessCode == "" {
+			return nil, apierror.NewFailedApiError("该分享需要提取码")
+		}
+		fullUrl = &strings.Builder{}
+		fmt.Fprintf(fullUrl, "%s/api/open/share/checkAccessCode.action?shareCode=%s&accessCode=%s",
+			WEB_URL, url.QueryEscape(shareCode), url.QueryEscape(accessCode))
+		logger.Verboseln("do request url: " + fullUrl.String())
+		body, err = client.Fetch("GET", fullUrl.String(), nil, header)
+		if err != nil {
+			logger.Verboseln("checkAccessCode failed")
+			return nil, apierror.NewApiErrorWithError(err)
+		}
+		r := &shareCheckAccessCodeResult{}
+		if err := json.Unmarshal(body, r); err != nil || r.ShareId == 0 {
+			logger.Verboseln("checkAccessCode response failed")
+			return nil, apierror.NewFailedApiError("提取码错误")
+		}
+		info.ShareId = r.ShareId
+		info.AccessCode = accessCode
+	}
+	return info, nil
+}
+
+// ShareListDir 获取分享中文件夹下的一页文件，fileId 为空获取分享的根目录
+func (p *PanClient) ShareListDir(share *ShareInfo, fileId string, pageNum, pageSize int) (ShareFileList, int, *apierror.ApiError) {
+	if fileId == "" {
+		fileId = share.FileId
+	}
+	isFolder := share.IsFolder || fileId != share.FileId
+	fullUrl := &strings.Builder{}
+	fmt.Fprintf(fullUrl, "%s/api/open/share/listShareDir.action?pageNum=%d&pageSize=%d&fileId=%s&shareDirFileId=%s&isFolder=%t&shareId=%d&shareMode=%d&iconOption=5&orderBy=lastOpTime&descending=true&accessCode=%s",
+		WEB_URL, pageNum, pageSize, fileId, share.FileId, isFolder, share.ShareId, share.ShareMode, url.QueryEscape(share.AccessCode))
+	logger.Verboseln("do request url: " + fullUrl.String())
+	body, err := client.Fetch("GET", fullUrl.String(), nil, shareWebHeader(share.ShareCode))
+	if err != nil {
+		logger.Verboseln("ShareListDir failed")
+		return nil, 0, apierror.NewApiErrorWithError(err)
+	}
+	item := &listShareDirResult{}
+	if err := json.Unmarshal(body, item); err != nil {
+		logger.Verboseln("ShareListDir response failed")
+		return nil, 0, apierror.NewApiErrorWithError(err)
+	}
+	if item.ResCode != 0 {
+		return nil, 0, apierror.NewFailedApiError("获取分享文件列表失败: " + item.ResMessage)
+	}
+	return item.toShareFileList(fileId), item.FileListAO.Count, nil
+}
+
+func (r *listShareDirResult) toShareFileList(parentId string) ShareFileList {
+	list := ShareFileList{}
+	for _, fe := range r.FileListAO.FolderList {
+		list = append(list, &ShareFileEntity{
+			FileId: strconv.FormatInt(fe.Id, 10),
+			ParentId: parentId,
+			FileName: fe.Name,
+			IsFolder: true,
+			LastOpTime: fe.LastOpTime,
+			CreateTime: fe.CreateDate,
+		})
+	}
+	for _, fe := range r.FileListAO.FileList {
+		list = append(list, &ShareFileEntity{
+			FileId: strconv.FormatInt(fe.Id, 10),
+			ParentId: parentId,
+			FileName: fe.Name,
+			FileSize: fe.Size,
+			FileMd5: fe.Md5,
+			MediaType: MediaType(fe.MediaType),
+			LastOpTime: fe.LastOpTime,
+			CreateTime: fe.CreateDate,
+		})
+	}
+	return list
+}
+
+// ShareListAllDir 获取分享中文件夹下的所有文件，自动翻页
+func (p *PanClient) ShareListAllDir(share *ShareInfo, fileId string) (ShareFileList, *apierror.ApiError) {
+	return listAllSharePages(func(pageNum int) (ShareFileList, int, *apierror.ApiError) {
+		return p.ShareListDir(share, fileId, pageNum, shareListPageSize)
+	})
+}
+
+func listAllSharePages(fetch func(pageNum int) (ShareFileList, int, *apierror.ApiError)) (ShareFileList, *apierror.ApiError) {
+	result := ShareFileList{}
+	for pageNum := 1; ; pageNum++ {
+		list, count, apiErr := fetch(pageNum)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result = append(result, list...)
+		if len(list) == 0 || len(result) >= count {
+			return result, nil
+		}
+	}
+}
+
+// ShareFilesRecurseList 递归获取分享中的所有文件和文件夹
+func (p *PanClient) ShareFilesRecurseList(share *ShareInfo) (ShareFileList, *apierror.ApiError) {
+	return walkShareDir(share.FileId, "/", func(fileId string) (ShareFileList, *apierror.ApiError) {
+		return p.ShareListAllDir(share, fileId)
+	})
+}
+
+func walkShareDir(fileId, dirPath string, list func(fileId string) (ShareFileList, *apierror.ApiError)) (ShareFileList, *apierror.ApiError) {
+	children, apiErr := list(fileId)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	result := ShareFileList{}
+	for _, fe := range children {
+		fe.Path = path.Join(dirPath, fe.FileName)
+		result = append(result, fe)
+		if !fe.IsFolder {
+			continue
+		}
+		sub, apiErr := walkShareDir(fe.FileId, fe.Path, list)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result = append(result, sub...)
+	}
+	return result, nil
+}
+
+// ShareInspect 获取分享信息以及分享中的所有文件，不会转存分享
+func (p *PanClient) ShareInspect(accessUrl, accessCode string) (*ShareInspectResult, *apierror.ApiError) {
+	info, apiErr := p.ShareInfoByUrl(accessUrl, accessCode)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	fileList, apiErr := p.ShareFilesRecurseList(info)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &ShareInspectResult{
+		Info: info,
+		FileList: fileList,
+	}, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func TestShareDirPagination(t *testing.T) {
+	// 根目录有130个文件，分3页返回
+	pages := 0
+	list, err := listAllSharePages(func(pageNum int) (ShareFileList, int, *apierror.ApiError) {
+		pages++
+		l := ShareFileList{}
+		for i := (pageNum - 1) * 60; i < pageNum*60 && i < 130; i++ {
+			l = append(l, &ShareFileEntity{FileId: strconv.Itoa(i)})
+		}
+		return l, 130, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, 130, len(list))
+
+	tree := map[string]ShareFileList{
+		"root": {{FileId: "1", FileName: "docs", IsFolder: true}, {FileId: "2", FileName: "a.txt", FileSize: 3}},
+		"1": {{FileId: "3", FileName: "b.txt", FileSize: 4}},
+	}
+	files, err := walkShareDir("root", "/", func(fileId string) (ShareFileList, *apierror.ApiError) {
+		return tree[fileId], nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, "/docs/b.txt", files[1].Path)
+	assert.Equal(t, int64(7), files.TotalSize())
+}
+
+func TestSelectShareFiles(t *testing.T) {
+	files := ShareFileList{
+		{FileId: "1", FileName: "photos", Path: "/photos", IsFolder: true},
+		{FileId: "2", FileName: "a.jpg", Path: "/photos/a.jpg"},
+		{FileId: "3", FileName: "b.png", Path: "/photos/b.png"},
+		{FileId: "4", FileName: "docs", Path: "/docs", IsFolder: true},
+		{FileId: "5", FileName: "c.txt", Path: "/docs/c.txt"},
+		{FileId: "6", FileName: "d.txt", Path: "/docs/d.txt"},
+	}
+
+	selected, skipped := selectShareFiles(files, []string{"photos/*.jpg", "6"})
+	assert.Equal(t, 2, len(selected))
+	assert.Equal(t, "2", selected[0].FileId)
+	assert.Equal(t, "6", selected[1].FileId)
+	assert.Equal(t, 2, len(skipped))
+
+	// 选中文件夹后不再重复选中子文件
+	selected, skipped = selectShareFiles(files, []string{"/docs", "/docs/c.txt"})
+	assert.Equal(t, 1, len(selected))
+	assert.Equal(t, "4", selected[0].FileId)
+	assert.Equal(t, 2, len(skipped))
+}
+
+func TestGroupShareFilesByDir(t *testing.T) {
+	files := ShareFileList{
+		{FileId: "1", FileName: "top.txt", Path: "/top.txt"},
+		{FileId: "2", FileName: "a.jpg", Path: "/photos/a.jpg"},
+		{FileId: "3", FileName: "c.txt", Path: "/docs/2021/c.txt"},
+		{FileId: "4", FileName: "b.jpg", Path: "/photos/b.jpg"},
+		{FileId: "5", FileName: "root.txt"},
+	}
+	// 选中的子文件夹中的文件按相对路径分组，转存到目标文件夹下对应的子文件夹
+	dirs, groups := groupShareFilesByDir(files)
+	assert.Equal(t, []string{"", "photos", "docs/2021"}, dirs)
+	assert.Equal(t, 2, len(groups[""]))
+	assert.Equal(t, "5", groups[""][1].FileId)
+	assert.Equal(t, 2, len(groups["photos"]))
+	assert.Equal(t, "3", groups["docs/2021"][0].FileId)
+}
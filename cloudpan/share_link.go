@@ -0,0 +1,87 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type (
+	// ShareLink 从分享链接或者分享文本中解析出的分享码和访问码
+	ShareLink struct {
+		// ShareCode 分享码
+		ShareCode string
+		// AccessCode 访问码，链接中没有访问码时为空
+		AccessCode string
+	}
+)
+
+var (
+	// shareCodeRegexps 各种格式的分享链接
+	shareCodeRegexps = []*regexp.Regexp{
+		// https://cloud.189.cn/web/share?code=XXXX
+		// https://cloud.189.cn/share.html?code=XXXX
+		regexp.MustCompile(`(?i)cloud\.189\.cn/(?:web/)?share(?:\.html)?\?(?:[^#\s]*&)?code=([0-9a-z]+)`),
+		// https://cloud.189.cn/t/XXXX
+		// https://h5.cloud.189.cn/share.html#/t/XXXX
+		// https://cloud.189.cn/web/share.html#/t/XXXX
+		regexp.MustCompile(`(?i)cloud\.189\.cn/(?:[^\s#]*#/)?t/([0-9a-z]+)`),
+	}
+
+	// shareAccessCodeRegexp 分享文本或者链接参数中的访问码，例如 （访问码：abcd）、提取码: abcd、?accessCode=abcd、&pwd=abcd。
+	// 文本必须有冒号分隔，accessCode 和 pwd 只匹配链接参数，避免匹配到分享码中的字符
+	shareAccessCodeRegexp = regexp.MustCompile(`(?i)(?:(?:访问码|提取码|密码)\s*[:：]\s*|[?&](?:accessCode|pwd)=)([0-9a-z]+)`)
+
+	// bareShareCodeRegexp 只有分享码
+	bareShareCodeRegexp = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+)
+
+// ParseShareLink 从分享链接或者复制的分享文本中解析分享码和访问码，支持
+// https://cloud.189.cn/t/XXXX、https://cloud.189.cn/web/share?code=XXXX、
+// https://h5.cloud.189.cn/share.html#/t/XXXX 等格式，以及经过URL编码的链接和只有分享码的文本
+func ParseShareLink(text string) (*ShareLink, *apierror.ApiError) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, apierror.NewFailedApiError("分享链接为空")
+	}
+	text = strings.ReplaceAll(text, "&amp;", "&")
+	// 链接可能被编码过一次或者多次
+	for i := 0; i < 3 && strings.Contains(text, "%"); i++ {
+		decoded, err := url.PathUnescape(text)
+		if err != nil || decoded == text {
+			break
+		}
+		text = decoded
+	}
+
+	link := &ShareLink{}
+	if m := shareAccessCodeRegexp.FindStringSubmatch(text); m != nil {
+		link.AccessCode = m[1]
+	}
+	for _, re := range shareCodeRegexps {
+		if m := re.FindStringSubmatch(text); m != nil {
+			link.ShareCode = m[1]
+			return link, nil
+		}
+	}
+	if bareShareCodeRegexp.MatchString(text) {
+		link.ShareCode = text
+		return link, nil
+	}
+	return nil, apierror.NewFailedApiError("无法识别的分享链接: " + text)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseShareLink(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		shareCode string
+		accessCode string
+		fail bool
+	}{
+		{"short", "https://cloud.189.cn/t/aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"short http", "http://cloud.189.cn/t/aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"no scheme", "cloud.189.cn/t/aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"web share", "https://cloud.189.cn/web/share?code=aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"web share more query", "https://cloud.189.cn/web/share?from=app&code=aEfYJjUnqMba&x=1", "aEfYJjUnqMba", "", false},
+		{"share html query", "https://cloud.189.cn/share.html?code=aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"h5 hash", "https://h5.cloud.189.cn/share.html#/t/aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"web hash", "https://cloud.189.cn/web/share.html#/t/aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"trailing access code", "https://cloud.189.cn/t/aEfYJjUnqMba（访问码：ab12）", "aEfYJjUnqMba", "ab12", false},
+		{"trailing ascii access code", "https://cloud.189.cn/t/aEfYJjUnqMba (访问码:ab12)", "aEfYJjUnqMba", "ab12", false},
+		{"url encoded", "https://cloud.189.cn/t/aEfYJjUnqMba%EF%BC%88%E8%AE%BF%E9%97%AE%E7%A0%81%EF%BC%9Aab12%EF%BC%89", "aEfYJjUnqMba", "ab12", false},
+		{"url encoded query", "https%3A%2F%2Fcloud.189.cn%2Fweb%2Fshare%3Fcode%3DaEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"html escaped", "https://cloud.189.cn/web/share?from=app&amp;code=aEfYJjUnqMba", "aEfYJjUnqMba", "", false},
+		{"message", "分享文件：照片\n链接：https://cloud.189.cn/t/aEfYJjUnqMba 提取码：ab12\n复制链接打开天翼云盘", "aEfYJjUnqMba", "ab12", false},
+		{"message access code first", "访问码: ab12 https://cloud.189.cn/web/share?code=aEfYJjUnqMba", "aEfYJjUnqMba", "ab12", false},
+		{"access code param", "https://cloud.189.cn/web/share?code=aEfYJjUnqMba&accessCode=ab12", "aEfYJjUnqMba", "ab12", false},
+		{"pwd param", "https://cloud.189.cn/t/aEfYJjUnqMba?pwd=ab12", "aEfYJjUnqMba", "ab12", false},
+		{"pwd in share code", "https://cloud.189.cn/t/aBpWdQ12", "aBpWdQ12", "", false},
+		{"access code in share code", "https://cloud.189.cn/web/share?code=accessCode12", "accessCode12", "", false},
+		{"bare code", "  aEfYJjUnqMba ", "aEfYJjUnqMba", "", false},
+		{"empty", "   ", "", "", true},
+		{"other site", "https://example.com/t/aEfYJjUnqMba", "", "", true},
+	}
+	for _, c := range cases {
+		link, err := ParseShareLink(c.text)
+		if c.fail {
+			assert.NotNil(t, err, c.name)
+			continue
+		}
+		if !assert.Nil(t, err, c.name) {
+			continue
+		}
+		assert.Equal(t, c.shareCode, link.ShareCode, c.name)
+		assert.Equal(t, c.accessCode, link.AccessCode, c.name)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ShareBatchItem 批量分享中一个文件的分享结果
+	ShareBatchItem struct {
+		Path string `json:"path"`
+		FileId string `json:"fileId"`
+		FileName string `json:"fileName"`
+		IsFolder bool `json:"isFolder"`
+		ShareMode ShareMode `json:"shareMode"`
+		// ShareId 分享ID
+		ShareId int64 `json:"shareId,omitempty"`
+		ShareUrl string `json:"shareUrl"`
+		// AccessCode 访问码，只有私密分享才有
+		AccessCode string `json:"accessCode,omitempty"`
+		// Error 分享失败的原因，为空表示分享成功
+		Error string `json:"error,omitempty"`
+	}
+
+	ShareBatchItemList []*ShareBatchItem
+
+	// ShareAuditRule 分享审计规则，值为0的规则不生效
+	ShareAuditRule struct {
+		// MaxAge 分享时间超过 MaxAge 的也视为已过期，已经超过有效期的分享总是会被标记
+		MaxAge time.Duration
+		// MaxAccessCount 查看、下载、转存的总次数超过 MaxAccessCount 的分享
+		MaxAccessCount int
+		// ReviewFailed 是否标记审查状态不正常(ReviewStatus != 1)的分享
+		ReviewFailed bool
+	}
+
+	// ShareAuditItem 分享审计结果
+	ShareAuditItem struct {
+		Share *ShareItem
+		// Expired 已过期
+		Expired bool
+		// ReviewFailed 审查状态不正常
+		ReviewFailed bool
+		// AccessExceeded 访问次数超过阈值
+		AccessExceeded bool
+	}
+
+	ShareAuditItemList []*ShareAuditItem
+)
+
+const (
+	// shareCancelBatchSize 每次取消分享的数量
+	shareCancelBatchSize = 50
+)
+
+// Total 查看、下载、转存的总次数
+func (a AccessCount) Total() int {
+	return a.CopyCount + a.DownloadCount + a.PreviewCount
+}
+
+// ShareTimeOf 分享的创建时间
+func (s *ShareItem) ShareTimeOf() time.Time {
+	ms := s.ShareTime
+	if ms <= 0 {
+		ms = s.ShareDate
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// ExpiredAt 分享的过期时间，永久有效或者有效期未知时返回零值
+func (s *ShareItem) ExpiredAt() time.Time {
+	if s.ExpireType == 1 || s.ExpireTime <= 0 || ShareExpiredTime(s.ExpireTime) == ShareExpiredTimeForever {
+		return time.Time{}
+	}
+	return s.ShareTimeOf().AddDate(0, 0, s.ExpireTime)
+}
+
+// IsExpired 分享在 now 时是否已经过期
+func (s *ShareItem) IsExpired(now time.Time) bool {
+	expiredAt := s.ExpiredAt()
+	return !expiredAt.IsZero() && !now.Before(expiredAt)
+}
+
+// Failed 分享失败的文件
+func (l ShareBatchItemList) Failed() ShareBatchItemList {
+	r := ShareBatchItemList{}
+	for _, item := range l {
+		if item.Error != "" {
+			r = append(r, item)
+		}
+	}
+	return r
+}
+
+// WriteCSV 导出分享链接和访问码为CSV，第一行为表头
+func (l ShareBatchItemList) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "fileId", "isFolder", "shareMode", "shareUrl", "accessCode", "error"}); err != nil {
+		return err
+	}
+	for _, item := range l {
+		record := []string{
+			item.Path,
+			item.FileId,
+			strconv.FormatBool(item.IsFolder),
+			strconv.Itoa(int(item.ShareMode)),
+			item.ShareUrl,
+			item.AccessCode,
+			item.Error,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON 导出分享链接和访问码为JSON数组
+func (l ShareBatchItemList) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if l == nil {
+		l = ShareBatchItemList{}
+	}
+	return enc.Encode(l)
+}
+
+// normalizeShareUrl 补全分享链接的协议
+func normalizeShareUrl(u string) string {
+	if strings.HasPrefix(u, "//") {
+		return "https:" + u
+	}
+	return u
+}
+
+// ShareBatchCreate 批量分享个人云的文件，paths 为文件的完整路径。单个文件分享失败不会中断，
+// 失败原因记录在 ShareBatchItem.Error 中，达到每日分享次数上限时停止并返回错误
+func (p *PanClient) ShareBatchCreate(paths []string, mode ShareMode, expiredTime ShareExpiredTime) (ShareBatchItemList, *apierror.ApiError) {
+	return p.ShareBatchCreateWithParam(paths, &ShareCreateParam{
+		ShareMode: mode,
+		ExpiredTime: expiredTime,
+	})
+}
+
+// ShareBatchCreateWithParam 使用相同的参数批量分享文件，param.FamilyId 指定文件所在的家庭云，param.FileId 不使用。
+// 每个文件都通过 ShareCreate 创建分享，支持自定义有效天数和访问码，失败和上限的处理和 ShareBatchCreate 一致
+func (p *PanClient) ShareBatchCreateWithParam(paths []string, param *ShareCreateParam) (ShareBatchItemList, *apierror.ApiError) {
+	mode := param.ShareMode
+	if mode == 0 {
+		mode = ShareModePrivate
+	}
+	result := ShareBatchItemList{}
+	for _, pathStr := range paths {
+		item := &ShareBatchItem{
+			Path: pathStr,
+			ShareMode: mode,
+		}
+		result = append(result, item)
+
+		fe, apiErr := p.AppFileInfoByPath(param.FamilyId, pathStr)
+		if apiErr != nil {
+			item.Error = apiErr.Error()
+			continue
+		}
+		item.FileId = fe.FileId
+		item.FileName = fe.FileName
+		item.IsFolder = fe.IsFolder
+
+		fileParam := *param
+		fileParam.FileId = fe.FileId
+		fileParam.ShareMode = mode
+		r, apiErr := p.ShareCreate(&fileParam)
+		if apiErr != nil {
+			item.Error = apiErr.Error()
+			if isShareLimitError(apiErr) {
+				return result, apiErr
+			}
+			continue
+		}
+		item.ShareId = r.ShareId
+		item.ShareUrl = r.ShareUrl
+		if mode == ShareModePrivate {
+			item.AccessCode = r.AccessCode
+		}
+	}
+	return result, nil
+}
+
+// isShareLimitError 是否达到每日分享次数上限，之后的分享都会失败
+func isShareLimitError(apiErr *apierror.ApiError) bool {
+	return apiErr != nil && apiErr.Code == apierror.ApiCodeShareCreateOverload
+}
+
+// ShareListAll 获取所有分享，自动翻页
+func (p *PanClient) ShareListAll() (ShareItemList, *apierror.ApiError) {
+	result := ShareItemList{}
+	param := NewShareListParam()
+	for {
+		r, apiErr := p.ShareList(param)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result = append(result, r.Data...)
+		if len(r.Data) == 0 || len(result) >= r.RecordCount {
+			return result, nil
+		}
+		param.PageNum++
+	}
+}
+
+// AuditShares 按规则审计分享，只返回被标记的分享，rule 为 nil 时和值为0的规则一致
+func AuditShares(shares ShareItemList, rule *ShareAuditRule, now time.Time) ShareAuditItemList {
+	if rule == nil {
+		rule = &ShareAuditRule{}
+	}
+	result := ShareAuditItemList{}
+	for _, s := range shares {
+		item := &ShareAuditItem{Share: s}
+		if s.IsExpired(now) || (rule.MaxAge > 0 && now.Sub(s.ShareTimeOf()) > rule.MaxAge) {
+			item.Expired = true
+		}
+		if rule.ReviewFailed && s.ReviewStatus != 1 {
+			item.ReviewFailed = true
+		}
+		if rule.MaxAccessCount > 0 && s.AccessCount.Total() > rule.MaxAccessCount {
+			item.AccessExceeded = true
+		}
+		if item.Flagged() {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Flagged 是否命中任一审计规则
+func (a *ShareAuditItem) Flagged() bool {
+	return a.Expired || a.ReviewFailed || a.AccessExceeded
+}
+
+// ShareIdList 审计结果的分享ID
+func (l ShareAuditItemList) ShareIdList() []int64 {
+	ids := []int64{}
+	for _, item := range l {
+		ids = append(ids, item.Share.ShareId)
+	}
+	return ids
+}
+
+// ShareAudit 获取所有分享并按规则审计
+func (p *PanClient) ShareAudit(rule *ShareAuditRule) (ShareAuditItemList, *apierror.ApiError) {
+	shares, apiErr := p.ShareListAll()
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return AuditShares(shares, rule, time.Now()), nil
+}
+
+// ShareCancelByRule 取消命中审计规则的分享，返回已取消的分享
+func (p *PanClient) ShareCancelByRule(rule *ShareAuditRule) (ShareAuditItemList, *apierror.ApiError) {
+	flagged, apiErr := p.ShareAudit(rule)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	cancelled := ShareAuditItemList{}
+	for start := 0; start < len(flagged); start += shareCancelBatchSize {
+		end := start + shareCancelBatchSize
+		if end > len(flagged) {
+			end = len(flagged)
+		}
+		chunk := flagged[start:end]
+		ok, apiErr := p.ShareCancel(chunk.ShareIdList())
+		if apiErr != nil {
+			return cancelled, apiErr
+		}
+		if !ok {
+			return cancelled, apierror.NewFailedApiError("取消分享失败，请稍后重试")
+		}
+		cancelled = append(cancelled, chunk...)
+	}
+	return cancelled, nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"bytes"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAuditShares(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(d time.Duration) int64 {
+		return now.Add(-d).UnixNano() / int64(time.Millisecond)
+	}
+	shares := ShareItemList{
+		{ShareId: 1, ReviewStatus: 1, ShareTime: ms(time.Hour)},
+		{ShareId: 2, ReviewStatus: 1, ShareTime: ms(10 * 24 * time.Hour)},
+		{ShareId: 3, ReviewStatus: 2, ShareTime: ms(time.Hour)},
+		{ShareId: 4, ReviewStatus: 1, ShareDate: ms(time.Hour), AccessCount: AccessCount{CopyCount: 5, DownloadCount: 5, PreviewCount: 1}},
+		{ShareId: 5, ReviewStatus: 1, ShareTime: ms(2 * 24 * time.Hour), ExpireTime: 1},
+		{ShareId: 6, ReviewStatus: 1, ShareTime: ms(30 * 24 * time.Hour), ExpireType: 1, ExpireTime: 1},
+	}
+	rule := &ShareAuditRule{
+		MaxAge: 7 * 24 * time.Hour,
+		MaxAccessCount: 10,
+		ReviewFailed: true,
+	}
+	r := AuditShares(shares, rule, now)
+	assert.Equal(t, []int64{2, 3, 4, 5, 6}, r.ShareIdList())
+	assert.True(t, r[0].Expired)
+	assert.True(t, r[1].ReviewFailed)
+	assert.False(t, r[1].Expired)
+	assert.True(t, r[2].AccessExceeded)
+	assert.True(t, r[3].Expired)
+	assert.True(t, r[4].Expired)
+
+	// 值为0的规则不生效，只标记超过有效期的分享
+	r = AuditShares(shares, &ShareAuditRule{}, now)
+	assert.Equal(t, []int64{5}, r.ShareIdList())
+	r = AuditShares(shares, nil, now)
+	assert.Equal(t, []int64{5}, r.ShareIdList())
+}
+
+func TestShareBatchExport(t *testing.T) {
+	list := ShareBatchItemList{
+		{Path: "/a.txt", FileId: "1", ShareMode: ShareModePrivate, ShareUrl: "https://cloud.189.cn/t/abc", AccessCode: "ab12"},
+		{Path: "/b,c", ShareMode: ShareModePrivate, Error: "文件不存在"},
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, list.WriteCSV(buf))
+	assert.Equal(t, "path,fileId,isFolder,shareMode,shareUrl,accessCode,error\n"+
+		"/a.txt,1,false,1,https://cloud.189.cn/t/abc,ab12,\n"+
+		"\"/b,c\",,false,1,,,文件不存在\n", buf.String())
+	assert.Equal(t, 1, len(list.Failed()))
+
+	buf.Reset()
+	assert.Nil(t, list[:1].WriteJSON(buf))
+	assert.Contains(t, buf.String(), `"accessCode": "ab12"`)
+	assert.NotContains(t, buf.String(), `"error"`)
+}
+
+func TestShareCreateParamCheck(t *testing.T) {
+	param := NewShareCreateParam("123")
+	assert.Nil(t, param.check())
+
+	param.ExpiredTime = 15
+	param.AccessCode = "ab12"
+	assert.Nil(t, param.check())
+
+	param.AccessCode = "ab1"
+	assert.NotNil(t, param.check())
+
+	param.AccessCode = "ab12"
+	param.ShareMode = ShareModePublic
+	assert.NotNil(t, param.check())
+
+	param = NewShareCreateParam("123")
+	param.ExpiredTime = 0
+	assert.NotNil(t, param.check())
+}
+
+func TestIsShareLimitError(t *testing.T) {
+	assert.True(t, isShareLimitError(apierror.NewApiError(apierror.ApiCodeShareCreateOverload, "您分享的次数已达上限，请明天再来吧")))
+	// 只按错误码判断，不依赖提示信息
+	assert.False(t, isShareLimitError(apierror.NewFailedApiError("您分享的次数已达上限，请明天再来吧")))
+	assert.False(t, isShareLimitError(apierror.NewFailedApiError("文件不存在")))
+	assert.False(t, isShareLimitError(nil))
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudpan
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"path"
+	"strings"
+)
+
+type (
+	// ShareSaveParam 转存分享的参数
+	ShareSaveParam struct {
+		AccessUrl string
+		AccessCode string
+		// SavePanDirId 转存到的个人云文件夹ID
+		SavePanDirId string
+		// Include 只转存匹配的文件，可以是分享中的路径、通配符或者文件ID，为空转存分享的所有文件。
+		// 匹配的文件夹会整个转存，子文件夹中的文件保留在分享中的相对路径，缺少的文件夹会在 SavePanDirId 下创建
+		Include []string
+		// Conflict 同名文件的处理方式，为 nil 时遇到同名文件返回 ApiCodeBatchTaskConflict 错误
+		Conflict *BatchTaskConflictOption
+	}
+
+	// ShareSaveResult 转存分享的结果
+	ShareSaveResult struct {
+		Info *ShareInfo
+		// Saved 提交转存的文件
+		Saved ShareFileList
+		// Skipped 没有匹配 Include 而未转存的文件
+		Skipped ShareFileList
+		// Summary 转存任务的结果
+		Summary *BatchTaskSummary
+	}
+)
+
+// matchShareFile 文件是否匹配 include 中的路径、通配符或者文件ID
+func matchShareFile(fe *ShareFileEntity, include []string) bool {
+	for _, pattern := range include {
+		if pattern == fe.FileId {
+			return true
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = "/" + pattern
+		}
+		pattern = path.Clean(pattern)
+		if pattern == fe.Path {
+			return true
+		}
+		if ok, _ := path.Match(pattern, fe.Path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectShareFiles 选出要转存的文件，已选中文件夹下的文件不会重复选中。
+// files 必须按遍历顺序排列，即文件夹在其子文件之前
+func selectShareFiles(files ShareFileList, include []string) (selected, skipped ShareFileList) {
+	selected = ShareFileList{}
+	skipped = ShareFileList{}
+	selectedDirs := []string{}
+	for _, fe := range files {
+		covered := false
+		for _, dir := range selectedDirs {
+			if strings.HasPrefix(fe.Path, dir+"/") {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		if !matchShareFile(fe, include) {
+			if !fe.IsFolder {
+				skipped = append(skipped, fe)
+			}
+			continue
+		}
+		selected = append(selected, fe)
+		if fe.IsFolder {
+			selectedDirs = append(selectedDirs, fe.Path)
+		}
+	}
+	return
+}
+
+// shareBatchTaskInfoList 转存任务的文件列表
+func shareBatchTaskInfoList(files ShareFileList) BatchTaskInfoList {
+	infoList := BatchTaskInfoList{}
+	for _, fe := range files {
+		isFolder := 0
+		if fe.IsFolder {
+			isFolder = 1
+		}
+		infoList = append(infoList, &BatchTaskInfo{
+			FileId: fe.FileId,
+			FileName: fe.FileName,
+			IsFolder: isFolder,
+		})
+	}
+	return infoList
+}
+
+// groupShareFilesByDir 按文件在分享中的上级文件夹分组，分享根目录为空，文件夹按第一次出现的顺序排列
+func groupShareFilesByDir(files ShareFileList) ([]string, map[string]ShareFileList) {
+	dirs := []string{}
+	groups := map[string]ShareFileList{}
+	for _, fe := range files {
+		dir := strings.TrimPrefix(path.Dir(path.Clean("/"+fe.Path)), "/")
+		if _, ok := groups[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		groups[dir] = append(groups[dir], fe)
+	}
+	return dirs, groups
+}
+
+// shareSaveFolderId 获取 rootId 下相对路径 dir 对应的个人云文件夹ID，不存在则创建，结果缓存在 folderIds 中
+func (p *PanClient) shareSaveFolderId(folderIds map[string]string, rootId, dir string) (string, *apierror.ApiError) {
+	if dir == "" {
+		return rootId, nil
+	}
+	if id, ok := folderIds[dir]; ok {
+		return id, nil
+	}
+	parentId, apiErr := p.shareSaveFolderId(folderIds, rootId, strings.TrimPrefix(path.Dir("/"+dir), "/"))
+	if apiErr != nil {
+		return "", apiErr
+	}
+	name := path.Base(dir)
+	children, apiErr := p.appListFolder(0, &AppFileEntity{FileId: parentId})
+	if apiErr != nil {
+		return "", apiErr
+	}
+	if fe := children.findByName(name); fe != nil && fe.IsFolder {
+		folderIds[dir] = fe.FileId
+		return fe.FileId, nil
+	}
+	r, apiErr := p.AppMkdir(0, parentId, name)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	folderIds[dir] = r.FileId
+	return r.FileId, nil
+}
+
+// ShareSaveWithParam 转存分享，会获取分享的所有分页，可以只转存部分文件，并等待转存任务结束
+func (p *PanClient) ShareSaveWithParam(ctx context.Context, param *ShareSaveParam) (*ShareSaveResult, *apierror.ApiError) {
+	info, apiErr := p.ShareInfoByUrl(param.AccessUrl, param.AccessCode)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	result := &ShareSaveResult{
+		Info: info,
+		Skipped: ShareFileList{},
+		Summary: &BatchTaskSummary{},
+	}
+
+	if len(param.Include) == 0 {
+		list, apiErr := p.ShareListAllDir(info, "")
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result.Saved = list
+	} else {
+		files, apiErr := p.ShareFilesRecurseList(info)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result.Saved, result.Skipped = selectShareFiles(files, param.Include)
+	}
+	if len(result.Saved) == 0 {
+		return result, nil
+	}
+
+	dirs, groups := groupShareFilesByDir(result.Saved)
+	folderIds := map[string]string{}
+	for _, dir := range dirs {
+		targetId, apiErr := p.shareSaveFolderId(folderIds, param.SavePanDirId, dir)
+		if apiErr != nil {
+			return result, apiErr
+		}
+		for _, chunk := range splitBatchTaskInfoList(shareBatchTaskInfoList(groups[dir]), BatchTaskMaxFileCount) {
+			r, apiErr := p.ExecuteBatchTask(ctx, &BatchTaskParam{
+				TypeFlag: BatchTaskTypeShareSave,
+				TaskInfos: chunk,
+				TargetFolderId: targetId,
+				ShareId: info.ShareId,
+			}, param.Conflict)
+			if r != nil {
+				result.Summary.add(r.TaskId, r)
+			}
+			if apiErr != nil {
+				return result, apiErr
+			}
+		}
+	}
+	return result, nil
+}
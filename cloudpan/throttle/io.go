@@ -0,0 +1,127 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"context"
+	"io"
+)
+
+type (
+	reader struct {
+		ctx      context.Context
+		r        io.Reader
+		limiters []*Limiter
+	}
+
+	readCloser struct {
+		reader
+		c io.Closer
+	}
+
+	writer struct {
+		ctx      context.Context
+		w        io.Writer
+		limiters []*Limiter
+	}
+)
+
+const (
+	// maxChunk 每次读写的最大字节数，避免一次读取大量数据造成速度波动
+	maxChunk = 32 * 1024
+)
+
+func activeLimiters(limiters []*Limiter) []*Limiter {
+	active := []*Limiter{}
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	return active
+}
+
+func waitAll(ctx context.Context, limiters []*Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewReader 限速读取，同时受所有 limiters 限制，nil 的 limiter 会被忽略
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	active := activeLimiters(limiters)
+	if len(active) == 0 {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiters: active}
+}
+
+// NewReadCloser 限速读取，关闭时关闭 rc
+func NewReadCloser(ctx context.Context, rc io.ReadCloser, limiters ...*Limiter) io.ReadCloser {
+	active := activeLimiters(limiters)
+	if len(active) == 0 {
+		return rc
+	}
+	return &readCloser{reader: reader{ctx: ctx, r: rc, limiters: active}, c: rc}
+}
+
+// NewWriter 限速写入，同时受所有 limiters 限制，nil 的 limiter 会被忽略
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	active := activeLimiters(limiters)
+	if len(active) == 0 {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, limiters: active}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := waitAll(r.ctx, r.limiters, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (rc *readCloser) Close() error {
+	return rc.c.Close()
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+		if err := waitAll(w.ctx, w.limiters, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
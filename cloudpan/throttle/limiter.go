@@ -0,0 +1,108 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package throttle 上传下载限速，令牌桶实现，支持运行时修改速度以及按时间段限速
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Limiter 令牌桶限速器，多个数据流共用一个 Limiter 时限制的是总速度，可以并发使用
+	Limiter struct {
+		mu       sync.Mutex
+		rate     int64
+		schedule Schedule
+		tokens   float64
+		last     time.Time
+		now      func() time.Time
+	}
+)
+
+// NewLimiter 创建限速器，rate 为每秒字节数，<=0 不限速
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{
+		rate: rate,
+		now:  time.Now,
+	}
+}
+
+// SetRate 修改基础速度，<=0 不限速
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+}
+
+// SetSchedule 设置分时段限速，时间段内使用规则的速度，其他时间使用基础速度
+func (l *Limiter) SetSchedule(schedule Schedule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = schedule
+}
+
+// Rate 当前生效的速度，<=0 表示不限速
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.schedule.RateAt(l.now(), l.rate)
+}
+
+// reserve 预留 n 个字节的令牌，返回需要等待的时间。令牌不足时允许透支，由后续的请求等待
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	rate := l.schedule.RateAt(now, l.rate)
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		return 0
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	// 最多积攒1秒的令牌
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+}
+
+// WaitN 等待直到可以传输 n 个字节，ctx 结束时返回 ctx.Err()
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	d := l.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// Clock 一天中的时间，从0点开始的分钟数
+	Clock int
+
+	// ScheduleRule 时间段限速规则，Start > End 表示跨过0点，例如 22:00-06:00
+	ScheduleRule struct {
+		Start Clock
+		End   Clock
+		// Weekdays 生效的星期，为空每天都生效。跨过0点的规则按开始的那天判断
+		Weekdays []time.Weekday
+		// Rate 时间段内的速度，每秒字节数，<=0 不限速
+		Rate int64
+	}
+
+	// Schedule 分时段限速，按顺序匹配，第一条匹配的规则生效
+	Schedule []*ScheduleRule
+)
+
+// ParseClock 解析 HH:MM 格式的时间
+func ParseClock(s string) (Clock, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("时间格式错误: %s", s)
+	}
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("时间格式错误: %s", s)
+	}
+	return Clock(h*60 + m), nil
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", int(c)/60, int(c)%60)
+}
+
+func clockOf(t time.Time) Clock {
+	return Clock(t.Hour()*60 + t.Minute())
+}
+
+func (r *ScheduleRule) matchWeekday(d time.Weekday) bool {
+	if len(r.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range r.Weekdays {
+		if w == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 规则在 t 时是否生效
+func (r *ScheduleRule) Match(t time.Time) bool {
+	c := clockOf(t)
+	if r.Start <= r.End {
+		return c >= r.Start && c < r.End && r.matchWeekday(t.Weekday())
+	}
+	// 跨过0点
+	if c >= r.Start {
+		return r.matchWeekday(t.Weekday())
+	}
+	if c < r.End {
+		return r.matchWeekday(t.AddDate(0, 0, -1).Weekday())
+	}
+	return false
+}
+
+// RateAt t 时生效的速度，没有匹配的规则时返回 defaultRate
+func (s Schedule) RateAt(t time.Time, defaultRate int64) int64 {
+	for _, r := range s {
+		if r.Match(t) {
+			return r.Rate
+		}
+	}
+	return defaultRate
+}
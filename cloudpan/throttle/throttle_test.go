@@ -0,0 +1,109 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.Local)
+	l := NewLimiter(1000)
+	l.now = func() time.Time { return now }
+
+	// 透支的部分需要等待
+	assert.Equal(t, time.Duration(0), l.reserve(0))
+	assert.Equal(t, 500*time.Millisecond, l.reserve(500))
+	assert.Equal(t, 1500*time.Millisecond, l.reserve(1000))
+
+	// 1.5秒之后令牌刚好补足
+	now = now.Add(1500 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), l.reserve(0))
+	// 空闲很久最多积攒1秒的令牌
+	now = now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), l.reserve(1000))
+	assert.Equal(t, 100*time.Millisecond, l.reserve(100))
+
+	l.SetRate(0)
+	assert.Equal(t, time.Duration(0), l.reserve(1<<30))
+}
+
+func TestSchedule(t *testing.T) {
+	workStart, _ := ParseClock("09:00")
+	workEnd, _ := ParseClock("18:00")
+	nightStart, _ := ParseClock("22:30")
+	nightEnd, _ := ParseClock("06:00")
+	_, err := ParseClock("25:00")
+	assert.NotNil(t, err)
+	assert.Equal(t, "22:30", nightStart.String())
+
+	s := Schedule{
+		{Start: workStart, End: workEnd, Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, Rate: 100},
+		{Start: nightStart, End: nightEnd, Rate: 0},
+	}
+	at := func(day, hour, min int) time.Time {
+		// 2021-05-31 是星期一
+		return time.Date(2021, 5, 31+day, hour, min, 0, 0, time.Local)
+	}
+	assert.Equal(t, int64(100), s.RateAt(at(0, 10, 0), 500))
+	assert.Equal(t, int64(500), s.RateAt(at(0, 18, 0), 500))
+	assert.Equal(t, int64(500), s.RateAt(at(5, 10, 0), 500))
+	assert.Equal(t, int64(0), s.RateAt(at(0, 23, 0), 500))
+	assert.Equal(t, int64(0), s.RateAt(at(1, 5, 59), 500))
+	assert.Equal(t, int64(500), s.RateAt(at(1, 6, 0), 500))
+
+	l := NewLimiter(500)
+	l.SetSchedule(s)
+	l.now = func() time.Time { return at(0, 10, 0) }
+	assert.Equal(t, int64(100), l.Rate())
+}
+
+func TestReaderWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100*1024)
+	// 没有限速器直接返回原始的 Reader
+	r := bytes.NewReader(data)
+	assert.Equal(t, io.Reader(r), NewReader(context.Background(), r, nil))
+
+	l := NewLimiter(0)
+	out, err := ioutil.ReadAll(NewReader(context.Background(), bytes.NewReader(data), l))
+	assert.Nil(t, err)
+	assert.Equal(t, data, out)
+
+	buf := &bytes.Buffer{}
+	n, err := NewWriter(context.Background(), buf, l).Write(data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, data, buf.Bytes())
+
+	// 取消时停止等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewLimiter(1)
+	_, err = NewWriter(ctx, ioutil.Discard, slow).Write(data)
+	assert.Equal(t, context.Canceled, err)
+
+	// 限速 200KB/s 读取 100KB，大约需要0.5秒
+	start := time.Now()
+	_, err = ioutil.ReadAll(NewReader(context.Background(), bytes.NewReader(data), NewLimiter(200*1024)))
+	assert.Nil(t, err)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 400*time.Millisecond, elapsed.String())
+}
@@ -0,0 +1,303 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"errors"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"github.com/phpc0de/ctlibgo/logger"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type (
+	// FolderDownloadOption 文件夹下载参数
+	FolderDownloadOption struct {
+		// FamilyId 家庭云ID，个人云为0
+		FamilyId int64
+		// PanDir 云盘文件夹绝对路径，文件夹下的内容下载到 LocalDir 中
+		PanDir string
+		// LocalDir 本地文件夹路径，不存在会自动创建
+		LocalDir string
+		// Parallel 同时下载的文件数，默认为 DefaultMaxConcurrent
+		Parallel int
+		// StateFile 下载状态文件路径，为空则不保存。中断后使用相同的状态文件再次下载，
+		// 已完成的文件不再计算MD5，未完成的文件从中断的位置继续下载
+		StateFile string
+		// OnResult 每个文件处理完成的回调，会在多个 goroutine 中调用，调用之间是串行的
+		OnResult func(r *FolderResult)
+	}
+
+	// downloadJob 下载单个文件的任务
+	downloadJob struct {
+		fe     *cloudpan.AppFileEntity
+		result *FolderResult
+	}
+
+	folderDownloader struct {
+		ctx    context.Context
+		client *cloudpan.PanClient
+		opt    *FolderDownloadOption
+		state  *FolderState
+
+		resultLocker sync.Mutex
+	}
+)
+
+// DownloadFolder 下载云盘文件夹，在本地创建相同的文件夹结构，文件并发下载，
+// 本地已存在并且MD5一致的文件会跳过，下载完成的文件和文件夹的修改时间设置为云盘上的修改时间。
+// 单个文件失败不会中断下载，失败原因记录在报告中；遍历云盘文件夹失败时返回已处理文件的报告和 error
+func DownloadFolder(ctx context.Context, client *cloudpan.PanClient, opt *FolderDownloadOption) (*FolderReport, error) {
+	if opt.LocalDir == "" || opt.PanDir == "" {
+		return nil, errors.New("本地文件夹和云盘文件夹不能为空")
+	}
+	if !path.IsAbs(opt.PanDir) {
+		return nil, errors.New("云盘文件夹必须是绝对路径")
+	}
+	o := *opt
+	o.PanDir = path.Clean(o.PanDir)
+	if o.Parallel <= 0 {
+		o.Parallel = DefaultMaxConcurrent
+	}
+	root, apiErr := client.AppFileInfoByPath(o.FamilyId, o.PanDir)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if !root.IsFolder {
+		return nil, errors.New("云盘路径不是文件夹: " + o.PanDir)
+	}
+	state, err := LoadFolderState(o.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	if state.PanDir != "" && state.PanDir != o.PanDir {
+		return nil, errors.New("状态文件属于另一个云盘文件夹: " + state.PanDir)
+	}
+	state.PanDir = o.PanDir
+	if err := os.MkdirAll(o.LocalDir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &folderDownloader{
+		ctx: ctx,
+		client: client,
+		opt: &o,
+		state: state,
+	}
+	report, err := d.download()
+	if serr := state.Save(); serr != nil && err == nil {
+		err = serr
+	}
+	return report, err
+}
+
+// relPathOf 云盘文件相对于 panDir 的路径
+func relPathOf(panDir, panPath string) (string, bool) {
+	panPath = path.Clean(panPath)
+	if panDir == "/" {
+		return strings.TrimPrefix(panPath, "/"), panPath != "/"
+	}
+	if !strings.HasPrefix(panPath, panDir+"/") {
+		return "", false
+	}
+	return panPath[len(panDir)+1:], true
+}
+
+func (d *folderDownloader) finish(r *FolderResult) {
+	if d.opt.OnResult == nil {
+		return
+	}
+	d.resultLocker.Lock()
+	defer d.resultLocker.Unlock()
+	d.opt.OnResult(r)
+}
+
+func (d *folderDownloader) newResult(fe *cloudpan.AppFileEntity) *FolderResult {
+	r := &FolderResult{
+		PanPath: fe.Path,
+		IsDir: fe.IsFolder,
+		Size: fe.FileSize,
+		FileId: fe.FileId,
+	}
+	rel, ok := relPathOf(d.opt.PanDir, fe.Path)
+	if !ok {
+		r.Status = StatusFailed
+		r.Reason = "无法确定文件的相对路径"
+		return r
+	}
+	r.RelPath = rel
+	r.LocalPath = filepath.Join(d.opt.LocalDir, filepath.FromSlash(rel))
+	return r
+}
+
+// download 遍历云盘文件夹，文件交给并发的下载 goroutine，遍历结束后创建空文件夹并设置文件夹的修改时间
+func (d *folderDownloader) download() (*FolderReport, error) {
+	report := &FolderReport{}
+	jobs := make(chan *downloadJob)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < d.opt.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				d.downloadFile(job)
+				d.finish(job.result)
+			}
+		}()
+	}
+
+	var walkErr error
+	list := d.client.AppFilesDirectoriesRecurseList(d.opt.FamilyId, d.opt.PanDir, func(depth int, fdPath string, fd *cloudpan.AppFileEntity, apiErr *apierror.ApiError) bool {
+		if apiErr != nil {
+			walkErr = apiErr
+			return false
+		}
+		if err := d.ctx.Err(); err != nil {
+			walkErr = err
+			return false
+		}
+		r := d.newResult(fd)
+		report.Results = append(report.Results, r)
+		if r.Status != "" {
+			d.finish(r)
+			return true
+		}
+		jobs <- &downloadJob{fe: fd, result: r}
+		return true
+	})
+	close(jobs)
+	wg.Wait()
+	if walkErr == nil && list == nil {
+		walkErr = errors.New("遍历云盘文件夹失败")
+	}
+
+	// 文件下载完成后再设置文件夹的修改时间，否则会被文件的写入改变
+	for _, fe := range list {
+		if !fe.IsFolder {
+			continue
+		}
+		r := d.newResult(fe)
+		report.Results = append(report.Results, r)
+		if r.Status == "" {
+			if err := os.MkdirAll(r.LocalPath, 0755); err != nil {
+				r.Status = StatusFailed
+				r.Reason = err.Error()
+			} else {
+				r.Status = StatusFolder
+				if modTime := apiutil.ParseTimeStr(fe.LastOpTime); !modTime.IsZero() {
+					os.Chtimes(r.LocalPath, modTime, modTime)
+				}
+			}
+		}
+		d.finish(r)
+	}
+	return report, walkErr
+}
+
+// localUpToDate 本地文件是否已经和云盘文件一致，状态文件记录已完成并且本地文件没有修改时不计算MD5
+func localUpToDate(ctx context.Context, fe *cloudpan.AppFileEntity, localPath string, entry *FolderStateEntry) (bool, string, error) {
+	info, err := os.Stat(localPath)
+	if err != nil || info.IsDir() || info.Size() != fe.FileSize {
+		return false, "", nil
+	}
+	if entry != nil && entry.Done && entry.FileId == fe.FileId && strings.EqualFold(entry.Md5, fe.FileMd5) &&
+		entry.LocalModTime == info.ModTime().Unix() {
+		return true, "已下载", nil
+	}
+	if fe.FileMd5 == "" {
+		return false, "", nil
+	}
+	md5, err := localFileMd5(ctx, localPath)
+	if err != nil {
+		return false, "", err
+	}
+	if strings.EqualFold(md5, fe.FileMd5) {
+		return true, "MD5一致", nil
+	}
+	return false, "", nil
+}
+
+func (d *folderDownloader) downloadFile(job *downloadJob) {
+	fe := job.fe
+	r := job.result
+	fail := func(err error) {
+		r.Status = StatusFailed
+		r.Reason = err.Error()
+	}
+	if err := d.ctx.Err(); err != nil {
+		fail(err)
+		return
+	}
+
+	entry := d.state.Get(r.RelPath)
+	same, reason, err := localUpToDate(d.ctx, fe, r.LocalPath, entry)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if same {
+		if entry == nil || !entry.Done {
+			if modTime := apiutil.ParseTimeStr(fe.LastOpTime); !modTime.IsZero() {
+				os.Chtimes(r.LocalPath, modTime, modTime)
+			}
+			d.markDone(r.RelPath, fe, r.LocalPath)
+		}
+		r.Status = StatusSkipped
+		r.Reason = reason
+		return
+	}
+
+	if entry != nil && !entry.Done && (entry.FileId != fe.FileId || !strings.EqualFold(entry.Md5, fe.FileMd5)) {
+		// 上次中断后云盘文件已经变了，临时文件不能继续下载
+		os.Remove(r.LocalPath + DownloadTmpSuffix)
+	}
+	d.setState(r.RelPath, &FolderStateEntry{
+		FileId: fe.FileId,
+		Md5: strings.ToUpper(fe.FileMd5),
+		Size: fe.FileSize,
+	})
+	if err := downloadPanFile(d.ctx, d.client, d.opt.FamilyId, fe, r.LocalPath, func(done, total int64) {}); err != nil {
+		fail(err)
+		return
+	}
+	d.markDone(r.RelPath, fe, r.LocalPath)
+	r.Status = StatusDownloaded
+}
+
+func (d *folderDownloader) markDone(rel string, fe *cloudpan.AppFileEntity, localPath string) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return
+	}
+	d.setState(rel, &FolderStateEntry{
+		FileId: fe.FileId,
+		Md5: strings.ToUpper(fe.FileMd5),
+		Size: fe.FileSize,
+		LocalModTime: info.ModTime().Unix(),
+		Done: true,
+	})
+}
+
+func (d *folderDownloader) setState(rel string, entry *FolderStateEntry) {
+	if err := d.state.Set(rel, entry); err != nil {
+		logger.Verboseln("save folder download state failed: ", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelPathOf(t *testing.T) {
+	rel, ok := relPathOf("/backup", "/backup/a/b.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "a/b.txt", rel)
+	rel, ok = relPathOf("/", "//a/b.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "a/b.txt", rel)
+	_, ok = relPathOf("/backup", "/backup2/a.txt")
+	assert.False(t, ok)
+	_, ok = relPathOf("/backup", "")
+	assert.False(t, ok)
+}
+
+func TestLocalUpToDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folderdownload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	writeTestFile(t, localPath, "hello")
+	ctx := context.Background()
+
+	// hello 的MD5
+	fe := &cloudpan.AppFileEntity{FileId: "1", FileSize: 5, FileMd5: "5D41402ABC4B2A76B9719D911017C592"}
+	same, reason, err := localUpToDate(ctx, fe, localPath, nil)
+	assert.Nil(t, err)
+	assert.True(t, same)
+	assert.Equal(t, "MD5一致", reason)
+
+	changed := &cloudpan.AppFileEntity{FileId: "1", FileSize: 5, FileMd5: "00000000000000000000000000000000"}
+	same, _, _ = localUpToDate(ctx, changed, localPath, nil)
+	assert.False(t, same)
+	bigger := &cloudpan.AppFileEntity{FileId: "1", FileSize: 6, FileMd5: fe.FileMd5}
+	same, _, _ = localUpToDate(ctx, bigger, localPath, nil)
+	assert.False(t, same)
+	same, _, _ = localUpToDate(ctx, fe, filepath.Join(dir, "none"), nil)
+	assert.False(t, same)
+
+	// 状态文件记录已完成并且本地文件没有修改时不计算MD5
+	info, _ := os.Stat(localPath)
+	entry := &FolderStateEntry{FileId: "1", Md5: changed.FileMd5, Size: 5, LocalModTime: info.ModTime().Unix(), Done: true}
+	same, reason, _ = localUpToDate(ctx, changed, localPath, entry)
+	assert.True(t, same)
+	assert.Equal(t, "已下载", reason)
+	modTime := info.ModTime().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(localPath, modTime, modTime))
+	same, _, _ = localUpToDate(ctx, changed, localPath, entry)
+	assert.False(t, same)
+}
+
+func TestFolderState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folderstate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state", "download.json")
+
+	s, err := LoadFolderState(statePath)
+	assert.Nil(t, err)
+	s.PanDir = "/backup"
+	assert.Nil(t, s.Set("a.txt", &FolderStateEntry{FileId: "1", Md5: "M", Size: 5, Done: true}))
+	assert.Nil(t, s.Set("b.txt", &FolderStateEntry{FileId: "2", Size: 7}))
+	assert.Nil(t, s.Save())
+
+	s2, err := LoadFolderState(statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, "/backup", s2.PanDir)
+	assert.True(t, s2.Get("a.txt").Done)
+	assert.False(t, s2.Get("b.txt").Done)
+	assert.Nil(t, s2.Get("c.txt"))
+}
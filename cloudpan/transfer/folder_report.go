@@ -0,0 +1,119 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+type (
+	// FileStatus 文件夹上传下载中单个文件的处理结果
+	FileStatus string
+
+	// FolderResult 单个文件或文件夹的上传或下载结果
+	FolderResult struct {
+		// RelPath 相对于上传下载的文件夹的路径，使用 / 分隔
+		RelPath string `json:"relPath"`
+		LocalPath string `json:"localPath"`
+		PanPath string `json:"panPath"`
+		IsDir bool `json:"isDir"`
+		Size int64 `json:"size"`
+		// FileId 云盘文件或文件夹ID
+		FileId string `json:"fileId"`
+		Status FileStatus `json:"status"`
+		// Reason 跳过或者失败的原因
+		Reason string `json:"reason"`
+	}
+
+	// FolderReport 文件夹上传下载报告
+	FolderReport struct {
+		Results []*FolderResult `json:"results"`
+	}
+)
+
+const (
+	// StatusUploaded 已上传
+	StatusUploaded FileStatus = "uploaded"
+	// StatusDownloaded 已下载
+	StatusDownloaded FileStatus = "downloaded"
+	// StatusFolder 文件夹已创建或者已存在
+	StatusFolder FileStatus = "folder"
+	// StatusSkipped 已跳过
+	StatusSkipped FileStatus = "skipped"
+	// StatusIgnored 匹配忽略规则
+	StatusIgnored FileStatus = "ignored"
+	// StatusFailed 失败
+	StatusFailed FileStatus = "failed"
+)
+
+// Count 统计指定状态的文件数
+func (r *FolderReport) Count(status FileStatus) int {
+	n := 0
+	for _, item := range r.Results {
+		if item.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed 失败的文件
+func (r *FolderReport) Failed() []*FolderResult {
+	list := []*FolderResult{}
+	for _, item := range r.Results {
+		if item.Status == StatusFailed {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// TransferredBytes 实际上传或下载的文件大小之和
+func (r *FolderReport) TransferredBytes() int64 {
+	var n int64
+	for _, item := range r.Results {
+		if item.Status == StatusUploaded || item.Status == StatusDownloaded {
+			n += item.Size
+		}
+	}
+	return n
+}
+
+// WriteCSV 导出报告为CSV
+func (r *FolderReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"relPath", "localPath", "panPath", "isDir", "size", "fileId", "status", "reason"}); err != nil {
+		return err
+	}
+	for _, item := range r.Results {
+		record := []string{
+			item.RelPath,
+			item.LocalPath,
+			item.PanPath,
+			strconv.FormatBool(item.IsDir),
+			strconv.FormatInt(item.Size, 10),
+			item.FileId,
+			string(item.Status),
+			item.Reason,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
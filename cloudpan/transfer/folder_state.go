@@ -0,0 +1,132 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// FolderStateEntry 文件夹下载中单个文件的状态
+	FolderStateEntry struct {
+		// FileId 云盘文件ID
+		FileId string `json:"fileId"`
+		// Md5 开始下载时云盘文件的MD5，大写
+		Md5 string `json:"md5"`
+		// Size 文件大小
+		Size int64 `json:"size"`
+		// LocalModTime 下载完成后本地文件的修改时间，unix时间戳
+		LocalModTime int64 `json:"localModTime"`
+		// Done 是否已下载完成
+		Done bool `json:"done"`
+	}
+
+	// FolderState 文件夹下载的状态文件，中断后再次下载时跳过已完成的文件，
+	// 并且判断未完成的临时文件是否还能继续下载
+	FolderState struct {
+		// PanDir 云盘文件夹路径
+		PanDir string `json:"panDir"`
+		// Files 相对路径 -> 文件状态
+		Files map[string]*FolderStateEntry `json:"files"`
+
+		path   string
+		locker sync.Mutex
+		// lastSave 上次保存的时间，unix纳秒
+		lastSave int64
+	}
+)
+
+const (
+	// folderStateSaveInterval 下载过程中保存状态文件的最小间隔
+	folderStateSaveInterval = 2 * time.Second
+)
+
+// LoadFolderState 加载文件夹下载的状态文件，文件不存在则返回空的状态
+func LoadFolderState(statePath string) (*FolderState, error) {
+	s := &FolderState{
+		Files: map[string]*FolderStateEntry{},
+		path: statePath,
+	}
+	if statePath == "" {
+		return s, nil
+	}
+	f, err := os.Open(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(s); err != nil {
+		return nil, err
+	}
+	if s.Files == nil {
+		s.Files = map[string]*FolderStateEntry{}
+	}
+	return s, nil
+}
+
+// Get 获取文件状态
+func (s *FolderState) Get(relPath string) *FolderStateEntry {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.Files[relPath]
+}
+
+// Set 设置文件状态，距离上次保存超过一定时间时保存状态文件
+func (s *FolderState) Set(relPath string, entry *FolderStateEntry) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.Files[relPath] = entry
+	if time.Duration(time.Now().UnixNano()-s.lastSave) < folderStateSaveInterval {
+		return nil
+	}
+	return s.save()
+}
+
+// Save 保存状态文件，先写入临时文件再替换，避免中断导致文件损坏
+func (s *FolderState) Save() error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.save()
+}
+
+func (s *FolderState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.lastSave = time.Now().UnixNano()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,416 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"errors"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// ExistPolicy 云盘上已存在同名文件时的处理策略
+	ExistPolicy int
+
+	// SymlinkPolicy 本地符号链接的处理策略
+	SymlinkPolicy int
+
+	// FolderUploadOption 文件夹上传参数
+	FolderUploadOption struct {
+		// FamilyId 家庭云ID，个人云为0
+		FamilyId int64
+		// LocalDir 本地文件夹路径，文件夹下的内容上传到 PanDir 中
+		LocalDir string
+		// PanDir 云盘文件夹绝对路径，不存在会自动创建
+		PanDir string
+		// Parallel 同时上传的文件数，默认为 DefaultMaxConcurrent
+		Parallel int
+		// ExistPolicy 已存在同名文件时的处理策略，默认为 ExistOverwrite
+		ExistPolicy ExistPolicy
+		// SkipSame 云盘上的同名文件大小和MD5都一致时跳过，优先于 ExistPolicy
+		SkipSame bool
+		// Symlink 符号链接的处理策略，默认为 SymlinkSkip
+		Symlink SymlinkPolicy
+		// IgnorePatterns .gitignore 语法的忽略规则，相对于 LocalDir
+		IgnorePatterns []string
+		// IgnoreFileName 每个文件夹下的忽略规则文件名，例如 .gitignore，为空不读取
+		IgnoreFileName string
+		// OnResult 每个文件处理完成的回调，会在多个 goroutine 中调用，调用之间是串行的
+		OnResult func(r *FolderResult)
+	}
+
+	// localEntry 扫描到的本地文件，status 不为空表示扫描时已经被忽略或跳过
+	localEntry struct {
+		relPath string
+		absPath string
+		isDir   bool
+		size    int64
+		status  FileStatus
+		reason  string
+	}
+
+	// localScanner 遍历本地文件夹，处理忽略规则和符号链接
+	localScanner struct {
+		opt     *FolderUploadOption
+		ignore  *IgnoreMatcher
+		entries []*localEntry
+	}
+
+	// uploadJob 上传单个文件的任务
+	uploadJob struct {
+		entry    *localEntry
+		parentId string
+		old      *cloudpan.AppFileEntity
+		result   *FolderResult
+	}
+
+	folderUploader struct {
+		ctx    context.Context
+		client *cloudpan.PanClient
+		opt    *FolderUploadOption
+
+		// folderIds 云盘文件夹相对路径 -> 文件夹ID
+		folderIds map[string]string
+		// children 云盘文件夹ID -> 文件夹下的文件，新建的文件夹为空
+		children map[string]map[string]*cloudpan.AppFileEntity
+
+		resultLocker sync.Mutex
+	}
+)
+
+const (
+	// ExistOverwrite 覆盖云盘上的同名文件
+	ExistOverwrite ExistPolicy = 1
+	// ExistRename 保留云盘上的同名文件，新上传的文件由云盘自动重命名
+	ExistRename ExistPolicy = 2
+	// ExistSkip 跳过云盘上已存在的文件
+	ExistSkip ExistPolicy = 3
+
+	// SymlinkSkip 跳过符号链接
+	SymlinkSkip SymlinkPolicy = 1
+	// SymlinkFollow 上传符号链接指向的文件或文件夹，指向上级文件夹的循环链接会被跳过
+	SymlinkFollow SymlinkPolicy = 2
+)
+
+// UploadFolder 上传本地文件夹，在云盘上创建相同的文件夹结构，文件夹只创建一次，文件并发上传。
+// 单个文件失败不会中断上传，失败原因记录在报告中；只有云盘目标文件夹无法创建或者本地文件夹无法读取时返回 error
+func UploadFolder(ctx context.Context, client *cloudpan.PanClient, opt *FolderUploadOption) (*FolderReport, error) {
+	if opt.LocalDir == "" || opt.PanDir == "" {
+		return nil, errors.New("本地文件夹和云盘文件夹不能为空")
+	}
+	if !path.IsAbs(opt.PanDir) {
+		return nil, errors.New("云盘文件夹必须是绝对路径")
+	}
+	o := *opt
+	if o.Parallel <= 0 {
+		o.Parallel = DefaultMaxConcurrent
+	}
+	if o.ExistPolicy == 0 {
+		o.ExistPolicy = ExistOverwrite
+	}
+	if o.Symlink == 0 {
+		o.Symlink = SymlinkSkip
+	}
+
+	scanner, err := scanLocalTree(&o)
+	if err != nil {
+		return nil, err
+	}
+	root, apiErr := client.AppMkdirAll(o.FamilyId, o.PanDir)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	u := &folderUploader{
+		ctx: ctx,
+		client: client,
+		opt: &o,
+		folderIds: map[string]string{"": root.FileId},
+		children: map[string]map[string]*cloudpan.AppFileEntity{},
+	}
+	return &FolderReport{Results: u.upload(scanner.entries)}, nil
+}
+
+// scanLocalTree 遍历本地文件夹，文件夹在它包含的文件之前
+func scanLocalTree(opt *FolderUploadOption) (*localScanner, error) {
+	info, err := os.Stat(opt.LocalDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("本地路径不是文件夹: " + opt.LocalDir)
+	}
+	s := &localScanner{
+		opt: opt,
+		ignore: NewIgnoreMatcher(opt.IgnorePatterns),
+	}
+	ancestors := map[string]bool{}
+	if real, err := filepath.EvalSymlinks(opt.LocalDir); err == nil {
+		ancestors[real] = true
+	}
+	if err := s.walk(opt.LocalDir, "", ancestors); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *localScanner) skip(rel, absPath string, isDir bool, status FileStatus, reason string) {
+	s.entries = append(s.entries, &localEntry{
+		relPath: rel,
+		absPath: absPath,
+		isDir: isDir,
+		status: status,
+		reason: reason,
+	})
+}
+
+func (s *localScanner) walk(absDir, rel string, ancestors map[string]bool) error {
+	if s.opt.IgnoreFileName != "" {
+		if err := s.ignore.AddFile(rel, filepath.Join(absDir, s.opt.IgnoreFileName)); err != nil {
+			return err
+		}
+	}
+	dir, err := os.Open(absDir)
+	if err != nil {
+		return err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		absPath := filepath.Join(absDir, name)
+		childRel := path.Join(rel, name)
+		info, err := os.Lstat(absPath)
+		if err != nil {
+			s.skip(childRel, absPath, false, StatusFailed, err.Error())
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if s.opt.Symlink != SymlinkFollow {
+				s.skip(childRel, absPath, false, StatusSkipped, "符号链接")
+				continue
+			}
+			if info, err = os.Stat(absPath); err != nil {
+				s.skip(childRel, absPath, false, StatusFailed, err.Error())
+				continue
+			}
+		}
+		if s.ignore.Match(childRel, info.IsDir()) {
+			s.skip(childRel, absPath, info.IsDir(), StatusIgnored, "匹配忽略规则")
+			continue
+		}
+
+		if info.IsDir() {
+			real, err := filepath.EvalSymlinks(absPath)
+			if err != nil {
+				s.skip(childRel, absPath, true, StatusFailed, err.Error())
+				continue
+			}
+			if ancestors[real] {
+				s.skip(childRel, absPath, true, StatusSkipped, "循环的符号链接")
+				continue
+			}
+			entry := &localEntry{relPath: childRel, absPath: absPath, isDir: true}
+			s.entries = append(s.entries, entry)
+			ancestors[real] = true
+			err = s.walk(absPath, childRel, ancestors)
+			delete(ancestors, real)
+			if err != nil {
+				// 子文件夹无法读取不影响其他文件，文件夹只记录一次
+				entry.status = StatusFailed
+				entry.reason = err.Error()
+			}
+		} else if info.Mode().IsRegular() {
+			s.entries = append(s.entries, &localEntry{relPath: childRel, absPath: absPath, size: info.Size()})
+		} else {
+			s.skip(childRel, absPath, false, StatusSkipped, "不是普通文件")
+		}
+	}
+	return nil
+}
+
+// parentRel 相对路径的上级文件夹，根目录为空
+func parentRel(rel string) string {
+	dir := path.Dir(rel)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// finish 记录处理结果并回调
+func (u *folderUploader) finish(r *FolderResult) {
+	if u.opt.OnResult == nil {
+		return
+	}
+	u.resultLocker.Lock()
+	defer u.resultLocker.Unlock()
+	u.opt.OnResult(r)
+}
+
+// listFolder 获取云盘文件夹下的文件，结果缓存
+func (u *folderUploader) listFolder(folderId string) (map[string]*cloudpan.AppFileEntity, error) {
+	if m, ok := u.children[folderId]; ok {
+		return m, nil
+	}
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = u.opt.FamilyId
+	param.FileId = folderId
+	r, apiErr := u.client.AppGetAllFileList(param)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	m := map[string]*cloudpan.AppFileEntity{}
+	for _, fe := range r.FileList {
+		m[fe.FileName] = fe
+	}
+	u.children[folderId] = m
+	return m, nil
+}
+
+// upload 按顺序创建文件夹，文件交给并发的上传 goroutine
+func (u *folderUploader) upload(entries []*localEntry) []*FolderResult {
+	results := make([]*FolderResult, 0, len(entries))
+	jobs := make(chan *uploadJob)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < u.opt.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				u.uploadFile(job)
+				u.finish(job.result)
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		r := &FolderResult{
+			RelPath: entry.relPath,
+			LocalPath: entry.absPath,
+			PanPath: path.Join(u.opt.PanDir, entry.relPath),
+			IsDir: entry.isDir,
+			Size: entry.size,
+		}
+		results = append(results, r)
+		if entry.status != "" {
+			r.Status = entry.status
+			r.Reason = entry.reason
+			u.finish(r)
+			continue
+		}
+		old, parentId, err := u.prepare(entry)
+		if err != nil {
+			r.Status = StatusFailed
+			r.Reason = err.Error()
+			u.finish(r)
+			continue
+		}
+		if entry.isDir {
+			r.Status = StatusFolder
+			r.FileId = u.folderIds[entry.relPath]
+			u.finish(r)
+			continue
+		}
+		jobs <- &uploadJob{entry: entry, parentId: parentId, old: old, result: r}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// prepare 获取上级文件夹ID和云盘上的同名文件，文件夹不存在则创建
+func (u *folderUploader) prepare(entry *localEntry) (*cloudpan.AppFileEntity, string, error) {
+	if err := u.ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	parentId, ok := u.folderIds[parentRel(entry.relPath)]
+	if !ok {
+		return nil, "", errors.New("上级文件夹创建失败")
+	}
+	children, err := u.listFolder(parentId)
+	if err != nil {
+		return nil, "", err
+	}
+	name := path.Base(entry.relPath)
+	old := children[name]
+	if !entry.isDir {
+		return old, parentId, nil
+	}
+
+	if old != nil {
+		if !old.IsFolder {
+			return nil, "", errors.New("云盘上存在同名文件")
+		}
+		u.folderIds[entry.relPath] = old.FileId
+		return old, parentId, nil
+	}
+	r, apiErr := u.client.AppMkdir(u.opt.FamilyId, parentId, name)
+	if apiErr != nil {
+		return nil, "", apiErr
+	}
+	u.folderIds[entry.relPath] = r.FileId
+	u.children[r.FileId] = map[string]*cloudpan.AppFileEntity{}
+	return nil, parentId, nil
+}
+
+func (u *folderUploader) uploadFile(job *uploadJob) {
+	r := job.result
+	old := job.old
+	fail := func(err error) {
+		r.Status = StatusFailed
+		r.Reason = err.Error()
+	}
+	if old != nil && old.IsFolder {
+		fail(errors.New("云盘上存在同名文件夹"))
+		return
+	}
+	if old != nil && u.opt.ExistPolicy == ExistSkip {
+		r.Status = StatusSkipped
+		r.Reason = "云盘上已存在"
+		r.FileId = old.FileId
+		return
+	}
+	md5, err := localFileMd5(u.ctx, job.entry.absPath)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if old != nil && u.opt.SkipSame && old.FileSize == job.entry.size && strings.EqualFold(old.FileMd5, md5) {
+		r.Status = StatusSkipped
+		r.Reason = "大小和MD5一致"
+		r.FileId = old.FileId
+		return
+	}
+
+	overwrite := u.opt.ExistPolicy == ExistOverwrite
+	fileId, err := uploadLocalFile(u.ctx, u.client, u.opt.FamilyId, job.parentId, path.Base(job.entry.relPath),
+		job.entry.absPath, md5, old, overwrite, func(done, total int64) {})
+	if err != nil {
+		fail(err)
+		return
+	}
+	r.Status = StatusUploaded
+	r.FileId = fileId
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, p, content string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.Nil(t, ioutil.WriteFile(p, []byte(content), 0644))
+}
+
+func scanSummary(s *localScanner) map[string]string {
+	m := map[string]string{}
+	for _, e := range s.entries {
+		v := "file"
+		if e.isDir {
+			v = "dir"
+		}
+		if e.status != "" {
+			v = string(e.status)
+		}
+		m[e.relPath] = v
+	}
+	return m
+}
+
+func TestScanLocalTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folderupload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a")
+	writeTestFile(t, filepath.Join(dir, "debug.log"), "log")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "b")
+	writeTestFile(t, filepath.Join(dir, "sub", "c.tmp"), "c")
+	writeTestFile(t, filepath.Join(dir, "sub", ".ctignore"), "*.tmp\n")
+	writeTestFile(t, filepath.Join(dir, "node_modules", "x.js"), "x")
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "empty"), 0755))
+	symlinks := os.Symlink(filepath.Join(dir, "a.txt"), filepath.Join(dir, "link.txt")) == nil
+	if symlinks {
+		assert.Nil(t, os.Symlink(dir, filepath.Join(dir, "sub", "loop")))
+	}
+
+	opt := &FolderUploadOption{
+		LocalDir: dir,
+		PanDir: "/backup",
+		IgnorePatterns: []string{"*.log", "node_modules/"},
+		IgnoreFileName: ".ctignore",
+		Symlink: SymlinkSkip,
+	}
+	s, err := scanLocalTree(opt)
+	assert.Nil(t, err)
+	summary := scanSummary(s)
+	assert.Equal(t, "file", summary["a.txt"])
+	assert.Equal(t, "ignored", summary["debug.log"])
+	assert.Equal(t, "ignored", summary["node_modules"])
+	assert.Equal(t, "", summary["node_modules/x.js"])
+	assert.Equal(t, "dir", summary["sub"])
+	assert.Equal(t, "file", summary["sub/b.txt"])
+	assert.Equal(t, "ignored", summary["sub/c.tmp"])
+	assert.Equal(t, "dir", summary["empty"])
+	// 文件夹在它包含的文件之前
+	for i, e := range s.entries {
+		if e.relPath == "sub/b.txt" {
+			assert.Equal(t, "sub", s.entries[i-2].relPath)
+		}
+	}
+
+	if symlinks {
+		assert.Equal(t, "skipped", summary["link.txt"])
+		assert.Equal(t, "skipped", summary["sub/loop"])
+
+		opt.Symlink = SymlinkFollow
+		s, err = scanLocalTree(opt)
+		assert.Nil(t, err)
+		summary = scanSummary(s)
+		assert.Equal(t, "file", summary["link.txt"])
+		// 指向上级文件夹的循环链接被跳过
+		assert.Equal(t, "skipped", summary["sub/loop"])
+	}
+
+	_, err = scanLocalTree(&FolderUploadOption{LocalDir: filepath.Join(dir, "a.txt")})
+	assert.NotNil(t, err)
+}
+
+func TestScanLocalTreeUnreadableFolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folderupload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a")
+	// 忽略规则文件是文件夹，读取失败
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "bad", ".ctignore"), 0755))
+
+	s, err := scanLocalTree(&FolderUploadOption{LocalDir: dir, IgnoreFileName: ".ctignore"})
+	assert.Nil(t, err)
+	count := 0
+	for _, e := range s.entries {
+		if e.relPath == "bad" {
+			count++
+			assert.Equal(t, StatusFailed, e.status)
+			assert.NotEqual(t, "", e.reason)
+		}
+	}
+	assert.Equal(t, 1, count)
+	assert.Equal(t, "file", scanSummary(s)["a.txt"])
+}
+
+func TestFolderReport(t *testing.T) {
+	r := &FolderReport{Results: []*FolderResult{
+		{RelPath: "a.txt", Size: 10, Status: StatusUploaded, FileId: "1"},
+		{RelPath: "b.txt", Size: 20, Status: StatusSkipped, Reason: "大小和MD5一致"},
+		{RelPath: "c.txt", Size: 30, Status: StatusFailed, Reason: "网络错误"},
+		{RelPath: "d", IsDir: true, Status: StatusFolder},
+	}}
+	assert.Equal(t, 1, r.Count(StatusUploaded))
+	assert.Equal(t, int64(10), r.TransferredBytes())
+	assert.Equal(t, 1, len(r.Failed()))
+	buf := &bytes.Buffer{}
+	assert.Nil(t, r.WriteCSV(buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 5, len(lines))
+	assert.Equal(t, "c.txt,,,false,30,,failed,网络错误", lines[3])
+}
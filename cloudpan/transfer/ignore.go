@@ -0,0 +1,189 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type (
+	// IgnoreMatcher .gitignore 语法的忽略规则，后面的规则优先，! 开头的规则重新包含之前忽略的文件
+	IgnoreMatcher struct {
+		rules []*ignoreRule
+	}
+
+	ignoreRule struct {
+		// base 规则所在的文件夹，相对路径，根目录为空
+		base    string
+		re      *regexp.Regexp
+		negate  bool
+		dirOnly bool
+	}
+)
+
+// NewIgnoreMatcher 创建忽略规则，patterns 的每一项相当于 .gitignore 文件中的一行
+func NewIgnoreMatcher(patterns []string) *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	m.AddPatterns("", patterns)
+	return m
+}
+
+// AddPatterns 添加 base 文件夹下的规则，base 为相对路径，规则只对 base 下的文件生效
+func (m *IgnoreMatcher) AddPatterns(base string, patterns []string) {
+	base = strings.Trim(base, "/")
+	for _, line := range patterns {
+		if rule := parseIgnoreRule(line); rule != nil {
+			rule.base = base
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// AddFile 读取 .gitignore 格式的文件，规则只对 base 下的文件生效，文件不存在时忽略
+func (m *IgnoreMatcher) AddFile(base, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	m.AddPatterns(base, lines)
+	return nil
+}
+
+// Match 相对路径 relPath 是否被忽略，使用 / 分隔
+func (m *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	relPath = strings.Trim(relPath, "/")
+	ignored := false
+	for _, rule := range m.rules {
+		sub := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			sub = relPath[len(rule.base)+1:]
+		}
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(sub) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreRule 解析一行规则，空行和注释返回 nil
+func parseIgnoreRule(line string) *ignoreRule {
+	line = strings.TrimRight(line, "\r")
+	// 行尾的空格被忽略，除非用 \ 转义
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	rule := &ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	// 包含 / 的规则相对于所在的文件夹，否则匹配任意层级的文件名
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil
+	}
+	rule.re = re
+	return rule
+}
+
+// globToRegexp 把 gitignore 的通配符转换为正则表达式
+func globToRegexp(glob string) string {
+	sb := &strings.Builder{}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				if atStart && i+2 < len(glob) && glob[i+2] == '/' {
+					// **/ 匹配0或多级文件夹
+					sb.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+				if atStart && i+2 == len(glob) {
+					// /** 匹配文件夹下的所有内容
+					sb.WriteString(".*")
+					i++
+					continue
+				}
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	m := NewIgnoreMatcher([]string{
+		"# 注释",
+		"",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/root.txt",
+		"docs/*.tmp",
+		"**/cache/**",
+		"a/**/z",
+		"\\#hash",
+		"file?.[ch]",
+		"trailing   ",
+	})
+	cases := []struct {
+		path   string
+		isDir  bool
+		ignore bool
+	}{
+		{"x.log", false, true},
+		{"sub/dir/x.log", false, true},
+		{"keep.log", false, false},
+		{"sub/keep.log", false, false},
+		{"build", true, true},
+		{"sub/build", true, true},
+		{"build", false, false},
+		{"root.txt", false, true},
+		{"sub/root.txt", false, false},
+		{"docs/a.tmp", false, true},
+		{"docs/sub/a.tmp", false, false},
+		{"cache/x", false, true},
+		{"p/cache/q/r", false, true},
+		{"cache", true, false},
+		{"a/z", false, true},
+		{"a/b/c/z", false, true},
+		{"#hash", false, true},
+		{"file1.c", false, true},
+		{"file1.go", false, false},
+		{"file12.c", false, false},
+		{"trailing", false, true},
+		{"文档.txt", false, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.ignore, m.Match(c.path, c.isDir), c.path)
+	}
+
+	// 子文件夹中的规则只对子文件夹生效
+	m.AddPatterns("sub", []string{"/only.txt", "!x.log"})
+	assert.True(t, m.Match("sub/only.txt", false))
+	assert.False(t, m.Match("only.txt", false))
+	assert.False(t, m.Match("sub/dir/x.log", false))
+	assert.True(t, m.Match("other/x.log", false))
+
+	var nilMatcher *IgnoreMatcher
+	assert.False(t, nilMatcher.Match("x.log", false))
+}
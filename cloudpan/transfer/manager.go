@@ -0,0 +1,491 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"errors"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctlibgo/logger"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// EventType 事件类型
+	EventType string
+
+	// Event 任务事件
+	Event struct {
+		Type EventType
+		// Task 事件发生时任务的副本
+		Task Task
+		// Speed 当前速度，字节/秒，只对进度事件有效
+		Speed int64
+		// ETA 预计剩余时间，速度未知时为0，只对进度事件有效
+		ETA time.Duration
+	}
+
+	// EventHandler 事件回调，在传输的 goroutine 中调用，不要在回调中长时间阻塞
+	EventHandler func(e *Event)
+
+	// Option 任务队列参数
+	Option struct {
+		// MaxConcurrent 最大同时传输的任务数，默认为 DefaultMaxConcurrent
+		MaxConcurrent int
+		// QueueFile 队列状态文件路径，为空则不保存，重启后未完成的任务会重新排队
+		QueueFile string
+		// ProgressInterval 进度事件的最小间隔，默认为 DefaultProgressInterval
+		ProgressInterval time.Duration
+		// OnEvent 事件回调
+		OnEvent EventHandler
+	}
+
+	// Manager 传输任务队列
+	Manager struct {
+		runner runner
+		opt    Option
+
+		locker  sync.Mutex
+		cond    *sync.Cond
+		tasks   map[string]*Task
+		seq     int64
+		running map[string]*runningTask
+		started bool
+	}
+
+	// runningTask 正在传输的任务
+	runningTask struct {
+		cancel    context.CancelFunc
+		lastEmit  time.Time
+		lastTime  time.Time
+		lastBytes int64
+		speed     float64
+	}
+)
+
+const (
+	// EventAdded 添加任务
+	EventAdded EventType = "added"
+	// EventStateChanged 任务状态改变
+	EventStateChanged EventType = "state"
+	// EventProgress 传输进度
+	EventProgress EventType = "progress"
+	// EventRemoved 删除任务
+	EventRemoved EventType = "removed"
+
+	// DefaultMaxConcurrent 默认最大同时传输的任务数
+	DefaultMaxConcurrent = 3
+	// DefaultProgressInterval 默认进度事件的最小间隔
+	DefaultProgressInterval = 500 * time.Millisecond
+)
+
+var (
+	// ErrTaskNotFound 任务不存在
+	ErrTaskNotFound = errors.New("任务不存在")
+	// ErrTaskRunning 任务正在传输，不能删除
+	ErrTaskRunning = errors.New("任务正在传输")
+	// ErrTaskState 任务当前的状态不支持该操作
+	ErrTaskState = errors.New("任务状态不支持该操作")
+)
+
+// NewManager 创建传输任务队列，加载 QueueFile 中保存的任务，调用 Start 后开始传输
+func NewManager(client *cloudpan.PanClient, opt *Option) (*Manager, error) {
+	return newManager(&panRunner{client: client}, opt)
+}
+
+func newManager(r runner, opt *Option) (*Manager, error) {
+	o := Option{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.MaxConcurrent <= 0 {
+		o.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if o.ProgressInterval <= 0 {
+		o.ProgressInterval = DefaultProgressInterval
+	}
+	q, err := loadQueue(o.QueueFile)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		runner: r,
+		opt: o,
+		tasks: map[string]*Task{},
+		seq: q.Seq,
+		running: map[string]*runningTask{},
+	}
+	m.cond = sync.NewCond(&m.locker)
+	for _, t := range q.Tasks {
+		if t.State == StateRunning {
+			// 上次退出时正在传输
+			t.State = StateQueued
+		}
+		m.tasks[t.Id] = t
+	}
+	return m, nil
+}
+
+// Start 开始执行队列中的任务
+func (m *Manager) Start() {
+	m.locker.Lock()
+	m.started = true
+	m.unlockAndEmit(m.schedule())
+}
+
+// Stop 停止执行任务，正在传输的任务中断后重新排队，等待所有传输停止并保存队列状态
+func (m *Manager) Stop() {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.started = false
+	for id, rt := range m.running {
+		m.tasks[id].stopState = StateQueued
+		rt.cancel()
+	}
+	for len(m.running) > 0 {
+		m.cond.Wait()
+	}
+	m.save()
+}
+
+// Wait 等待所有任务执行完成，暂停的任务不等待
+func (m *Manager) Wait() {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for len(m.running) > 0 || (m.started && m.hasQueued()) {
+		m.cond.Wait()
+	}
+}
+
+// Add 添加任务，返回任务ID。State 为 StatePaused 时添加为暂停状态，否则排队等待执行
+func (m *Manager) Add(task *Task) (string, error) {
+	if task.Kind != KindUpload && task.Kind != KindDownload {
+		return "", errors.New("未知的任务类型")
+	}
+	if task.LocalPath == "" {
+		return "", errors.New("本地文件路径不能为空")
+	}
+	if task.PanPath == "" && (task.Kind == KindUpload || task.FileId == "") {
+		return "", errors.New("云盘文件路径不能为空")
+	}
+
+	t := *task
+	t.Id = newTaskId()
+	if t.State != StatePaused {
+		t.State = StateQueued
+	}
+	t.Size = 0
+	t.Transferred = 0
+	t.Error = ""
+	t.CreateTime = time.Now().Unix()
+	t.FinishTime = 0
+
+	m.locker.Lock()
+	m.seq++
+	t.Seq = m.seq
+	m.tasks[t.Id] = &t
+	m.save()
+	events := []*Event{{Type: EventAdded, Task: t}}
+	m.unlockAndEmit(append(events, m.schedule()...))
+	return t.Id, nil
+}
+
+// Pause 暂停任务，正在传输的任务会被中断，下载任务继续时从中断的位置开始
+func (m *Manager) Pause(id string) error {
+	return m.stop(id, StatePaused)
+}
+
+// Cancel 取消任务，下载任务的临时文件会被删除
+func (m *Manager) Cancel(id string) error {
+	return m.stop(id, StateCanceled)
+}
+
+func (m *Manager) stop(id string, state State) error {
+	m.locker.Lock()
+	t, ok := m.tasks[id]
+	if !ok {
+		m.locker.Unlock()
+		return ErrTaskNotFound
+	}
+	if rt, ok := m.running[id]; ok {
+		t.stopState = state
+		rt.cancel()
+		m.locker.Unlock()
+		return nil
+	}
+	if t.IsFinished() || t.State == state {
+		m.locker.Unlock()
+		return ErrTaskState
+	}
+	discard := state == StateCanceled
+	events := m.setState(t, state)
+	m.save()
+	m.unlockAndEmit(events)
+	if discard {
+		m.runner.discard(*t)
+	}
+	return nil
+}
+
+// Resume 继续暂停的任务，或者重试失败的任务
+func (m *Manager) Resume(id string) error {
+	m.locker.Lock()
+	t, ok := m.tasks[id]
+	if !ok {
+		m.locker.Unlock()
+		return ErrTaskNotFound
+	}
+	if t.State != StatePaused && t.State != StateFailed {
+		m.locker.Unlock()
+		return ErrTaskState
+	}
+	t.Error = ""
+	events := m.setState(t, StateQueued)
+	m.save()
+	m.unlockAndEmit(append(events, m.schedule()...))
+	return nil
+}
+
+// Remove 从队列中删除任务，正在传输的任务需要先暂停或取消
+func (m *Manager) Remove(id string) error {
+	m.locker.Lock()
+	t, ok := m.tasks[id]
+	if !ok {
+		m.locker.Unlock()
+		return ErrTaskNotFound
+	}
+	if _, ok := m.running[id]; ok {
+		m.locker.Unlock()
+		return ErrTaskRunning
+	}
+	delete(m.tasks, id)
+	m.save()
+	m.unlockAndEmit([]*Event{{Type: EventRemoved, Task: *t}})
+	if !t.IsFinished() {
+		m.runner.discard(*t)
+	}
+	return nil
+}
+
+// ClearFinished 删除已完成和已取消的任务，返回删除的数量
+func (m *Manager) ClearFinished() int {
+	m.locker.Lock()
+	events := []*Event{}
+	for id, t := range m.tasks {
+		if t.State == StateCompleted || t.State == StateCanceled {
+			delete(m.tasks, id)
+			events = append(events, &Event{Type: EventRemoved, Task: *t})
+		}
+	}
+	if len(events) > 0 {
+		m.save()
+	}
+	m.unlockAndEmit(events)
+	return len(events)
+}
+
+// SetPriority 修改任务优先级，只影响还没开始传输的任务的执行顺序
+func (m *Manager) SetPriority(id string, priority int) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	t, ok := m.tasks[id]
+	if !ok {
+		return ErrTaskNotFound
+	}
+	t.Priority = priority
+	m.save()
+	return nil
+}
+
+// SetMaxConcurrent 修改最大同时传输的任务数，减少时正在传输的任务不会被中断
+func (m *Manager) SetMaxConcurrent(n int) {
+	if n <= 0 {
+		n = DefaultMaxConcurrent
+	}
+	m.locker.Lock()
+	m.opt.MaxConcurrent = n
+	m.unlockAndEmit(m.schedule())
+}
+
+// Task 获取任务的副本
+func (m *Manager) Task(id string) (*Task, bool) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	t, ok := m.tasks[id]
+	if !ok {
+		return nil, false
+	}
+	c := *t
+	return &c, true
+}
+
+// Tasks 获取所有任务的副本，按执行顺序排列
+func (m *Manager) Tasks() []*Task {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	list := m.sortedTasks()
+	r := make([]*Task, 0, len(list))
+	for _, t := range list {
+		c := *t
+		r = append(r, &c)
+	}
+	return r
+}
+
+// sortedTasks 按优先级从高到低、添加顺序从先到后排列，调用方持有锁
+func (m *Manager) sortedTasks() []*Task {
+	list := make([]*Task, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Priority != list[j].Priority {
+			return list[i].Priority > list[j].Priority
+		}
+		return list[i].Seq < list[j].Seq
+	})
+	return list
+}
+
+func (m *Manager) hasQueued() bool {
+	for _, t := range m.tasks {
+		if t.State == StateQueued {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) setState(t *Task, state State) []*Event {
+	t.State = state
+	return []*Event{{Type: EventStateChanged, Task: *t}}
+}
+
+// schedule 启动排队的任务直到达到最大并发数，调用方持有锁
+func (m *Manager) schedule() []*Event {
+	events := []*Event{}
+	if !m.started {
+		return events
+	}
+	for _, t := range m.sortedTasks() {
+		if len(m.running) >= m.opt.MaxConcurrent {
+			break
+		}
+		if t.State != StateQueued {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		rt := &runningTask{cancel: cancel}
+		m.running[t.Id] = rt
+		events = append(events, m.setState(t, StateRunning)...)
+		go m.run(ctx, rt, *t)
+	}
+	if len(events) > 0 {
+		m.save()
+	}
+	return events
+}
+
+func (m *Manager) run(ctx context.Context, rt *runningTask, t Task) {
+	fileId, err := m.runner.run(ctx, t, func(done, total int64) {
+		m.progress(t.Id, rt, done, total)
+	})
+	rt.cancel()
+
+	m.locker.Lock()
+	task := m.tasks[t.Id]
+	delete(m.running, t.Id)
+	discard := false
+	var events []*Event
+	if err == nil {
+		task.FileId = fileId
+		task.Error = ""
+		task.FinishTime = time.Now().Unix()
+		events = m.setState(task, StateCompleted)
+	} else if task.stopState != "" {
+		discard = task.stopState == StateCanceled
+		events = m.setState(task, task.stopState)
+	} else {
+		logger.Verboseln("transfer task failed: ", t.Id, " ", err)
+		task.Error = err.Error()
+		events = m.setState(task, StateFailed)
+	}
+	task.stopState = ""
+	m.save()
+	events = append(events, m.schedule()...)
+	m.cond.Broadcast()
+	m.unlockAndEmit(events)
+	if discard {
+		m.runner.discard(t)
+	}
+}
+
+func (m *Manager) progress(id string, rt *runningTask, done, total int64) {
+	now := time.Now()
+	m.locker.Lock()
+	t, ok := m.tasks[id]
+	if !ok {
+		m.locker.Unlock()
+		return
+	}
+	t.Size = total
+	t.Transferred = done
+	if rt.lastTime.IsZero() {
+		// 继续下载时从已下载的位置开始计算速度
+		rt.lastTime = now
+		rt.lastBytes = done
+	}
+	if now.Sub(rt.lastEmit) < m.opt.ProgressInterval && done < total {
+		m.locker.Unlock()
+		return
+	}
+	if elapsed := now.Sub(rt.lastTime).Seconds(); elapsed > 0 {
+		speed := float64(done-rt.lastBytes) / elapsed
+		if rt.speed > 0 {
+			// 平滑速度的波动
+			speed = rt.speed*0.7 + speed*0.3
+		}
+		rt.speed = speed
+		rt.lastTime = now
+		rt.lastBytes = done
+	}
+	rt.lastEmit = now
+	e := &Event{Type: EventProgress, Task: *t, Speed: int64(rt.speed)}
+	if rt.speed >= 1 && total > done {
+		e.ETA = time.Duration(float64(total-done) / rt.speed * float64(time.Second))
+	}
+	m.unlockAndEmit([]*Event{e})
+}
+
+// unlockAndEmit 释放锁后发送事件，避免回调中调用 Manager 的方法造成死锁
+func (m *Manager) unlockAndEmit(events []*Event) {
+	m.locker.Unlock()
+	if m.opt.OnEvent == nil {
+		return
+	}
+	for _, e := range events {
+		m.opt.OnEvent(e)
+	}
+}
+
+// save 保存队列状态，调用方持有锁
+func (m *Manager) save() {
+	q := &queueFile{Seq: m.seq, Tasks: m.sortedTasks()}
+	if err := saveQueue(m.opt.QueueFile, q); err != nil {
+		logger.Verboseln("save transfer queue failed: ", err)
+	}
+}
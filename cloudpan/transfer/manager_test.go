@@ -0,0 +1,189 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunner 模拟传输，block=true 时一直传输直到被中断
+type fakeRunner struct {
+	locker    sync.Mutex
+	block     bool
+	order     []string
+	discarded []string
+	started   chan string
+}
+
+func newFakeRunner(block bool) *fakeRunner {
+	return &fakeRunner{block: block, started: make(chan string, 16)}
+}
+
+func (r *fakeRunner) run(ctx context.Context, t Task, progress progressFunc) (string, error) {
+	r.locker.Lock()
+	r.order = append(r.order, t.LocalPath)
+	block := r.block
+	r.locker.Unlock()
+	r.started <- t.Id
+	progress(0, 100)
+	if block {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	progress(100, 100)
+	return "file-" + t.LocalPath, nil
+}
+
+func (r *fakeRunner) discard(t Task) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.discarded = append(r.discarded, t.Id)
+}
+
+func (r *fakeRunner) setBlock(block bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.block = block
+}
+
+func waitState(t *testing.T, m *Manager, id string, state State) {
+	for i := 0; i < 200; i++ {
+		if task, _ := m.Task(id); task.State == state {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	task, _ := m.Task(id)
+	t.Fatalf("task %s state %s, want %s", id, task.State, state)
+}
+
+func TestManagerPriority(t *testing.T) {
+	r := newFakeRunner(false)
+	events := []*Event{}
+	var locker sync.Mutex
+	m, err := newManager(r, &Option{MaxConcurrent: 1, OnEvent: func(e *Event) {
+		locker.Lock()
+		defer locker.Unlock()
+		events = append(events, e)
+	}})
+	assert.Nil(t, err)
+
+	_, err = m.Add(&Task{Kind: KindUpload, LocalPath: "a"})
+	assert.NotNil(t, err)
+	idA, _ := m.Add(NewUploadTask(0, "a", "/a", false))
+	idB, _ := m.Add(NewDownloadTask(0, "", "/b", "b"))
+	idC, _ := m.Add(NewUploadTask(1, "c", "/c", false))
+	assert.Nil(t, m.SetPriority(idC, 10))
+	m.Start()
+	m.Wait()
+
+	assert.Equal(t, []string{"c", "a", "b"}, r.order)
+	for _, id := range []string{idA, idB, idC} {
+		task, ok := m.Task(id)
+		assert.True(t, ok)
+		assert.Equal(t, StateCompleted, task.State)
+		assert.Equal(t, "file-"+task.LocalPath, task.FileId)
+		assert.Equal(t, int64(100), task.Transferred)
+	}
+
+	locker.Lock()
+	progress := 0
+	for _, e := range events {
+		if e.Type == EventProgress && e.Task.Transferred == 100 {
+			progress++
+		}
+	}
+	locker.Unlock()
+	assert.Equal(t, 3, progress)
+
+	assert.Equal(t, 3, m.ClearFinished())
+	assert.Equal(t, 0, len(m.Tasks()))
+}
+
+func TestManagerPauseCancel(t *testing.T) {
+	r := newFakeRunner(true)
+	m, _ := newManager(r, &Option{MaxConcurrent: 1})
+	m.Start()
+
+	id1, _ := m.Add(NewDownloadTask(0, "f1", "/1", "1"))
+	id2, _ := m.Add(NewDownloadTask(0, "f2", "/2", "2"))
+	<-r.started
+	waitState(t, m, id1, StateRunning)
+	assert.Equal(t, ErrTaskRunning, m.Remove(id1))
+
+	// 暂停正在传输的任务，下一个任务开始
+	assert.Nil(t, m.Pause(id1))
+	waitState(t, m, id1, StatePaused)
+	<-r.started
+	waitState(t, m, id2, StateRunning)
+	assert.Equal(t, ErrTaskState, m.Pause(id1))
+
+	// 取消正在传输的任务会清理临时文件
+	assert.Nil(t, m.Cancel(id2))
+	waitState(t, m, id2, StateCanceled)
+	assert.Equal(t, ErrTaskState, m.Resume(id2))
+
+	r.setBlock(false)
+	assert.Nil(t, m.Resume(id1))
+	m.Wait()
+	waitState(t, m, id1, StateCompleted)
+	assert.Equal(t, []string{id2}, r.discarded)
+	assert.Equal(t, ErrTaskNotFound, m.Pause("none"))
+}
+
+func TestManagerPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	queueFile := filepath.Join(dir, "queue.json")
+
+	r := newFakeRunner(true)
+	m, _ := newManager(r, &Option{MaxConcurrent: 1, QueueFile: queueFile})
+	id1, _ := m.Add(NewUploadTask(0, "1", "/1", true))
+	id2, _ := m.Add(NewUploadTask(0, "2", "/2", true))
+	paused := NewUploadTask(0, "3", "/3", true)
+	paused.State = StatePaused
+	id3, _ := m.Add(paused)
+	m.Start()
+	<-r.started
+	waitState(t, m, id1, StateRunning)
+	m.Stop()
+
+	// 重启后正在传输的任务重新排队，暂停的任务保持暂停
+	r2 := newFakeRunner(false)
+	m2, err := newManager(r2, &Option{MaxConcurrent: 1, QueueFile: queueFile})
+	assert.Nil(t, err)
+	tasks := m2.Tasks()
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, []string{id1, id2, id3}, []string{tasks[0].Id, tasks[1].Id, tasks[2].Id})
+	assert.Equal(t, StateQueued, tasks[0].State)
+	assert.Equal(t, StateQueued, tasks[1].State)
+	assert.Equal(t, StatePaused, tasks[2].State)
+	assert.True(t, tasks[0].Overwrite)
+
+	id4, _ := m2.Add(NewUploadTask(0, "4", "/4", false))
+	m2.Start()
+	m2.Wait()
+	assert.Equal(t, []string{"1", "2", "4"}, r2.order)
+	task4, _ := m2.Task(id4)
+	assert.Equal(t, int64(4), task4.Seq)
+}
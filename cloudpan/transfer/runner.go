@@ -0,0 +1,278 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// progressFunc 进度回调，done 为已传输的字节数，total 为文件大小
+	progressFunc func(done, total int64)
+
+	// runner 执行单个传输任务，t 是任务的副本，返回云盘文件ID
+	runner interface {
+		run(ctx context.Context, t Task, progress progressFunc) (string, error)
+		// discard 清理取消的任务留下的临时文件
+		discard(t Task)
+	}
+
+	// panRunner 使用 PanClient 的上传下载接口执行任务，上传下载受 PanClient 的限速控制
+	panRunner struct {
+		client *cloudpan.PanClient
+	}
+
+	// ctxReader 在 ctx 结束后读取返回 ctx.Err()，用于中断正在进行的上传下载
+	ctxReader struct {
+		ctx context.Context
+		r   io.Reader
+	}
+
+	// progressReader 统计读取的字节数
+	progressReader struct {
+		r        io.Reader
+		done     int64
+		total    int64
+		progress progressFunc
+	}
+)
+
+const (
+	// DownloadTmpSuffix 下载中的临时文件后缀，下载完成后重命名，中断后从临时文件的大小继续下载
+	DownloadTmpSuffix = ".ctdownload"
+)
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.done += int64(n)
+		r.progress(r.done, r.total)
+	}
+	return n, err
+}
+
+func (r *panRunner) run(ctx context.Context, t Task, progress progressFunc) (string, error) {
+	if t.Kind == KindUpload {
+		return r.upload(ctx, t, progress)
+	}
+	return r.download(ctx, t, progress)
+}
+
+func (r *panRunner) discard(t Task) {
+	if t.Kind == KindDownload {
+		os.Remove(t.LocalPath + DownloadTmpSuffix)
+	}
+}
+
+// abortErr 传输被中断时返回 ctx.Err()，而不是 http 请求的错误
+func abortErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// localFileMd5 计算本地文件MD5，大写
+func localFileMd5(ctx context.Context, localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, &ctxReader{ctx: ctx, r: f}); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// uploadLocalFile 上传本地文件到云盘文件夹 parentId，old 为云盘上已存在的同名文件，返回新文件的ID。
+// overwrite=true 时覆盖 old，否则由云盘自动重命名
+func uploadLocalFile(ctx context.Context, client *cloudpan.PanClient, familyId int64, parentId, name, localPath, md5 string, old *cloudpan.AppFileEntity, overwrite bool, progress progressFunc) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+	progress(0, size)
+	reader := &progressReader{r: &ctxReader{ctx: ctx, r: f}, total: size, progress: progress}
+	result, apiErr := client.AppUploadFileFromReaderContext(ctx, &cloudpan.AppCreateUploadFileParam{
+		FamilyId: familyId,
+		ParentFolderId: parentId,
+		FileName: name,
+		Size: size,
+		Md5: md5,
+		LastWrite: apiutil.FormatTime(info.ModTime()),
+		LocalPath: localPath,
+	}, reader, overwrite)
+	if apiErr != nil {
+		return "", abortErr(ctx, apiErr)
+	}
+	if overwrite && old != nil && familyId > 0 {
+		// 家庭云上传不支持覆盖，新文件上传成功后再删除旧文件，上传失败时保留旧文件
+		if apiErr := client.AppFamilyReplaceFile(familyId, old, result.Id, result.Name); apiErr != nil {
+			return "", apiErr
+		}
+	}
+	// 秒传不会读取文件数据
+	progress(size, size)
+	return result.Id, nil
+}
+
+func (r *panRunner) upload(ctx context.Context, t Task, progress progressFunc) (string, error) {
+	if !path.IsAbs(t.PanPath) {
+		return "", errors.New("云盘文件路径必须是绝对路径")
+	}
+	info, err := os.Stat(t.LocalPath)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s 是文件夹", t.LocalPath)
+	}
+	md5, err := localFileMd5(ctx, t.LocalPath)
+	if err != nil {
+		return "", err
+	}
+
+	parent, apiErr := r.client.AppMkdirAll(t.FamilyId, path.Dir(t.PanPath))
+	if apiErr != nil {
+		return "", apiErr
+	}
+	var old *cloudpan.AppFileEntity
+	if t.Overwrite && t.FamilyId > 0 {
+		if old, apiErr = r.client.AppFileInfoByPath(t.FamilyId, t.PanPath); apiErr != nil {
+			if apiErr.Code != apierror.ApiCodeFileNotFoundCode {
+				return "", apiErr
+			}
+			old = nil
+		}
+	}
+	return uploadLocalFile(ctx, r.client, t.FamilyId, parent.FileId, path.Base(t.PanPath), t.LocalPath, md5, old, t.Overwrite, progress)
+}
+
+func (r *panRunner) download(ctx context.Context, t Task, progress progressFunc) (string, error) {
+	var fe *cloudpan.AppFileEntity
+	var apiErr *apierror.ApiError
+	if t.FileId != "" {
+		fe, apiErr = r.client.AppFileInfoById(t.FamilyId, t.FileId)
+	} else {
+		fe, apiErr = r.client.AppFileInfoByPath(t.FamilyId, t.PanPath)
+	}
+	if apiErr != nil {
+		return "", apiErr
+	}
+	if fe.IsFolder {
+		return "", fmt.Errorf("%s 是文件夹", fe.FileName)
+	}
+	if err := downloadPanFile(ctx, r.client, t.FamilyId, fe, t.LocalPath, progress); err != nil {
+		return "", err
+	}
+	return fe.FileId, nil
+}
+
+// downloadPanFile 下载云盘文件到 localPath，先写入临时文件，已有临时文件时从临时文件的大小继续下载，
+// 继续下载的文件完成后校验MD5。下载完成后把本地文件的修改时间设置为云盘文件的修改时间
+func downloadPanFile(ctx context.Context, client *cloudpan.PanClient, familyId int64, fe *cloudpan.AppFileEntity, localPath string, progress progressFunc) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := localPath + DownloadTmpSuffix
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err == nil && offset > fe.FileSize {
+		// 云盘文件已经变了，重新下载
+		if err = f.Truncate(0); err == nil {
+			offset, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	resumed := offset > 0
+
+	progress(offset, fe.FileSize)
+	if offset < fe.FileSize {
+		body, apiErr := client.AppDownloadFileReaderContext(ctx, familyId, fe.FileId, cloudpan.AppFileDownloadRange{Offset: offset})
+		if apiErr != nil {
+			f.Close()
+			return apiErr
+		}
+		reader := &progressReader{r: &ctxReader{ctx: ctx, r: body}, done: offset, total: fe.FileSize, progress: progress}
+		_, err = io.Copy(f, reader)
+		body.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return abortErr(ctx, err)
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != fe.FileSize {
+		// 服务器没有按区间返回数据
+		os.Remove(tmpPath)
+		return fmt.Errorf("下载的文件大小 %d 和云盘文件大小 %d 不一致", info.Size(), fe.FileSize)
+	}
+	if resumed && fe.FileMd5 != "" {
+		md5, err := localFileMd5(ctx, tmpPath)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(md5, fe.FileMd5) {
+			os.Remove(tmpPath)
+			return errors.New("继续下载的文件MD5校验失败，已删除临时文件")
+		}
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return err
+	}
+	if modTime := apiutil.ParseTimeStr(fe.LastOpTime); !modTime.IsZero() {
+		os.Chtimes(localPath, modTime, modTime)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"github.com/phpc0de/ctlibgo/jsonhelper"
+	"os"
+	"path/filepath"
+)
+
+type (
+	// queueFile 队列状态文件的内容
+	queueFile struct {
+		Seq   int64   `json:"seq"`
+		Tasks []*Task `json:"tasks"`
+	}
+)
+
+// loadQueue 加载队列状态文件，文件不存在返回空队列
+func loadQueue(queuePath string) (*queueFile, error) {
+	q := &queueFile{}
+	if queuePath == "" {
+		return q, nil
+	}
+	f, err := os.Open(queuePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return q, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := jsonhelper.UnmarshalData(f, q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// saveQueue 保存队列状态文件，先写入临时文件再替换，避免中断导致文件损坏
+func saveQueue(queuePath string, q *queueFile) error {
+	if queuePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(queuePath), 0755); err != nil {
+		return err
+	}
+	tmpPath := queuePath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := jsonhelper.MarshalData(f, q); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, queuePath)
+}
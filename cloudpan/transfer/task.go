@@ -0,0 +1,123 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transfer 上传下载任务队列，支持优先级、暂停/继续/取消、并发数控制、进度事件以及队列状态持久化，
+// 支持个人云和家庭云
+package transfer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type (
+	// Kind 任务类型
+	Kind string
+
+	// State 任务状态
+	State string
+
+	// Task 传输任务，字段由 Manager 维护，调用方通过 Manager.Task 获取副本
+	Task struct {
+		// Id 任务ID
+		Id string `json:"id"`
+		// Kind 任务类型
+		Kind Kind `json:"kind"`
+		// FamilyId 家庭云ID，个人云为0
+		FamilyId int64 `json:"familyId"`
+		// LocalPath 本地文件路径
+		LocalPath string `json:"localPath"`
+		// PanPath 云盘文件绝对路径
+		PanPath string `json:"panPath"`
+		// FileId 云盘文件ID，下载任务可以只指定 PanPath，上传完成后为新文件的ID
+		FileId string `json:"fileId"`
+		// Overwrite 上传时覆盖云盘上的同名文件
+		Overwrite bool `json:"overwrite"`
+		// Priority 优先级，数值大的先执行，相同优先级按添加顺序执行
+		Priority int `json:"priority"`
+		// State 任务状态
+		State State `json:"state"`
+		// Size 文件大小，开始传输后才有值
+		Size int64 `json:"size"`
+		// Transferred 已传输的字节数
+		Transferred int64 `json:"transferred"`
+		// Error 失败原因
+		Error string `json:"error"`
+		// Seq 添加顺序
+		Seq int64 `json:"seq"`
+		// CreateTime 添加时间，unix时间戳
+		CreateTime int64 `json:"createTime"`
+		// FinishTime 完成时间，unix时间戳
+		FinishTime int64 `json:"finishTime"`
+
+		// stopState 运行中的任务被中断后进入的状态
+		stopState State
+	}
+)
+
+const (
+	// KindUpload 上传
+	KindUpload Kind = "upload"
+	// KindDownload 下载
+	KindDownload Kind = "download"
+
+	// StateQueued 等待执行
+	StateQueued State = "queued"
+	// StateRunning 正在传输
+	StateRunning State = "running"
+	// StatePaused 已暂停
+	StatePaused State = "paused"
+	// StateCompleted 已完成
+	StateCompleted State = "completed"
+	// StateFailed 失败
+	StateFailed State = "failed"
+	// StateCanceled 已取消
+	StateCanceled State = "canceled"
+)
+
+// NewUploadTask 创建上传任务，把本地文件 localPath 上传为云盘文件 panPath，云盘文件夹不存在会自动创建
+func NewUploadTask(familyId int64, localPath, panPath string, overwrite bool) *Task {
+	return &Task{
+		Kind: KindUpload,
+		FamilyId: familyId,
+		LocalPath: localPath,
+		PanPath: panPath,
+		Overwrite: overwrite,
+	}
+}
+
+// NewDownloadTask 创建下载任务，把云盘文件下载到本地 localPath，fileId 为空时按 panPath 查找文件
+func NewDownloadTask(familyId int64, fileId, panPath, localPath string) *Task {
+	return &Task{
+		Kind: KindDownload,
+		FamilyId: familyId,
+		FileId: fileId,
+		PanPath: panPath,
+		LocalPath: localPath,
+	}
+}
+
+// IsFinished 任务是否已结束，已结束的任务不会再执行，除非调用 Manager.Resume 重试失败的任务
+func (t *Task) IsFinished() bool {
+	return t.State == StateCompleted || t.State == StateFailed || t.State == StateCanceled
+}
+
+func newTaskId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().Format("060102150405")))
+	}
+	return hex.EncodeToString(b)
+}
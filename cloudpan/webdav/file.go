@@ -0,0 +1,308 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webdav
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apiutil"
+	xwebdav "golang.org/x/net/webdav"
+	"hash"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// fileInfo 云盘文件信息，实现了 webdav.ContentTyper 和 webdav.ETager
+	fileInfo struct {
+		name    string
+		entity  *cloudpan.AppFileEntity
+		modTime time.Time
+	}
+
+	// dirFile 文件夹，第一次 Readdir 时获取文件列表，之后的 Readdir 在同一个列表上分页
+	dirFile struct {
+		fs      *FileSystem
+		panPath string
+		info    *fileInfo
+		list    cloudpan.AppFileList
+		offset  int
+	}
+
+	// readFile 只读文件，Seek 后会从新的位置重新打开下载数据流
+	readFile struct {
+		fs     *FileSystem
+		info   *fileInfo
+		offset int64
+		rc     io.ReadCloser
+	}
+
+	// writeFile 写入的数据暂存到本地临时文件，Close 时上传到云盘。
+	// 云盘上传前需要知道文件的大小和MD5，所以 PUT 的数据必须先完整写入临时文件，TempDir 需要有足够的空间
+	writeFile struct {
+		fs      *FileSystem
+		panPath string
+		parent  *cloudpan.AppFileEntity
+		old     *cloudpan.AppFileEntity
+		tmp     *os.File
+		md5     hash.Hash
+		size    int64
+		closed  bool
+	}
+)
+
+var (
+	errIsDirectory = errors.New("is a directory")
+	errNotDirectory = errors.New("not a directory")
+)
+
+func newFileInfo(fe *cloudpan.AppFileEntity, name string) *fileInfo {
+	return &fileInfo{
+		name: name,
+		entity: fe,
+		modTime: apiutil.ParseTimeStr(fe.LastOpTime),
+	}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	if fi.entity.IsFolder {
+		return 0
+	}
+	return fi.entity.FileSize
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.entity.IsFolder {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.entity.IsFolder
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return fi.entity
+}
+
+// ContentType 根据文件后缀判断文件类型，避免为了探测类型而下载文件数据
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.entity.IsFolder {
+		return "", xwebdav.ErrNotImplemented
+	}
+	if ct := mime.TypeByExtension(path.Ext(fi.name)); ct != "" {
+		return ct, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// ETag 使用文件MD5作为ETag
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.entity.IsFolder || fi.entity.FileMd5 == "" {
+		return "", xwebdav.ErrNotImplemented
+	}
+	return fmt.Sprintf(`"%s"`, strings.ToLower(fi.entity.FileMd5)), nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, errIsDirectory
+}
+
+func (d *dirFile) Write([]byte) (int, error) {
+	return 0, errIsDirectory
+}
+
+func (d *dirFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errIsDirectory
+}
+
+func (d *dirFile) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+// Readdir 获取文件夹下的文件列表，count>0 时分页返回
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if d.list == nil {
+		list, err := d.fs.list(d.panPath)
+		if err != nil {
+			return nil, err
+		}
+		d.list = list
+	}
+	remain := d.list[d.offset:]
+	if count > 0 {
+		if len(remain) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(remain) {
+			remain = remain[:count]
+		}
+	}
+	d.offset += len(remain)
+	infos := make([]os.FileInfo, 0, len(remain))
+	for _, fe := range remain {
+		infos = append(infos, newFileInfo(fe, fe.FileName))
+	}
+	return infos, nil
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	size := f.info.Size()
+	if f.offset >= size {
+		return 0, io.EOF
+	}
+	if f.rc == nil {
+		rc, err := f.fs.backend.Open(f.fs.familyId, f.info.entity.FileId, f.offset, -1)
+		if err != nil {
+			return 0, convertError(err)
+		}
+		f.rc = rc
+	}
+	n, err := f.rc.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *readFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// Seek 位置改变后关闭当前数据流，下一次读取时从新位置开始下载
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.info.Size() + offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if abs < 0 {
+		return 0, os.ErrInvalid
+	}
+	if abs != f.offset {
+		f.closeStream()
+		f.offset = abs
+	}
+	return abs, nil
+}
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errNotDirectory
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *readFile) closeStream() {
+	if f.rc != nil {
+		f.rc.Close()
+		f.rc = nil
+	}
+}
+
+func (f *readFile) Close() error {
+	f.closeStream()
+	return nil
+}
+
+func newWriteFile(fs *FileSystem, panPath string, parent, old *cloudpan.AppFileEntity, tmp *os.File) *writeFile {
+	return &writeFile{
+		fs: fs,
+		panPath: panPath,
+		parent: parent,
+		old: old,
+		tmp: tmp,
+		md5: md5.New(),
+	}
+}
+
+func (f *writeFile) Read([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// Write 写入数据到临时文件，同时计算MD5
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.tmp.Write(p)
+	f.md5.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+// Seek 只支持获取当前位置，数据只能顺序写入
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	if (whence == io.SeekCurrent && offset == 0) || (whence == io.SeekStart && offset == f.size) {
+		return f.size, nil
+	}
+	return 0, os.ErrInvalid
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errNotDirectory
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	return newFileInfo(&cloudpan.AppFileEntity{
+		FileName: path.Base(f.panPath),
+		FileSize: f.size,
+		FileMd5: hex.EncodeToString(f.md5.Sum(nil)),
+		LastOpTime: apiutil.FormatTime(time.Now()),
+	}, path.Base(f.panPath)), nil
+}
+
+// Close 上传临时文件到云盘，个人云覆盖同名文件，家庭云上传成功后再替换旧文件
+func (f *writeFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	defer func() {
+		f.tmp.Close()
+		os.Remove(f.tmp.Name())
+	}()
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := f.fs.backend.Upload(f.fs.familyId, f.fs.folderId(f.parent), path.Base(f.panPath), f.size,
+		strings.ToUpper(hex.EncodeToString(f.md5.Sum(nil))), f.tmp, f.old)
+	f.fs.invalidate(path.Dir(f.panPath))
+	return convertError(err)
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webdav
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctapi/cloudpan/apierror"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	xwebdav "golang.org/x/net/webdav"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	cacheItem struct {
+		entity   *cloudpan.AppFileEntity
+		children cloudpan.AppFileList
+		expireAt time.Time
+	}
+
+	// FileSystem 基于云盘实现的 webdav.FileSystem，支持个人云和家庭云
+	FileSystem struct {
+		backend  panbackend.Backend
+		familyId int64
+		root     string
+
+		// CacheTTL 文件夹列表缓存时长
+		CacheTTL time.Duration
+		// TempDir 上传文件时暂存数据的本地目录，为空使用系统临时目录
+		TempDir string
+
+		cache     map[string]*cacheItem
+		cacheLock sync.Mutex
+	}
+)
+
+const (
+	// DefaultCacheTTL 默认的文件夹列表缓存时长
+	DefaultCacheTTL = 10 * time.Second
+)
+
+// NewFileSystem 创建云盘 webdav 文件系统，root 为云盘中作为根目录的绝对路径，familyId<=0 为个人云
+func NewFileSystem(client *cloudpan.PanClient, familyId int64, root string) *FileSystem {
+	return newFileSystem(panbackend.NewClient(client), familyId, root)
+}
+
+func newFileSystem(b panbackend.Backend, familyId int64, root string) *FileSystem {
+	if root == "" {
+		root = "/"
+	}
+	return &FileSystem{
+		backend: b,
+		familyId: familyId,
+		root: path.Clean(root),
+		CacheTTL: DefaultCacheTTL,
+		cache: map[string]*cacheItem{},
+	}
+}
+
+// panPath 将 webdav 路径转换为云盘绝对路径
+func (fs *FileSystem) panPath(name string) string {
+	return path.Join(fs.root, path.Clean("/"+name))
+}
+
+// convertError 将云盘的错误转换为 os 包的错误
+func convertError(err error) error {
+	if apiErr, ok := err.(*apierror.ApiError); ok {
+		switch apiErr.Code {
+		case apierror.ApiCodeFileNotFoundCode:
+			return os.ErrNotExist
+		case apierror.ApiCodeFileAlreadyExisted:
+			return os.ErrExist
+		}
+	}
+	return err
+}
+
+// folderId 家庭云根目录的文件夹ID为空
+func (fs *FileSystem) folderId(fe *cloudpan.AppFileEntity) string {
+	if fs.familyId > 0 && fe.FileId == cloudpan.NewAppFileEntityForRootDir().FileId {
+		return ""
+	}
+	return fe.FileId
+}
+
+func (fs *FileSystem) invalidate(panPath string) {
+	fs.cacheLock.Lock()
+	defer fs.cacheLock.Unlock()
+	for p := range fs.cache {
+		if p == panPath || strings.HasPrefix(p, panPath+"/") || panPath == "/" {
+			delete(fs.cache, p)
+		}
+	}
+}
+
+// stat 获取云盘文件信息，父文件夹的列表会被缓存
+func (fs *FileSystem) stat(panPath string) (*cloudpan.AppFileEntity, error) {
+	if panPath == "/" {
+		return cloudpan.NewAppFileEntityForRootDir(), nil
+	}
+	fs.cacheLock.Lock()
+	item, ok := fs.cache[panPath]
+	fs.cacheLock.Unlock()
+	if ok && time.Now().Before(item.expireAt) && item.entity != nil {
+		return item.entity, nil
+	}
+
+	parentPath := path.Dir(panPath)
+	children, err := fs.list(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(panPath)
+	for _, fe := range children {
+		if fe.FileName == name {
+			return fe, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// list 获取云盘文件夹下的文件列表
+func (fs *FileSystem) list(panPath string) (cloudpan.AppFileList, error) {
+	fs.cacheLock.Lock()
+	item, ok := fs.cache[panPath]
+	fs.cacheLock.Unlock()
+	if ok && time.Now().Before(item.expireAt) && item.children != nil {
+		return item.children, nil
+	}
+
+	dir, err := fs.stat(panPath)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsFolder {
+		return nil, os.ErrInvalid
+	}
+	list, err := fs.backend.List(fs.familyId, dir.FileId)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	expireAt := time.Now().Add(fs.CacheTTL)
+	fs.cacheLock.Lock()
+	defer fs.cacheLock.Unlock()
+	fs.cache[panPath] = &cacheItem{entity: dir, children: list, expireAt: expireAt}
+	for _, fe := range list {
+		childPath := path.Join(panPath, fe.FileName)
+		if c, ok := fs.cache[childPath]; ok {
+			c.entity = fe
+		} else {
+			fs.cache[childPath] = &cacheItem{entity: fe, expireAt: expireAt}
+		}
+	}
+	return list, nil
+}
+
+// Mkdir 创建文件夹
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	panPath := fs.panPath(name)
+	if _, err := fs.stat(panPath); err == nil {
+		return os.ErrExist
+	}
+	parent, err := fs.stat(path.Dir(panPath))
+	if err != nil {
+		return err
+	}
+	if !parent.IsFolder {
+		return os.ErrInvalid
+	}
+	if err := fs.backend.Mkdir(fs.familyId, fs.folderId(parent), path.Base(panPath)); err != nil {
+		return convertError(err)
+	}
+	fs.invalidate(path.Dir(panPath))
+	return nil
+}
+
+// OpenFile 打开文件，写模式下数据先暂存到本地临时文件，关闭时上传
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (xwebdav.File, error) {
+	panPath := fs.panPath(name)
+	fe, err := fs.stat(panPath)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		if fe.IsFolder {
+			return &dirFile{fs: fs, panPath: panPath, info: newFileInfo(fe, path.Base(panPath))}, nil
+		}
+		return &readFile{fs: fs, info: newFileInfo(fe, path.Base(panPath))}, nil
+	}
+
+	// 写模式
+	if err != nil && err != os.ErrNotExist {
+		return nil, err
+	}
+	if err == nil {
+		if fe.IsFolder {
+			return nil, os.ErrInvalid
+		}
+		if flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+	} else if flag&os.O_CREATE == 0 {
+		return nil, os.ErrNotExist
+	}
+	parent, err := fs.stat(path.Dir(panPath))
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(fs.TempDir, "ctapi-webdav-")
+	if err != nil {
+		return nil, err
+	}
+	return newWriteFile(fs, panPath, parent, fe, tmp), nil
+}
+
+// RemoveAll 删除文件或文件夹，删除的文件会进入回收站
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	panPath := fs.panPath(name)
+	if panPath == fs.root {
+		return os.ErrPermission
+	}
+	fe, err := fs.stat(panPath)
+	if err != nil {
+		return err
+	}
+	if err := fs.backend.Remove(fs.familyId, fe); err != nil {
+		return convertError(err)
+	}
+	fs.invalidate(panPath)
+	fs.invalidate(path.Dir(panPath))
+	return nil
+}
+
+// Rename 重命名或移动文件/文件夹
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath := fs.panPath(oldName)
+	newPath := fs.panPath(newName)
+	if oldPath == fs.root || newPath == fs.root {
+		return os.ErrPermission
+	}
+	fe, err := fs.stat(oldPath)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.stat(newPath); err == nil {
+		return os.ErrExist
+	}
+
+	if path.Dir(oldPath) != path.Dir(newPath) {
+		target, err := fs.stat(path.Dir(newPath))
+		if err != nil {
+			return err
+		}
+		if !target.IsFolder {
+			return os.ErrInvalid
+		}
+		if err := fs.backend.Move(fs.familyId, fe, fs.folderId(target)); err != nil {
+			return convertError(err)
+		}
+	}
+	if path.Base(oldPath) != path.Base(newPath) {
+		if err := fs.backend.Rename(fs.familyId, fe, path.Base(newPath)); err != nil {
+			return convertError(err)
+		}
+	}
+	fs.invalidate(oldPath)
+	fs.invalidate(path.Dir(oldPath))
+	fs.invalidate(path.Dir(newPath))
+	return nil
+}
+
+// Stat 获取文件信息
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	panPath := fs.panPath(name)
+	fe, err := fs.stat(panPath)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(fe, path.Base(panPath)), nil
+}
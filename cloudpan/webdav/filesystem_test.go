@@ -0,0 +1,114 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webdav
+
+import (
+	"context"
+	"github.com/phpc0de/ctapi/cloudpan/internal/panbackend"
+	"github.com/stretchr/testify/assert"
+	xwebdav "golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDirFileReaddir(t *testing.T) {
+	mem := panbackend.NewMemory()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		mem.Add(0, "/"+name, []byte(name))
+	}
+	fs := newFileSystem(mem, 0, "/")
+	fs.CacheTTL = 0
+	ctx := context.Background()
+
+	f, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	infos, err := f.Readdir(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(infos))
+
+	// 分页过程中文件夹变小不会越界，仍然在打开时的列表上分页
+	for _, name := range []string{"b", "c", "d", "e"} {
+		fe, err := mem.Stat(0, "/"+name)
+		assert.Nil(t, err)
+		assert.Nil(t, mem.Remove(0, fe))
+	}
+	infos, err = f.Readdir(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(infos))
+	infos, err = f.Readdir(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+	_, err = f.Readdir(2)
+	assert.Equal(t, io.EOF, err)
+	infos, err = f.Readdir(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(infos))
+	assert.Nil(t, f.Close())
+
+	// 重新打开获取最新的列表
+	f, err = fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	infos, err = f.Readdir(-1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+}
+
+func TestPut(t *testing.T) {
+	mem := panbackend.NewMemory()
+	docsId, _ := mem.MkdirAll(0, "/docs")
+	tempDir, err := ioutil.TempDir("", "webdav")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	fs := newFileSystem(mem, 0, "/")
+	fs.TempDir = tempDir
+	h := &xwebdav.Handler{FileSystem: fs, LockSystem: xwebdav.NewMemLS()}
+
+	put := func(target, body string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, target, strings.NewReader(body)))
+		return w.Code
+	}
+	get := func(target string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Code, w.Body.String()
+	}
+
+	assert.Equal(t, http.StatusCreated, put("/docs/a.txt", "hello"))
+	code, body := get("/docs/a.txt")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello", body)
+
+	// 覆盖已存在的文件
+	assert.Equal(t, http.StatusCreated, put("/docs/a.txt", "hello world"))
+	code, body = get("/docs/a.txt")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello world", body)
+	list, _ := mem.List(0, docsId)
+	assert.Equal(t, 1, len(list))
+
+	// 上级文件夹不存在
+	assert.Equal(t, http.StatusNotFound, put("/none/a.txt", "x"))
+
+	// 临时文件上传后被删除
+	files, err := ioutil.ReadDir(tempDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webdav 基于云盘实现的 WebDAV 服务，可以在文件管理器或办公软件中挂载云盘
+package webdav
+
+import (
+	"crypto/subtle"
+	"github.com/phpc0de/ctapi/cloudpan"
+	"github.com/phpc0de/ctlibgo/logger"
+	xwebdav "golang.org/x/net/webdav"
+	"net/http"
+)
+
+type (
+	// User WebDAV 用户，通过 basic auth 认证后使用对应的云盘登录凭证
+	User struct {
+		Username string
+		Password string
+		WebToken cloudpan.WebLoginToken
+		AppToken cloudpan.AppLoginToken
+		// FamilyId 大于0则挂载家庭云，否则挂载个人云
+		FamilyId int64
+		// Root 作为 WebDAV 根目录的云盘路径，为空则为云盘根目录
+		Root string
+	}
+
+	// Handler WebDAV 服务的 http.Handler
+	Handler struct {
+		// Realm basic auth 的认证域
+		Realm string
+
+		users    map[string]*User
+		handlers map[string]*xwebdav.Handler
+	}
+)
+
+// NewHandler 创建 WebDAV 服务，prefix 为 URL 路径前缀，每个用户使用独立的 PanClient
+func NewHandler(prefix string, users []*User) *Handler {
+	h := &Handler{
+		Realm: "cloudpan",
+		users: map[string]*User{},
+		handlers: map[string]*xwebdav.Handler{},
+	}
+	for _, u := range users {
+		client := cloudpan.NewPanClient(u.WebToken, u.AppToken)
+		h.users[u.Username] = u
+		h.handlers[u.Username] = &xwebdav.Handler{
+			Prefix: prefix,
+			FileSystem: NewFileSystem(client, u.FamilyId, u.Root),
+			LockSystem: xwebdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					logger.Verboseln("webdav ", r.Method, " ", r.URL.Path, " error: ", err)
+				}
+			},
+		}
+	}
+	return h
+}
+
+// ServeHTTP 实现 http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if ok {
+		if u, exist := h.users[username]; exist && subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1 {
+			h.handlers[username].ServeHTTP(w, r)
+			return
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+h.Realm+`"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
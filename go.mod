@@ -1,12 +1,23 @@
 module github.com/phpc0de/ctapi
 
-go 1.12
+go 1.17
 
 require (
 	github.com/json-iterator/go v1.1.10
+	github.com/phpc0de/ctlibgo v0.0.5
 	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.6.1
-	github.com/phpc0de/ctlibgo v0.0.5
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/net v0.11.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
+	github.com/modern-go/reflect2 v0.0.0-20180701023420-4b7aa43c6742 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.9.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
 
 //replace github.com/phpc0de/ctlibgo => /Users/tickstep/Documents/Workspace/go/projects/library-go